@@ -0,0 +1,216 @@
+/* GoTheora
+Wrapper for Theora library
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package gotheora
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* Chapters.
+   The chapters are stored in the comments as pairs
+   CHAPTER001=00:00:00.000 and CHAPTER001NAME=Name, numbered from 001
+   in the order of their start times */
+
+const (
+	chapterTagPrefix = "CHAPTER"
+	chapterTagName   = "NAME"
+)
+
+// Chapter is a named section of the stream
+type Chapter struct {
+	Start time.Duration
+	Name  string
+}
+
+// Chapters is a list of chapters ordered by their start times
+type Chapters []Chapter
+
+func formatChapterTime(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d",
+		ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// parseChapterTime parses [[HH:]MM:]SS[.sss]
+func parseChapterTime(s string) (time.Duration, bool) {
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, false
+	}
+	sec, err := strconv.ParseFloat(parts[len(parts)-1], 64)
+	if err != nil || sec < 0 {
+		return 0, false
+	}
+	res := time.Duration(sec*float64(time.Second) + 0.5)
+	mul := time.Minute
+	for i := len(parts) - 2; i >= 0; i-- {
+		n, err := strconv.ParseUint(parts[i], 10, 32)
+		if err != nil {
+			return 0, false
+		}
+		res += time.Duration(n) * mul
+		mul *= 60
+	}
+	return res, true
+}
+
+// parseChapterTag splits CHAPTERxxx[NAME] into the number and the
+// suffix
+func parseChapterTag(tag string) (num int, suffix string, ok bool) {
+	if len(tag) <= len(chapterTagPrefix) ||
+		!fieldNameEqual(tag[:len(chapterTagPrefix)], chapterTagPrefix) {
+		return 0, "", false
+	}
+	tag = tag[len(chapterTagPrefix):]
+	n := 0
+	for n < len(tag) && tag[n] >= '0' && tag[n] <= '9' {
+		n++
+	}
+	if n == 0 {
+		return 0, "", false
+	}
+	num, err := strconv.Atoi(tag[:n])
+	if err != nil {
+		return 0, "", false
+	}
+	return num, strings.ToUpper(tag[n:]), true
+}
+
+// Sorted returns a copy of the chapters ordered by their start times
+func (c Chapters) Sorted() Chapters {
+	res := append(Chapters(nil), c...)
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Start < res[j].Start
+	})
+	return res
+}
+
+// RemoveChapters deletes all the chapter comments of tc
+func RemoveChapters(tc ITheoraComment) {
+	for name := range tc.Tags() {
+		if _, suffix, ok := parseChapterTag(name); ok &&
+			(suffix == "" || suffix == chapterTagName) {
+			tc.Remove(name)
+		}
+	}
+}
+
+// AssignToComment replaces the chapter comments of tc with the
+// chapters, numbered from 001 in the order of their start times
+func (c Chapters) AssignToComment(tc ITheoraComment) error {
+	RemoveChapters(tc)
+	for i, ch := range c.Sorted() {
+		tag := fmt.Sprintf("%s%03d", chapterTagPrefix, i+1)
+		err := tc.AddTagChecked(tag, formatChapterTime(ch.Start))
+		if err != nil {
+			return err
+		}
+		if len(ch.Name) > 0 {
+			err = tc.AddTagChecked(tag+chapterTagName, ch.Name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ChaptersFromComment parses the chapter comments of tc. The chapters
+// are returned in the order of their numbers, the chapters with
+// invalid start times are skipped
+func ChaptersFromComment(tc ITheoraComment) Chapters {
+	starts := make(map[int]time.Duration)
+	names := make(map[int]string)
+	for name, values := range tc.Tags() {
+		num, suffix, ok := parseChapterTag(name)
+		if !ok || len(values) == 0 {
+			continue
+		}
+		switch suffix {
+		case "":
+			if d, ok := parseChapterTime(strings.TrimSpace(values[0])); ok {
+				starts[num] = d
+			}
+		case chapterTagName:
+			names[num] = values[0]
+		}
+	}
+
+	nums := make([]int, 0, len(starts))
+	for num := range starts {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+	res := make(Chapters, 0, len(nums))
+	for _, num := range nums {
+		res = append(res, Chapter{Start: starts[num], Name: names[num]})
+	}
+	return res
+}
+
+/* Forced keyframes.
+   The encoder has no request to code the next frame as a keyframe, so
+   the maximal keyframe distance is set to 1 for this frame and
+   restored after it */
+
+// ForceKeyframe makes the encoder code the next frame as a keyframe
+func (v *TheoraEncoder) ForceKeyframe() {
+	v.fForceKF = true
+}
+
+// SetChapters makes the encoder code the first frame of each chapter
+// as a keyframe, so the players can seek to the chapters exactly. The
+// chapters are not written into the comments, use AssignToComment
+func (v *TheoraEncoder) SetChapters(c Chapters) {
+	inf := v.fState.Info()
+	v.fChapterFrames = v.fChapterFrames[:0]
+	if inf.GetFPSNumerator() <= 0 || inf.GetFPSDenominator() <= 0 {
+		return
+	}
+	num := int64(inf.GetFPSNumerator())
+	den := int64(inf.GetFPSDenominator()) * int64(time.Second)
+	for _, ch := range c.Sorted() {
+		/* the first frame shown at the chapter start or later */
+		frame := (int64(ch.Start)*num + den - 1) / den
+		if frame >= v.fFrames {
+			v.fChapterFrames = append(v.fChapterFrames, frame)
+		}
+	}
+}
+
+// beginKeyframe forces a keyframe if it is requested for the next
+// frame. Returns true if the keyframe distance has to be restored
+func (v *TheoraEncoder) beginKeyframe() bool {
+	force := v.fForceKF
+	for len(v.fChapterFrames) > 0 && v.fChapterFrames[0] <= v.fFrames {
+		force = true
+		v.fChapterFrames = v.fChapterFrames[1:]
+	}
+	v.fForceKF = false
+	if !force || v.fFrames == 0 {
+		return false
+	}
+	_, err := v.setKeyframeFrequencyForce(1)
+	return err == nil
+}
+
+func (v *TheoraEncoder) endKeyframe(restore bool) {
+	if restore {
+		v.setKeyframeFrequencyForce(v.fKFForce)
+	}
+}
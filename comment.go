@@ -0,0 +1,159 @@
+/* GoTheora
+Wrapper for Theora library
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package gotheora
+
+/*
+#cgo CFLAGS: -I/usr/include
+#include "theora/theora.h"
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"strings"
+	"unicode/utf8"
+	"unsafe"
+)
+
+/* Vorbis comment rules.
+   A comment is "FIELD=value". The field name consists of the ASCII
+   characters 0x20 through 0x7D except '=' and is compared case
+   insensitively. The value is UTF-8 */
+
+func validFieldName(tag string) bool {
+	if len(tag) == 0 {
+		return false
+	}
+	for i := 0; i < len(tag); i++ {
+		if tag[i] < 0x20 || tag[i] > 0x7d || tag[i] == '=' {
+			return false
+		}
+	}
+	return true
+}
+
+func validateComment(tag, value string) error {
+	if !validFieldName(tag) || !utf8.ValidString(value) ||
+		strings.IndexByte(value, 0) >= 0 {
+		return ETheoraInvalidCommentException
+	}
+	return nil
+}
+
+func splitComment(comment string) (tag, value string, ok bool) {
+	return strings.Cut(comment, "=")
+}
+
+// fieldNameEqual compares the field names ignoring the case of the
+// ASCII letters
+func fieldNameEqual(a, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		ca, cb := a[i], b[i]
+		if 'a' <= ca && ca <= 'z' {
+			ca -= 'a' - 'A'
+		}
+		if 'a' <= cb && cb <= 'z' {
+			cb -= 'a' - 'A'
+		}
+		if ca != cb {
+			return false
+		}
+	}
+	return true
+}
+
+func commentHasTag(comment, tag string) bool {
+	name, _, ok := splitComment(comment)
+	return ok && fieldNameEqual(name, tag)
+}
+
+// Tags returns all the comments grouped by the field names, which
+// are converted to upper case. The values of each field keep their
+// order. Comments without '=' are returned under the empty name
+func (v *TheoraComment) Tags() map[string][]string {
+	res := make(map[string][]string)
+	for i := 0; i < v.TagsCount(); i++ {
+		name, value, ok := splitComment(v.GetTag(i))
+		if !ok {
+			name, value = "", name
+		}
+		name = strings.ToUpper(name)
+		res[name] = append(res[name], value)
+	}
+	return res
+}
+
+// removeIf deletes the comments for which del returns true and
+// returns their count
+func (v *TheoraComment) removeIf(del func(index int, comment string) bool) int {
+	cnt := v.TagsCount()
+	if cnt == 0 {
+		return 0
+	}
+	comments := unsafe.Slice(v.Ref().user_comments, cnt)
+	lengths := unsafe.Slice(v.Ref().comment_lengths, cnt)
+	n := 0
+	for i := 0; i < cnt; i++ {
+		if del(i, C.GoStringN(comments[i], lengths[i])) {
+			C.free(unsafe.Pointer(comments[i]))
+			continue
+		}
+		comments[n], lengths[n] = comments[i], lengths[i]
+		n++
+	}
+	for i := n; i < cnt; i++ {
+		comments[i], lengths[i] = nil, 0
+	}
+	v.Ref().comments = C.int(n)
+	return cnt - n
+}
+
+// Remove deletes all the comments with the field name tag and returns
+// their count
+func (v *TheoraComment) Remove(tag string) int {
+	return v.removeIf(func(_ int, comment string) bool {
+		return commentHasTag(comment, tag)
+	})
+}
+
+// Replace sets the only value of the field tag. The first comment
+// with this field name is replaced in place, the others are deleted.
+// If there is no such comment, a new one is appended
+func (v *TheoraComment) Replace(tag, value string) error {
+	if err := validateComment(tag, value); err != nil {
+		return err
+	}
+	first := -1
+	for i := 0; i < v.TagsCount(); i++ {
+		if commentHasTag(v.GetTag(i), tag) {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		v.AddTag(tag, value)
+		return nil
+	}
+
+	comment := tag + "=" + value
+	comments := unsafe.Slice(v.Ref().user_comments, v.TagsCount())
+	lengths := unsafe.Slice(v.Ref().comment_lengths, v.TagsCount())
+	C.free(unsafe.Pointer(comments[first]))
+	comments[first] = C.CString(comment)
+	lengths[first] = C.int(len(comment))
+
+	v.removeIf(func(index int, comment string) bool {
+		return index != first && commentHasTag(comment, tag)
+	})
+	return nil
+}
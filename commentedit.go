@@ -0,0 +1,222 @@
+/* GoTheora
+Wrapper for Theora library
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package gotheora
+
+/*
+#cgo CFLAGS: -I/usr/include
+#include "ogg/ogg.h"
+#include "theora/theora.h"
+*/
+import "C"
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	OGG "github.com/ilya2ik/googg"
+)
+
+/* Comment header rewriting.
+   The pages of the first theora stream holding the comment and the
+   setup headers are replaced by the pages built from the new comment
+   header and the original setup header. All the following pages of
+   this stream are copied with the shifted sequence numbers and
+   recalculated CRCs, the pages of the other streams are copied as
+   they are */
+
+const (
+	theoraHeaderInfo    = 0x80
+	theoraHeaderComment = 0x81
+	theoraHeaderSetup   = 0x82
+)
+
+func isTheoraHeader(packet []byte, kind byte) bool {
+	return len(packet) >= 7 && packet[0] == kind && string(packet[1:7]) == "theora"
+}
+
+// buildCommentPacket returns the theora comment header packet
+// holding the vendor string and all the comments of tc
+func buildCommentPacket(vendor string, tc ITheoraComment) []byte {
+	var buf bytes.Buffer
+	var le [4]byte
+	putString := func(s string) {
+		binary.LittleEndian.PutUint32(le[:], uint32(len(s)))
+		buf.Write(le[:])
+		buf.WriteString(s)
+	}
+
+	buf.WriteByte(theoraHeaderComment)
+	buf.WriteString("theora")
+	putString(vendor)
+	binary.LittleEndian.PutUint32(le[:], uint32(tc.TagsCount()))
+	buf.Write(le[:])
+	for i := 0; i < tc.TagsCount(); i++ {
+		putString(tc.GetTag(i))
+	}
+	return buf.Bytes()
+}
+
+// commentPacketVendor returns the vendor string of a comment header
+// packet
+func commentPacketVendor(packet []byte) string {
+	if len(packet) < 11 {
+		return ""
+	}
+	n := binary.LittleEndian.Uint32(packet[7:11])
+	if uint64(n) > uint64(len(packet)-11) {
+		return ""
+	}
+	return string(packet[11 : 11+n])
+}
+
+type commentRewriter struct {
+	fsync   *oggSyncState
+	fstream *oggStreamState
+	fpacket OGG.IOGGPacket
+	fserial int32
+	fheader [][]byte
+	fdone   bool
+	fdelta  int64
+}
+
+// RewriteComment copies the physical ogg stream from str to out and
+// replaces the comment header of the first theora stream with the
+// comments of tc. If tc has no vendor string, the original one is
+// kept. The video packets and the other logical streams are copied
+// bit-exact. The headers of the theora stream must end on a page
+// boundary as the specification requires
+func RewriteComment(str io.Reader, out io.Writer, tc ITheoraComment) error {
+	var err error
+	v := new(commentRewriter)
+	v.fsync, err = newOggSyncState()
+	if err != nil {
+		return err
+	}
+	defer v.fsync.Done()
+	v.fpacket, err = OGG.NewPacket()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if v.fstream != nil {
+			v.fstream.Done()
+		}
+	}()
+
+	var og C.ogg_page
+	for {
+		err = v.fsync.ReadPage(str, &og)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		err = v.page(&og, out, tc)
+		if err != nil {
+			return err
+		}
+	}
+
+	if v.fstream == nil {
+		return ETheoraNoStreamException
+	}
+	if !v.fdone {
+		return newError("RewriteComment", C.OC_BADHEADER)
+	}
+	return nil
+}
+
+func (v *commentRewriter) page(og *C.ogg_page, out io.Writer, tc ITheoraComment) error {
+	switch {
+	case v.fstream == nil && oggPageBOS(og):
+		stream, err := newOggStreamState(oggPageSerialNo(og))
+		if err != nil {
+			return err
+		}
+		if stream.PageIn(og) && stream.PacketOut(v.fpacket) > 0 &&
+			isTheoraHeader(oggPacketBytes(v.fpacket), theoraHeaderInfo) {
+			/* the identification header must be alone on its page */
+			if stream.PacketPeek(v.fpacket) != 0 || !oggPageEndsPacket(og) {
+				stream.Done()
+				return newError("RewriteComment", C.OC_IMPL)
+			}
+			v.fstream = stream
+			v.fserial = oggPageSerialNo(og)
+			v.fheader = append(v.fheader, bytes.Clone(oggPacketBytes(v.fpacket)))
+		} else {
+			stream.Done()
+		}
+	case v.fstream != nil && oggPageSerialNo(og) == v.fserial:
+		if v.fdone {
+			if v.fdelta != 0 {
+				oggPageSetPageNo(og, oggPagePageNo(og)+v.fdelta)
+			}
+			break
+		}
+		return v.headerPage(og, out, tc)
+	}
+	return oggPageWrite(out, og)
+}
+
+// headerPage collects the comment and the setup headers. The original
+// pages are dropped, the new ones are written after the last of them
+func (v *commentRewriter) headerPage(og *C.ogg_page, out io.Writer, tc ITheoraComment) error {
+	if !v.fstream.PageIn(og) {
+		return newError("RewriteComment", C.OC_BADHEADER)
+	}
+	for len(v.fheader) < 3 {
+		res := v.fstream.PacketOut(v.fpacket)
+		if res == 0 {
+			return nil
+		}
+		if res < 0 {
+			return newError("RewriteComment", C.OC_BADHEADER)
+		}
+		v.fheader = append(v.fheader, bytes.Clone(oggPacketBytes(v.fpacket)))
+	}
+	if !isTheoraHeader(v.fheader[1], theoraHeaderComment) ||
+		!isTheoraHeader(v.fheader[2], theoraHeaderSetup) {
+		return newError("RewriteComment", C.OC_BADHEADER)
+	}
+	if v.fstream.PacketPeek(v.fpacket) != 0 || !oggPageEndsPacket(og) {
+		/* the first video packet shares the page with the headers */
+		return newError("RewriteComment", C.OC_IMPL)
+	}
+
+	vendor := tc.GetVendor()
+	if len(vendor) == 0 {
+		vendor = commentPacketVendor(v.fheader[1])
+	}
+
+	mux, err := newOggStreamState(v.fserial)
+	if err != nil {
+		return err
+	}
+	defer mux.Done()
+
+	var np C.ogg_page
+	/* the identification page has been copied already */
+	mux.PacketIn(v.fheader[0], true, false, 0, 0)
+	mux.Flush(&np)
+	mux.PacketIn(buildCommentPacket(vendor, tc), false, false, 0, 1)
+	mux.PacketIn(v.fheader[2], false, false, 0, 2)
+	pageno := oggPagePageNo(og)
+	for mux.Flush(&np) {
+		err = oggPageWrite(out, &np)
+		if err != nil {
+			return err
+		}
+		v.fdelta = oggPagePageNo(&np) - pageno
+	}
+	v.fdone = true
+	return nil
+}
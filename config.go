@@ -0,0 +1,325 @@
+/* GoTheora
+Wrapper for Theora library
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package gotheora
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+)
+
+/* Encoder configuration */
+
+// Limits of the header fields
+const (
+	maxFrameSize      = 0xFFFF0
+	maxBitrate        = 0xFFFFFF
+	maxAspect         = 0xFFFFFF
+	maxQuality        = 63
+	maxSharpness      = 2
+	maxKeyframeThresh = 100
+)
+
+// EncoderConfig holds the settings of an encoder. The defaults are
+// set by NewEncoderConfig
+type EncoderConfig struct {
+	// Size of the picture in pixels
+	Width, Height int
+	// Size of the encoded frame, a multiple of 16 not less than the
+	// picture size plus its offset. 0 rounds the picture size up
+	FrameWidth, FrameHeight int
+	// Placement of the picture in the frame, AlignCenter by default
+	Align PictureAlign
+	// Offset of the picture for AlignCustom
+	Offset image.Point
+
+	// Frame rate, FPSNumerator/FPSDenominator frames per second.
+	// 25/1 by default
+	FPSNumerator, FPSDenominator int
+	// Pixel aspect ratio, 0:0 (unknown) by default
+	AspectNumerator, AspectDenominator int
+	// Unspec by default
+	Colorspace Colorspace
+	// Chroma subsampling, 4:2:0 by default
+	PixelFormat image.YCbCrSubsampleRatio
+
+	// Quality 0..63, 48 by default. Used if Bitrate is 0
+	Quality int
+	// Target bitrate in bits per second, 0 (constant quality) by
+	// default
+	Bitrate int
+
+	// Maximal distance between keyframes, 64 by default
+	KeyframeFrequency int
+	// Minimal distance between the automatic keyframes, 8 by default
+	KeyframeMinDistance int
+	// Insert keyframes on the scene changes, true by default
+	KeyframeAuto bool
+	// Scene change threshold 0..100, 80 by default
+	KeyframeAutoThreshold int
+
+	// Allow the encoder to drop frames, false by default
+	DropFrames bool
+	// Quick encoding mode, true by default
+	Quick bool
+	// 1 by default
+	NoiseSensitivity int
+	// Sharpness 0..2, 0 by default
+	Sharpness int
+
+	// Speed level of the encoder, SpeedLevelDefault by default. The
+	// levels above GetSpeedLevelMax are lowered to it
+	SpeedLevel int
+	// Rate control flags used in the bitrate mode, RateDropFrames by
+	// default
+	RateFlags RateFlags
+	// Rate control buffer in frames used in the bitrate mode, 0 (the
+	// encoder default) by default
+	RateBuffer int
+
+	// Serial number of the ogg stream, 0 picks a random one
+	SerialNo int32
+}
+
+// NewEncoderConfig returns the default configuration for a w x h
+// picture
+func NewEncoderConfig(w, h int) EncoderConfig {
+	return EncoderConfig{
+		Width:                 w,
+		Height:                h,
+		Align:                 AlignCenter,
+		FPSNumerator:          25,
+		FPSDenominator:        1,
+		PixelFormat:           image.YCbCrSubsampleRatio420,
+		Quality:               48,
+		KeyframeFrequency:     64,
+		KeyframeMinDistance:   8,
+		KeyframeAuto:          true,
+		KeyframeAutoThreshold: 80,
+		Quick:                 true,
+		NoiseSensitivity:      1,
+		SpeedLevel:            SpeedLevelDefault,
+		RateFlags:             RateDropFrames,
+	}
+}
+
+// ConvertOptions returns the options placing the pictures into the
+// frames of the configuration
+func (c EncoderConfig) ConvertOptions() ConvertOptions {
+	return ConvertOptions{
+		Matrix: c.Colorspace.Matrix(),
+		Align:  c.Align,
+		Offset: c.Offset,
+	}
+}
+
+// Layout returns the size of the encoded frame and the offset of the
+// picture
+func (c EncoderConfig) Layout() (frame image.Point, offset image.Point) {
+	frame, offset = c.ConvertOptions().Layout(c.Width, c.Height)
+	if c.FrameWidth > 0 {
+		frame.X = c.FrameWidth
+	}
+	if c.FrameHeight > 0 {
+		frame.Y = c.FrameHeight
+	}
+	if c.Align == AlignCenter {
+		offset = image.Pt(max(frame.X-c.Width, 0)/2&^1, max(frame.Y-c.Height, 0)/2&^1)
+	}
+	return frame, offset
+}
+
+func configError(field, format string, args ...any) error {
+	return errTheoraInvalidConfigException{field, fmt.Sprintf(format, args...)}
+}
+
+// Validate checks the configuration. All the problems found are
+// returned joined, each of them matches ETheoraInvalidConfigException
+func (c EncoderConfig) Validate() error {
+	var errs []error
+	check := func(ok bool, field, format string, args ...any) {
+		if !ok {
+			errs = append(errs, configError(field, format, args...))
+		}
+	}
+
+	frame, offset := c.Layout()
+	check(c.Width > 0 && c.Height > 0, "Width/Height",
+		"picture size %dx%d must be positive", c.Width, c.Height)
+	check(frame.X%16 == 0 && frame.Y%16 == 0, "FrameWidth/FrameHeight",
+		"frame size %dx%d must be a multiple of 16", frame.X, frame.Y)
+	check(frame.X <= maxFrameSize && frame.Y <= maxFrameSize, "FrameWidth/FrameHeight",
+		"frame size %dx%d exceeds %d", frame.X, frame.Y, maxFrameSize)
+	check(offset.X+c.Width <= frame.X && offset.Y+c.Height <= frame.Y, "FrameWidth/FrameHeight",
+		"picture %dx%d at %d,%d does not fit the frame %dx%d",
+		c.Width, c.Height, offset.X, offset.Y, frame.X, frame.Y)
+	check(c.Align != AlignCustom || (c.Offset.X >= 0 && c.Offset.Y >= 0), "Offset",
+		"picture offset %d,%d must not be negative", c.Offset.X, c.Offset.Y)
+	check(offset.X <= maxPictureOffset && frame.Y-c.Height-offset.Y <= maxPictureOffset, "Offset",
+		"picture offset %d,%d exceeds %d", offset.X, offset.Y, maxPictureOffset)
+
+	check(c.FPSNumerator > 0 && c.FPSDenominator > 0, "FPSNumerator/FPSDenominator",
+		"frame rate %d/%d must be positive", c.FPSNumerator, c.FPSDenominator)
+	/* int may be 32 bits wide, the values are compared as uint64. The
+	   negative values are reported above */
+	check((c.FPSNumerator <= 0 || uint64(c.FPSNumerator) <= math.MaxUint32) &&
+		(c.FPSDenominator <= 0 || uint64(c.FPSDenominator) <= math.MaxUint32),
+		"FPSNumerator/FPSDenominator", "frame rate %d/%d exceeds 32 bits",
+		c.FPSNumerator, c.FPSDenominator)
+	check((c.AspectNumerator == 0) == (c.AspectDenominator == 0) &&
+		c.AspectNumerator >= 0 && c.AspectDenominator >= 0 &&
+		c.AspectNumerator <= maxAspect && c.AspectDenominator <= maxAspect,
+		"AspectNumerator/AspectDenominator", "aspect ratio %d:%d is invalid",
+		c.AspectNumerator, c.AspectDenominator)
+	check(c.Colorspace >= Unspec && c.Colorspace < NSpaces, "Colorspace",
+		"unknown colorspace %d", c.Colorspace)
+	check(c.PixelFormat == image.YCbCrSubsampleRatio420 ||
+		c.PixelFormat == image.YCbCrSubsampleRatio422 ||
+		c.PixelFormat == image.YCbCrSubsampleRatio444, "PixelFormat",
+		"pixel format %v is not supported", c.PixelFormat)
+
+	check(c.Quality >= 0 && c.Quality <= maxQuality, "Quality",
+		"quality %d must be in 0..%d", c.Quality, maxQuality)
+	check(c.Bitrate >= 0 && c.Bitrate <= maxBitrate, "Bitrate",
+		"bitrate %d must be in 0..%d", c.Bitrate, maxBitrate)
+
+	check(c.KeyframeFrequency > 0 && c.KeyframeFrequency <= math.MaxInt32, "KeyframeFrequency",
+		"keyframe frequency %d must be positive", c.KeyframeFrequency)
+	check(c.KeyframeMinDistance >= 0 && c.KeyframeMinDistance <= c.KeyframeFrequency,
+		"KeyframeMinDistance", "keyframe min distance %d must be in 0..%d",
+		c.KeyframeMinDistance, c.KeyframeFrequency)
+	check(c.KeyframeAutoThreshold >= 0 && c.KeyframeAutoThreshold <= maxKeyframeThresh,
+		"KeyframeAutoThreshold", "keyframe threshold %d must be in 0..%d",
+		c.KeyframeAutoThreshold, maxKeyframeThresh)
+	check(c.NoiseSensitivity >= 0, "NoiseSensitivity",
+		"noise sensitivity %d must not be negative", c.NoiseSensitivity)
+	check(c.Sharpness >= 0 && c.Sharpness <= maxSharpness, "Sharpness",
+		"sharpness %d must be in 0..%d", c.Sharpness, maxSharpness)
+	check(c.RateFlags&^(RateDropFrames|RateCapOverflow|RateCapUnderflow) == 0, "RateFlags",
+		"unknown rate flags %#x", int(c.RateFlags))
+	check(c.RateBuffer >= 0, "RateBuffer",
+		"rate buffer %d must not be negative", c.RateBuffer)
+
+	return errors.Join(errs...)
+}
+
+// AssignToTheoraInfo validates the configuration and sets all the
+// fields of inf
+func (c EncoderConfig) AssignToTheoraInfo(inf ITheoraInfo) error {
+	err := c.Validate()
+	if err != nil {
+		return err
+	}
+	frame, offset := c.Layout()
+	inf.SetWidth(frame.X)
+	inf.SetHeight(frame.Y)
+	inf.SetFrameWidth(c.Width)
+	inf.SetFrameHeight(c.Height)
+	inf.SetOffsetX(offset.X)
+	inf.SetOffsetY(offset.Y)
+
+	inf.SetFPSNumerator(c.FPSNumerator)
+	inf.SetFPSDenominator(c.FPSDenominator)
+	inf.SetAspectNumerator(c.AspectNumerator)
+	inf.SetAspectDenominator(c.AspectDenominator)
+	inf.SetColorspace(c.Colorspace)
+	inf.SetPixelFormat(c.PixelFormat)
+
+	inf.SetQuality(c.Quality)
+	inf.SetTargetBitrate(c.Bitrate)
+	inf.SetKeyframeDataTargetBitrate(c.Bitrate * 3 / 2)
+
+	inf.SetKeyframeFrequency(c.KeyframeFrequency)
+	inf.SetKeyframeFrequencyForce(c.KeyframeFrequency)
+	inf.SetKeyframeMindistance(c.KeyframeMinDistance)
+	inf.SetKeyframeAuto(c.KeyframeAuto)
+	inf.SetKeyframeAutoThreshold(c.KeyframeAutoThreshold)
+
+	inf.SetDropFrames(c.DropFrames)
+	inf.SetQuick(c.Quick)
+	inf.SetNoiseSensitivity(c.NoiseSensitivity)
+	inf.SetSharpness(c.Sharpness)
+	return nil
+}
+
+// NewTheoraInfo validates the configuration and builds the info
+// structure of it
+func (c EncoderConfig) NewTheoraInfo() (ITheoraInfo, error) {
+	inf, err := NewTheoraInfo()
+	if err != nil {
+		return nil, err
+	}
+	inf.Init()
+	err = c.AssignToTheoraInfo(inf)
+	if err != nil {
+		inf.Close()
+		return nil, err
+	}
+	return inf, nil
+}
+
+// NewTheoraEncoderConfig validates the configuration and creates the
+// encoder writing to str
+func NewTheoraEncoderConfig(cfg EncoderConfig, str io.Writer) (ITheoraEncoder, error) {
+	inf, err := cfg.NewTheoraInfo()
+	if err != nil {
+		return nil, err
+	}
+	enc, err := NewTheoraEncoder(inf, str)
+	/* the encoder state keeps the info until the encoder is closed */
+	inf.Close()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.SerialNo != 0 {
+		err = enc.SetSerialNo(cfg.SerialNo)
+		if err != nil {
+			enc.Close()
+			return nil, err
+		}
+	}
+	err = cfg.AssignToEncoder(enc)
+	if err != nil {
+		enc.Close()
+		return nil, err
+	}
+	return enc, nil
+}
+
+// AssignToEncoder sets the speed level and, in the bitrate mode, the
+// rate control settings of the encoder
+func (c EncoderConfig) AssignToEncoder(enc ITheoraEncoder) error {
+	if c.SpeedLevel >= 0 {
+		level, err := enc.GetSpeedLevelMax()
+		if err != nil {
+			return err
+		}
+		err = enc.SetSpeedLevel(min(c.SpeedLevel, level))
+		if err != nil {
+			return err
+		}
+	}
+	if c.Bitrate > 0 {
+		err := enc.SetRateFlags(c.RateFlags)
+		if err != nil {
+			return err
+		}
+		if c.RateBuffer > 0 {
+			_, err = enc.SetRateBuffer(c.RateBuffer)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
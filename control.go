@@ -0,0 +1,194 @@
+/* GoTheora
+Wrapper for Theora library
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package gotheora
+
+/*
+#cgo CFLAGS: -I/usr/include
+#include "theora/theoraenc.h"
+#include "theora/theoradec.h"
+#include "theora/theora.h"
+#include <stdint.h>
+
+extern void gotheoraStripeDecoded(void *ctx, th_img_plane *buf, int yfrag0, int yfrag_end);
+
+static int gotheora_set_stripe_cb(th_dec_ctx *dec, uintptr_t ctx) {
+    th_stripe_callback cb;
+    cb.ctx = (void *)ctx;
+    if (ctx != 0)
+        cb.stripe_decoded = (th_stripe_decoded_func)gotheoraStripeDecoded;
+    else
+        cb.stripe_decoded = NULL;
+    return th_decode_ctl(dec, TH_DECCTL_SET_STRIPE_CB, &cb, sizeof(cb));
+}
+*/
+import "C"
+import "unsafe"
+
+/* Rate control flags for SetRateFlags */
+
+type RateFlags int
+
+const (
+	// Drop frames to keep within bitrate buffer constraints
+	RateDropFrames RateFlags = C.TH_RATECTL_DROP_FRAMES
+	// Ignore bitrate buffer overflows
+	RateCapOverflow RateFlags = C.TH_RATECTL_CAP_OVERFLOW
+	// Ignore bitrate buffer underflows
+	RateCapUnderflow RateFlags = C.TH_RATECTL_CAP_UNDERFLOW
+)
+
+/* TheoraEncoder control requests */
+
+func (v *TheoraEncoder) controlInt(req int, value int) (int, error) {
+	cv := C.int(value)
+	R := v.control(req, unsafe.Pointer(&cv), unsafe.Sizeof(cv))
+	if R != 0 {
+		return value, newError("TheoraEncoder.Control", R)
+	}
+	return int(cv), nil
+}
+
+// SetQuality changes the quality target (0..63) of the encoder.
+// The encoder must be in the quality mode (target bitrate is zero)
+func (v *TheoraEncoder) SetQuality(value int) error {
+	_, err := v.controlInt(C.TH_ENCCTL_SET_QUALITY, value)
+	return err
+}
+
+// SetBitrate changes the target bitrate (bits per second). A zero
+// value switches the encoder back to the quality mode
+func (v *TheoraEncoder) SetBitrate(value int) error {
+	cv := C.long(value)
+	R := v.control(C.TH_ENCCTL_SET_BITRATE, unsafe.Pointer(&cv), unsafe.Sizeof(cv))
+	if R != 0 {
+		return newError("TheoraEncoder.SetBitrate", R)
+	}
+	return nil
+}
+
+// SetKeyframeFrequencyForce sets the maximum distance between
+// keyframes. The value actually used by the encoder is returned; it
+// is limited by the keyframe granule shift chosen at initialization
+func (v *TheoraEncoder) SetKeyframeFrequencyForce(value int) (int, error) {
+	res, err := v.setKeyframeFrequencyForce(value)
+	if err == nil {
+		v.fKFForce = res
+	}
+	return res, err
+}
+
+func (v *TheoraEncoder) setKeyframeFrequencyForce(value int) (int, error) {
+	cv := C.ogg_uint32_t(value)
+	R := v.control(C.TH_ENCCTL_SET_KEYFRAME_FREQUENCY_FORCE, unsafe.Pointer(&cv), unsafe.Sizeof(cv))
+	if R != 0 {
+		return value, newError("TheoraEncoder.SetKeyframeFrequencyForce", R)
+	}
+	return int(cv), nil
+}
+
+// GetSpeedLevelMax returns the highest speed level supported by the
+// encoder. Higher levels encode faster with lower quality
+func (v *TheoraEncoder) GetSpeedLevelMax() (int, error) {
+	return v.controlInt(C.TH_ENCCTL_GET_SPLEVEL_MAX, 0)
+}
+
+// GetSpeedLevel returns the current speed level
+func (v *TheoraEncoder) GetSpeedLevel() (int, error) {
+	return v.controlInt(C.TH_ENCCTL_GET_SPLEVEL, 0)
+}
+
+// SetSpeedLevel sets the speed level in the range 0..GetSpeedLevelMax
+func (v *TheoraEncoder) SetSpeedLevel(value int) error {
+	_, err := v.controlInt(C.TH_ENCCTL_SET_SPLEVEL, value)
+	return err
+}
+
+// SetVP3Compatible disables the features unsupported by VP3 decoders.
+// The result tells whether the stream is VP3 compatible now
+func (v *TheoraEncoder) SetVP3Compatible(value bool) (bool, error) {
+	cv := 0
+	if value {
+		cv = 1
+	}
+	res, err := v.controlInt(C.TH_ENCCTL_SET_VP3_COMPATIBLE, cv)
+	return res != 0, err
+}
+
+// SetRateFlags sets the rate control behavior in the bitrate mode
+func (v *TheoraEncoder) SetRateFlags(value RateFlags) error {
+	_, err := v.controlInt(C.TH_ENCCTL_SET_RATE_FLAGS, int(value))
+	return err
+}
+
+// SetRateBuffer sets the size of the rate control buffer in frames.
+// Zero selects the default size. The size actually used is returned
+func (v *TheoraEncoder) SetRateBuffer(value int) (int, error) {
+	return v.controlInt(C.TH_ENCCTL_SET_RATE_BUFFER, value)
+}
+
+/* TheoraDecoder control requests */
+
+func (v *TheoraDecoder) controlInt(req int, value int) (int, error) {
+	cv := C.int(value)
+	R := v.control(req, unsafe.Pointer(&cv), unsafe.Sizeof(cv))
+	if R != 0 {
+		return value, newError("TheoraDecoder.Control", R)
+	}
+	return int(cv), nil
+}
+
+// GetPPLevelMax returns the highest post-processing level. Level 0
+// disables post-processing, the higher levels enable deblocking and
+// deringing filters
+func (v *TheoraDecoder) GetPPLevelMax() (int, error) {
+	return v.controlInt(C.TH_DECCTL_GET_PPLEVEL_MAX, 0)
+}
+
+// SetPPLevel sets the post-processing level in the range
+// 0..GetPPLevelMax
+func (v *TheoraDecoder) SetPPLevel(value int) error {
+	_, err := v.controlInt(C.TH_DECCTL_SET_PPLEVEL, value)
+	return err
+}
+
+// SetGranulePos sets the granule position of the last decoded frame.
+// Should be called after a seek so that the granule positions of the
+// following frames are calculated correctly
+func (v *TheoraDecoder) SetGranulePos(value int64) error {
+	cv := C.ogg_int64_t(value)
+	R := v.control(C.TH_DECCTL_SET_GRANPOS, unsafe.Pointer(&cv), unsafe.Sizeof(cv))
+	if R != 0 {
+		return newError("TheoraDecoder.SetGranulePos", R)
+	}
+	v.fState.SetGranulePos(value)
+	return nil
+}
+
+// SetStripeCallback sets the function which receives the rows of the
+// frame as soon as they are decoded. The callback is called from
+// PacketIn. nil removes the callback
+func (v *TheoraDecoder) SetStripeCallback(cb StripeDecodedFunc) error {
+	if v.fDec == nil {
+		return newFrameError("TheoraDecoder.SetStripeCallback", 0, -1, ETheoraDecNotReadyException)
+	}
+	var h stripeHandle
+	if cb != nil {
+		h = newStripeHandle(cb)
+	}
+	R := int(C.gotheora_set_stripe_cb(v.fDec.Ref(), C.uintptr_t(h)))
+	if R != 0 {
+		h.release()
+		return newError("TheoraDecoder.SetStripeCallback", R)
+	}
+	v.fStripe.release()
+	v.fStripe = h
+	return nil
+}
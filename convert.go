@@ -0,0 +1,813 @@
+/* GoTheora
+Wrapper for Theora library
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package gotheora
+
+/*
+#cgo CFLAGS: -I/usr/include
+#include "theora/theora.h"
+*/
+import "C"
+import (
+	"image"
+	"image/color"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+/* Conversion options */
+
+// ColorMatrix is the set of luma coefficients of a Y'CbCr encoding
+type ColorMatrix struct {
+	Kr, Kb float64
+}
+
+var (
+	// ITU-R BT.601
+	MatrixBT601 = ColorMatrix{Kr: 0.299, Kb: 0.114}
+	// ITU-R BT.709
+	MatrixBT709 = ColorMatrix{Kr: 0.2126, Kb: 0.0722}
+	// ITU-R Rec. 470 System M. Uses the BT.601 coefficients
+	MatrixRec470M = MatrixBT601
+	// ITU-R Rec. 470 Systems B and G. Uses the BT.601 coefficients
+	MatrixRec470BG = MatrixBT601
+)
+
+// ColorRange is the range of the Y'CbCr samples
+type ColorRange int
+
+const (
+	// Y' in [16, 235], Cb and Cr in [16, 240]. Theora streams are
+	// always coded in this range
+	RangeLimited ColorRange = iota
+	// Y', Cb and Cr in [0, 255]
+	RangeFull
+)
+
+// PictureAlign sets the placement of the picture inside the encoded
+// frame, which sizes are multiples of 16
+type PictureAlign int
+
+const (
+	// The picture is placed at the top left corner of the frame
+	AlignTopLeft PictureAlign = iota
+	// The picture is centered in the frame. The offsets are even, so
+	// the chroma samples are not shifted
+	AlignCenter
+	// The picture is placed at ConvertOptions.Offset
+	AlignCustom
+)
+
+// Maximal offset of the picture allowed by the bitstream
+const maxPictureOffset = 255
+
+// ConvertOptions sets the matrix and the range used to convert
+// between RGB and the Y'CbCr planes of a TheoraYUVbuffer, and the
+// placement of the picture in the encoded frame. The padding around
+// the picture is filled with its edge pixels. The zero Matrix is
+// treated as BT.601
+type ConvertOptions struct {
+	Matrix ColorMatrix
+	Range  ColorRange
+	Align  PictureAlign
+	// Offset of the picture from the top left corner of the frame
+	// for AlignCustom
+	Offset image.Point
+}
+
+// Matrix returns the conversion matrix of the color space. The
+// unspecified color space is treated as BT.601
+func (cs Colorspace) Matrix() ColorMatrix {
+	switch cs {
+	case ITURec470M:
+		return MatrixRec470M
+	case ITURec470BG:
+		return MatrixRec470BG
+	}
+	return MatrixBT601
+}
+
+// ConvertOptions returns the conversion options matching the color
+// space tag of a stream
+func (cs Colorspace) ConvertOptions() ConvertOptions {
+	return ConvertOptions{Matrix: cs.Matrix(), Range: RangeLimited}
+}
+
+// ConvertOptionsOf returns the conversion options matching the color
+// space and the picture offsets of inf. If inf is nil, the options of
+// the unspecified color space are returned
+func ConvertOptionsOf(inf ITheoraInfo) ConvertOptions {
+	if inf == nil {
+		return Unspec.ConvertOptions()
+	}
+	res := inf.GetColorspace().ConvertOptions()
+	res.Align = AlignCustom
+	res.Offset = image.Pt(inf.GetOffsetX(), inf.GetOffsetY())
+	return res
+}
+
+func (o ConvertOptions) matrix() ColorMatrix {
+	if o.Matrix == (ColorMatrix{}) {
+		return MatrixBT601
+	}
+	return o.Matrix
+}
+
+func roundUp16(v int) int {
+	return (v + 15) &^ 15
+}
+
+// Layout returns the size of the encoded frame and the offset of a
+// w x h picture placed according to the options
+func (o ConvertOptions) Layout(w, h int) (frame image.Point, offset image.Point) {
+	switch o.Align {
+	case AlignCenter:
+		frame = image.Pt(roundUp16(w), roundUp16(h))
+		offset = image.Pt(((frame.X-w)/2)&^1, ((frame.Y-h)/2)&^1)
+	case AlignCustom:
+		offset = image.Pt(max(o.Offset.X, 0), max(o.Offset.Y, 0))
+		frame = image.Pt(roundUp16(offset.X+w), roundUp16(offset.Y+h))
+	default:
+		frame = image.Pt(roundUp16(w), roundUp16(h))
+	}
+	return frame, offset
+}
+
+// AssignToTheoraInfo sets the frame size, the picture size and the
+// picture offsets of inf for a w x h picture placed according to the
+// options, so the frames converted with ConvertFromRasterImageInfo
+// match the header
+func (o ConvertOptions) AssignToTheoraInfo(inf ITheoraInfo, w, h int) error {
+	frame, offset := o.Layout(w, h)
+	if w <= 0 || h <= 0 || offset.X > maxPictureOffset ||
+		frame.Y-h-offset.Y > maxPictureOffset {
+		return newError("ConvertOptions.AssignToTheoraInfo", C.OC_EINVAL)
+	}
+	inf.SetWidth(frame.X)
+	inf.SetHeight(frame.Y)
+	inf.SetFrameWidth(w)
+	inf.SetFrameHeight(h)
+	inf.SetOffsetX(offset.X)
+	inf.SetOffsetY(offset.Y)
+	return nil
+}
+
+/* RGB -> YCbCr conversion.
+   Every source is read row by row into the non-premultiplied
+   8-bit r, g, b components. The rows of the common image types are
+   read directly from their Pix arrays and converted in parallel,
+   any other image goes through At and color.NRGBAModel */
+
+// Minimal count of rows in a band processed by one goroutine
+const convertMinBandRows = 32
+
+// Fixed point precision of the conversion coefficients
+const convertFixedBits = 16
+
+type yuvPlanes struct {
+	y, u, v  []byte
+	ystride  int
+	uvstride int
+}
+
+// rgbRowFunc reads the row y (relative to the image bounds) into r, g, b
+type rgbRowFunc func(y int, r, g, b []int32)
+
+func clamp8(v int32) byte {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return byte(v)
+}
+
+func fixed(v float64) int32 {
+	if v < 0 {
+		return -int32(-v*(1<<convertFixedBits) + 0.5)
+	}
+	return int32(v*(1<<convertFixedBits) + 0.5)
+}
+
+// yuvCoefs holds the coefficients of the RGB -> Y'CbCr conversion
+type yuvCoefs struct {
+	yr, yg, yb, yo int32
+	ur, ug, ub     int32
+	vr, vg, vb     int32
+	co             int32
+}
+
+func (o ConvertOptions) yuvCoefs() *yuvCoefs {
+	m := o.matrix()
+	kr, kb := m.Kr, m.Kb
+	kg := 1 - kr - kb
+	ys, cs, yoff := 219.0/255.0, 224.0/255.0, 16.0
+	if o.Range == RangeFull {
+		ys, cs, yoff = 1, 1, 0
+	}
+	return &yuvCoefs{
+		yr: fixed(ys * kr), yg: fixed(ys * kg), yb: fixed(ys * kb),
+		yo: fixed(yoff + 0.5),
+		ur: fixed(-cs * kr / (2 * (1 - kb))), ug: fixed(-cs * kg / (2 * (1 - kb))), ub: fixed(cs / 2),
+		vr: fixed(cs / 2), vg: fixed(-cs * kg / (2 * (1 - kr))), vb: fixed(-cs * kb / (2 * (1 - kr))),
+		co: fixed(128.5),
+	}
+}
+
+func (c *yuvCoefs) y(r, g, b int32) byte {
+	return clamp8((c.yr*r + c.yg*g + c.yb*b + c.yo) >> convertFixedBits)
+}
+
+func (c *yuvCoefs) u(r, g, b int32) int32 {
+	return c.ur*r + c.ug*g + c.ub*b
+}
+
+func (c *yuvCoefs) v(r, g, b int32) int32 {
+	return c.vr*r + c.vg*g + c.vb*b
+}
+
+// chroma averages the sum of 1 << sh chroma values
+func (c *yuvCoefs) chroma(sum int32, sh uint) byte {
+	return clamp8(((sum >> sh) + c.co) >> convertFixedBits)
+}
+
+// ConvertFromRasterImage fills the buffer with the picture of aData
+// using the BT.601 matrix and the limited range. The picture is
+// placed at the top left corner of the frame
+func (v *TheoraYUVbuffer) ConvertFromRasterImage(chroma_format image.YCbCrSubsampleRatio, aData image.Image) bool {
+	return v.ConvertFromRasterImageOptions(chroma_format, aData, Unspec.ConvertOptions())
+}
+
+// ConvertFromRasterImageInfo fills the buffer with the picture of
+// aData using the pixel format, the color space, the frame size and
+// the picture offsets of inf, so the picture is coded as the stream
+// header declares. Returns false if the picture does not fit into the
+// frame
+func (v *TheoraYUVbuffer) ConvertFromRasterImageInfo(inf ITheoraInfo, aData image.Image) bool {
+	opts := ConvertOptionsOf(inf)
+	frame, offset, ok := infoLayout(inf, opts, aData.Bounds())
+	if !ok {
+		return false
+	}
+	return v.convertFromRasterImage(inf.GetPixelFormat(), aData, opts, frame, offset)
+}
+
+// infoLayout returns the frame size and the picture offset for the
+// picture of the given bounds coded with inf
+func infoLayout(inf ITheoraInfo, opts ConvertOptions, bounds image.Rectangle) (image.Point, image.Point, bool) {
+	frame, offset := opts.Layout(bounds.Dx(), bounds.Dy())
+	if inf.GetWidth() > 0 || inf.GetHeight() > 0 {
+		if inf.GetWidth() < frame.X || inf.GetHeight() < frame.Y {
+			return frame, offset, false
+		}
+		frame = image.Pt(inf.GetWidth(), inf.GetHeight())
+	}
+	return frame, offset, true
+}
+
+// ConvertFromRasterImageOptions fills the buffer with the picture of
+// aData using the given conversion options. The frame size and the
+// picture offsets are given by opts.Layout
+func (v *TheoraYUVbuffer) ConvertFromRasterImageOptions(chroma_format image.YCbCrSubsampleRatio, aData image.Image, opts ConvertOptions) bool {
+	frame, offset := opts.Layout(aData.Bounds().Dx(), aData.Bounds().Dy())
+	return v.convertFromRasterImage(chroma_format, aData, opts, frame, offset)
+}
+
+func (v *TheoraYUVbuffer) convertFromRasterImage(chroma_format image.YCbCrSubsampleRatio, aData image.Image, opts ConvertOptions, frame, offset image.Point) bool {
+	if !(chroma_format == image.YCbCrSubsampleRatio444 ||
+		chroma_format == image.YCbCrSubsampleRatio422 ||
+		chroma_format == image.YCbCrSubsampleRatio420) {
+		return false
+	}
+
+	h := aData.Bounds().Dy()
+	w := aData.Bounds().Dx()
+	if w <= 0 || h <= 0 || frame.X&15 != 0 || frame.Y&15 != 0 {
+		return false
+	}
+
+	// Must hold: yuv_w >= w
+	var yuv_w int = frame.X
+	// Must hold: yuv_h >= h
+	var yuv_h int = frame.Y
+
+	uv_w, uv_h := yuv_w, yuv_h
+	if chroma_format != image.YCbCrSubsampleRatio444 {
+		uv_w = yuv_w >> 1
+	}
+	if chroma_format == image.YCbCrSubsampleRatio420 {
+		uv_h = yuv_h >> 1
+	}
+
+	/* the planes are converted in place in the C memory. The planes
+	   of the same size are reused, every byte of them is written */
+	if v.reserve(yuv_w, yuv_h, uv_w, uv_h) != nil {
+		return false
+	}
+	p := &yuvPlanes{
+		y:        v.GetYData(),
+		u:        v.GetUData(),
+		v:        v.GetVData(),
+		ystride:  v.GetYStride(),
+		uvstride: v.GetUVStride(),
+	}
+
+	if src, ok := aData.(*image.YCbCr); ok && opts.matrix() == MatrixBT601 {
+		luma, chroma := &identityLUT, &identityLUT
+		if opts.Range == RangeLimited {
+			luma, chroma = &fullToLimitedLuma, &fullToLimitedChroma
+		}
+		xmap, ymap := edgeMap(frame.X, offset.X, w), edgeMap(frame.Y, offset.Y, h)
+		convertBands(yuv_h, func(y0, y1 int) {
+			convertYCbCrBand(p, chroma_format, src, luma, chroma, xmap, ymap, y0, y1)
+		})
+		return true
+	}
+
+	c := opts.yuvCoefs()
+	rows, parallel := rgbRowsOf(aData)
+	rows = paddedRows(rows, w, h, offset)
+	if parallel {
+		convertBands(yuv_h, func(y0, y1 int) {
+			convertRGBBand(p, chroma_format, c, rows, yuv_w, yuv_h, y0, y1)
+		})
+	} else {
+		convertRGBBand(p, chroma_format, c, rows, yuv_w, yuv_h, 0, yuv_h)
+	}
+	return true
+}
+
+// edgeMap maps each of n frame coordinates to the coordinate of the
+// nearest picture sample, the picture occupies [off, off+size)
+func edgeMap(n, off, size int) []int {
+	res := make([]int, n)
+	for i := range res {
+		res[i] = min(max(i-off, 0), size-1)
+	}
+	return res
+}
+
+// paddedRows turns the rows of a w x h picture into the rows of the
+// frame with the picture at off. The rows and the columns out of the
+// picture repeat its edges
+func paddedRows(rows rgbRowFunc, w, h int, off image.Point) rgbRowFunc {
+	return func(y int, r, g, b []int32) {
+		x0, x1 := off.X, off.X+w
+		rows(min(max(y-off.Y, 0), h-1), r[x0:x1], g[x0:x1], b[x0:x1])
+		for x := 0; x < x0; x++ {
+			r[x], g[x], b[x] = r[x0], g[x0], b[x0]
+		}
+		for x := x1; x < len(r); x++ {
+			r[x], g[x], b[x] = r[x1-1], g[x1-1], b[x1-1]
+		}
+	}
+}
+
+// convertBands splits the rows [0, h) into bands of even size and
+// processes them concurrently
+func convertBands(h int, band func(y0, y1 int)) {
+	n := runtime.GOMAXPROCS(0)
+	if max := h / convertMinBandRows; n > max {
+		n = max
+	}
+	if n <= 1 {
+		band(0, h)
+		return
+	}
+	rows := ((h+n-1)/n + 1) &^ 1
+	var wg sync.WaitGroup
+	for y0 := 0; y0 < h; y0 += rows {
+		y1 := min(y0+rows, h)
+		wg.Add(1)
+		go func(y0, y1 int) {
+			defer wg.Done()
+			band(y0, y1)
+		}(y0, y1)
+	}
+	wg.Wait()
+}
+
+func rgbRowsOf(aData image.Image) (rgbRowFunc, bool) {
+	b := aData.Bounds()
+	w := b.Dx()
+	switch src := aData.(type) {
+	case *image.NRGBA:
+		return func(y int, r, g, bl []int32) {
+			pix := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):]
+			for x := 0; x < w; x++ {
+				r[x] = int32(pix[x*4])
+				g[x] = int32(pix[x*4+1])
+				bl[x] = int32(pix[x*4+2])
+			}
+		}, true
+	case *image.RGBA:
+		return func(y int, r, g, bl []int32) {
+			pix := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):]
+			for x := 0; x < w; x++ {
+				r[x], g[x], bl[x] = unpremultiply(pix[x*4], pix[x*4+1], pix[x*4+2], pix[x*4+3])
+			}
+		}, true
+	case *image.Gray:
+		return func(y int, r, g, bl []int32) {
+			pix := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):]
+			for x := 0; x < w; x++ {
+				r[x] = int32(pix[x])
+				g[x] = r[x]
+				bl[x] = r[x]
+			}
+		}, true
+	case *image.Paletted:
+		pal := make([][3]int32, len(src.Palette))
+		for i, c := range src.Palette {
+			nc := color.NRGBAModel.Convert(c).(color.NRGBA)
+			pal[i] = [3]int32{int32(nc.R), int32(nc.G), int32(nc.B)}
+		}
+		return func(y int, r, g, bl []int32) {
+			pix := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):]
+			for x := 0; x < w; x++ {
+				var c [3]int32
+				if int(pix[x]) < len(pal) {
+					c = pal[pix[x]]
+				}
+				r[x], g[x], bl[x] = c[0], c[1], c[2]
+			}
+		}, true
+	case *image.YCbCr:
+		return func(y int, r, g, bl []int32) {
+			yo := src.YOffset(b.Min.X, b.Min.Y+y)
+			for x := 0; x < w; x++ {
+				co := src.COffset(b.Min.X+x, b.Min.Y+y)
+				cr, cg, cb := color.YCbCrToRGB(src.Y[yo+x], src.Cb[co], src.Cr[co])
+				r[x], g[x], bl[x] = int32(cr), int32(cg), int32(cb)
+			}
+		}, true
+	}
+	return func(y int, r, g, bl []int32) {
+		for x := 0; x < w; x++ {
+			c := color.NRGBAModel.Convert(aData.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+			r[x], g[x], bl[x] = int32(c.R), int32(c.G), int32(c.B)
+		}
+	}, false
+}
+
+// unpremultiply does the same as color.NRGBAModel for a color.RGBA
+func unpremultiply(r, g, b, a byte) (int32, int32, int32) {
+	switch a {
+	case 0xff:
+		return int32(r), int32(g), int32(b)
+	case 0:
+		return 0, 0, 0
+	}
+	a16 := uint32(a) * 0x101
+	r16 := uint32(r) * 0x101 * 0xffff / a16
+	g16 := uint32(g) * 0x101 * 0xffff / a16
+	b16 := uint32(b) * 0x101 * 0xffff / a16
+	return int32(r16 >> 8), int32(g16 >> 8), int32(b16 >> 8)
+}
+
+// rgbScratch holds the rows of a band being converted
+type rgbScratch struct {
+	rows [6][]int32
+}
+
+var rgbScratchPool = sync.Pool{
+	New: func() any { return new(rgbScratch) },
+}
+
+// row returns the i-th scratch row of the width w
+func (s *rgbScratch) row(i, w int) []int32 {
+	if cap(s.rows[i]) < w {
+		s.rows[i] = make([]int32, w)
+	}
+	return s.rows[i][:w]
+}
+
+// convertRGBBand converts the rows [y0, y1) of the source. For 4:2:0
+// y0 must be even
+func convertRGBBand(p *yuvPlanes, chroma_format image.YCbCrSubsampleRatio, c *yuvCoefs, rows rgbRowFunc, w, h, y0, y1 int) {
+	scratch := rgbScratchPool.Get().(*rgbScratch)
+	defer rgbScratchPool.Put(scratch)
+	r0, g0, b0 := scratch.row(0, w), scratch.row(1, w), scratch.row(2, w)
+
+	switch chroma_format {
+	case image.YCbCrSubsampleRatio420:
+		r1, g1, b1 := scratch.row(3, w), scratch.row(4, w), scratch.row(5, w)
+		for y := y0; y < y1; y += 2 {
+			rows(y, r0, g0, b0)
+			ya, yb := y*p.ystride, y*p.ystride
+			ra, ga, ba := r0, g0, b0
+			if y+1 < h {
+				rows(y+1, r1, g1, b1)
+				yb += p.ystride
+				ra, ga, ba = r1, g1, b1
+			}
+			uv := (y >> 1) * p.uvstride
+			for x := 0; x < w; x += 2 {
+				x1 := min(x+1, w-1)
+
+				p.y[ya+x] = c.y(r0[x], g0[x], b0[x])
+				p.y[ya+x1] = c.y(r0[x1], g0[x1], b0[x1])
+				p.y[yb+x] = c.y(ra[x], ga[x], ba[x])
+				p.y[yb+x1] = c.y(ra[x1], ga[x1], ba[x1])
+
+				p.u[uv+(x>>1)] = c.chroma(c.u(r0[x], g0[x], b0[x])+
+					c.u(r0[x1], g0[x1], b0[x1])+
+					c.u(ra[x], ga[x], ba[x])+
+					c.u(ra[x1], ga[x1], ba[x1]), 2)
+				p.v[uv+(x>>1)] = c.chroma(c.v(r0[x], g0[x], b0[x])+
+					c.v(r0[x1], g0[x1], b0[x1])+
+					c.v(ra[x], ga[x], ba[x])+
+					c.v(ra[x1], ga[x1], ba[x1]), 2)
+			}
+		}
+	case image.YCbCrSubsampleRatio444:
+		for y := y0; y < y1; y++ {
+			rows(y, r0, g0, b0)
+			ya := y * p.ystride
+			uv := y * p.uvstride
+			for x := 0; x < w; x++ {
+				p.y[ya+x] = c.y(r0[x], g0[x], b0[x])
+				p.u[uv+x] = c.chroma(c.u(r0[x], g0[x], b0[x]), 0)
+				p.v[uv+x] = c.chroma(c.v(r0[x], g0[x], b0[x]), 0)
+			}
+		}
+	default: /* TH_PF_422 */
+		for y := y0; y < y1; y++ {
+			rows(y, r0, g0, b0)
+			ya := y * p.ystride
+			uv := y * p.uvstride
+			for x := 0; x < w; x += 2 {
+				x1 := min(x+1, w-1)
+
+				p.y[ya+x] = c.y(r0[x], g0[x], b0[x])
+				p.y[ya+x1] = c.y(r0[x1], g0[x1], b0[x1])
+
+				p.u[uv+(x>>1)] = c.chroma(c.u(r0[x], g0[x], b0[x])+
+					c.u(r0[x1], g0[x1], b0[x1]), 1)
+				p.v[uv+(x>>1)] = c.chroma(c.v(r0[x], g0[x], b0[x])+
+					c.v(r0[x1], g0[x1], b0[x1]), 1)
+			}
+		}
+	}
+}
+
+/* image.YCbCr holds full range BT.601 (JFIF) values. They are mapped
+   to the target range, the chroma planes are resampled if the
+   subsampling differs. xmap and ymap give the picture coordinates of
+   each frame sample */
+
+var identityLUT, fullToLimitedLuma, fullToLimitedChroma [256]byte
+
+func init() {
+	for i := 0; i < 256; i++ {
+		identityLUT[i] = byte(i)
+		fullToLimitedLuma[i] = byte((i*438+255)/510 + 16)
+		fullToLimitedChroma[i] = byte(((i-128)*448 + 65535) / 510)
+	}
+}
+
+func convertYCbCrBand(p *yuvPlanes, chroma_format image.YCbCrSubsampleRatio, src *image.YCbCr, luma, chroma *[256]byte, xmap, ymap []int, y0, y1 int) {
+	b := src.Bounds()
+	for y := y0; y < y1; y++ {
+		so := src.YOffset(b.Min.X, b.Min.Y+ymap[y])
+		do := y * p.ystride
+		for x, sx := range xmap {
+			p.y[do+x] = luma[src.Y[so+sx]]
+		}
+	}
+
+	xs, ys := 1, 1
+	switch chroma_format {
+	case image.YCbCrSubsampleRatio420:
+		xs, ys = 2, 2
+	case image.YCbCrSubsampleRatio422:
+		xs = 2
+	}
+	for y := y0; y < y1; y += ys {
+		uv := (y / ys) * p.uvstride
+		for x := 0; x < len(xmap); x += xs {
+			var cb, cr, n int
+			for j := 0; j < ys; j++ {
+				for i := 0; i < xs; i++ {
+					co := src.COffset(b.Min.X+xmap[x+i], b.Min.Y+ymap[y+j])
+					cb += int(src.Cb[co])
+					cr += int(src.Cr[co])
+					n++
+				}
+			}
+			p.u[uv+x/xs] = chroma[(cb+n/2)/n]
+			p.v[uv+x/xs] = chroma[(cr+n/2)/n]
+		}
+	}
+}
+
+/* YCbCr -> RGB conversion.
+   The planes of the buffer are read with their own strides, so the
+   buffers returned by the decoder (with negative strides) are
+   converted without an intermediate copy */
+
+// rgbCoefs holds the coefficients of the Y'CbCr -> RGB conversion
+type rgbCoefs struct {
+	y, yoff, rv, gu, gv, bu int32
+}
+
+func (o ConvertOptions) rgbCoefs() rgbCoefs {
+	m := o.matrix()
+	kr, kb := m.Kr, m.Kb
+	kg := 1 - kr - kb
+	ys, cs, yoff := 255.0/219.0, 255.0/224.0, int32(16)
+	if o.Range == RangeFull {
+		ys, cs, yoff = 1, 1, 0
+	}
+	return rgbCoefs{
+		y:    fixed(ys),
+		yoff: yoff,
+		rv:   fixed(2 * (1 - kr) * cs),
+		gu:   fixed(2 * kb * (1 - kb) / kg * cs),
+		gv:   fixed(2 * kr * (1 - kr) / kg * cs),
+		bu:   fixed(2 * (1 - kb) * cs),
+	}
+}
+
+func clampRGB(v int32) uint8 {
+	return clamp8((v + 1<<(convertFixedBits-1)) >> convertFixedBits)
+}
+
+// subsampleRatio guesses the chroma subsampling from the sizes of
+// the planes
+func (v *TheoraYUVbuffer) subsampleRatio() (image.YCbCrSubsampleRatio, bool) {
+	yw, yh := v.GetYWidth(), v.GetYHeight()
+	uvw, uvh := v.GetUVWidth(), v.GetUVHeight()
+	full := func(c, l int) bool { return c == l }
+	half := func(c, l int) bool { return c == (l+1)>>1 }
+	switch {
+	case full(uvw, yw) && full(uvh, yh):
+		return image.YCbCrSubsampleRatio444, true
+	case half(uvw, yw) && full(uvh, yh):
+		return image.YCbCrSubsampleRatio422, true
+	case half(uvw, yw) && half(uvh, yh):
+		return image.YCbCrSubsampleRatio420, true
+	}
+	return 0, false
+}
+
+// planeView returns the memory of the plane as a slice together with
+// the offset of its top row. Negative strides are allowed
+func planeView(p *C.uchar, stride, width, height int) ([]byte, int) {
+	if p == nil || width <= 0 || height <= 0 {
+		return nil, 0
+	}
+	if stride >= 0 {
+		return unsafe.Slice((*byte)(unsafe.Pointer(p)), (height-1)*stride+width), 0
+	}
+	top := (height - 1) * -stride
+	base := unsafe.Add(unsafe.Pointer(p), -top)
+	return unsafe.Slice((*byte)(base), top+width), top
+}
+
+// limitedToFullLuma and limitedToFullChroma expand the samples of the
+// stream to the full range of image.YCbCr
+var limitedToFullLuma, limitedToFullChroma [256]byte
+
+func init() {
+	for i := 0; i < 256; i++ {
+		limitedToFullLuma[i] = clamp8(int32(((i-16)*510 + 219) / 438))
+		limitedToFullChroma[i] = clamp8(int32((i*510 - 7712) / 448))
+	}
+}
+
+// pictureRect returns the visible part of the frame given by inf. If
+// inf is nil, the whole frame is returned
+func (v *TheoraYUVbuffer) pictureRect(inf ITheoraInfo) image.Rectangle {
+	pic := image.Rect(0, 0, v.GetYWidth(), v.GetYHeight())
+	if inf != nil {
+		x, y := inf.GetOffsetX(), inf.GetOffsetY()
+		pic = image.Rect(x, y, x+inf.GetFrameWidth(), y+inf.GetFrameHeight()).Intersect(pic)
+	}
+	return pic
+}
+
+// ToYCbCr returns the visible part of the picture as an image.YCbCr.
+// The picture region is taken from inf. If inf is nil, the whole
+// encoded frame is returned. The bounds of the image are the picture
+// region in the coordinates of the frame, so the chroma samples of a
+// picture at odd offsets keep their positions. The samples are
+// expanded from the limited range of the stream to the full range of
+// image.YCbCr (JFIF). The planes are copied, so the image does not
+// depend on the C memory of the buffer. Returns nil if the chroma
+// subsampling of the buffer is not supported
+func (v *TheoraYUVbuffer) ToYCbCr(inf ITheoraInfo) *image.YCbCr {
+	ratio, ok := v.subsampleRatio()
+	if !ok {
+		return nil
+	}
+	pic := v.pictureRect(inf)
+	res := image.NewYCbCr(pic, ratio)
+	if pic.Empty() {
+		return res
+	}
+
+	ys, yo := planeView(v.fValue.y, v.GetYStride(), v.GetYWidth(), v.GetYHeight())
+	us, uo := planeView(v.fValue.u, v.GetUVStride(), v.GetUVWidth(), v.GetUVHeight())
+	vs, vo := planeView(v.fValue.v, v.GetUVStride(), v.GetUVWidth(), v.GetUVHeight())
+
+	ystride, uvstride := v.GetYStride(), v.GetUVStride()
+	for y := pic.Min.Y; y < pic.Max.Y; y++ {
+		src := ys[yo+y*ystride:]
+		dst := res.Y[res.YOffset(pic.Min.X, y):]
+		for x := 0; x < pic.Dx(); x++ {
+			dst[x] = limitedToFullLuma[src[pic.Min.X+x]]
+		}
+	}
+
+	xsh, ysh := 0, 0
+	switch ratio {
+	case image.YCbCrSubsampleRatio420:
+		xsh, ysh = 1, 1
+	case image.YCbCrSubsampleRatio422:
+		xsh = 1
+	}
+	cx0, cx1 := pic.Min.X>>xsh, ((pic.Max.X-1)>>xsh)+1
+	cy0, cy1 := pic.Min.Y>>ysh, ((pic.Max.Y-1)>>ysh)+1
+	for cy := cy0; cy < cy1; cy++ {
+		usrc, vsrc := us[uo+cy*uvstride:], vs[vo+cy*uvstride:]
+		co := (cy - cy0) * res.CStride
+		for cx := cx0; cx < cx1; cx++ {
+			res.Cb[co+cx-cx0] = limitedToFullChroma[usrc[cx]]
+			res.Cr[co+cx-cx0] = limitedToFullChroma[vsrc[cx]]
+		}
+	}
+	return res
+}
+
+// ToRGBA converts the visible part of the picture into an image.RGBA.
+// The picture region and the conversion options are taken from inf.
+// If inf is nil, the whole encoded frame is converted with BT.601
+func (v *TheoraYUVbuffer) ToRGBA(inf ITheoraInfo) *image.RGBA {
+	return v.ToRGBAOptions(inf, ConvertOptionsOf(inf))
+}
+
+// ToRGBAMatrix is like ToRGBA but uses the given conversion matrix
+func (v *TheoraYUVbuffer) ToRGBAMatrix(inf ITheoraInfo, m ColorMatrix) *image.RGBA {
+	opts := ConvertOptionsOf(inf)
+	opts.Matrix = m
+	return v.ToRGBAOptions(inf, opts)
+}
+
+// ToRGBAOptions is like ToRGBA but uses the given conversion options
+func (v *TheoraYUVbuffer) ToRGBAOptions(inf ITheoraInfo, opts ConvertOptions) *image.RGBA {
+	ratio, ok := v.subsampleRatio()
+	if !ok {
+		return nil
+	}
+
+	pic := v.pictureRect(inf)
+	res := image.NewRGBA(image.Rect(0, 0, pic.Dx(), pic.Dy()))
+	if pic.Empty() {
+		return res
+	}
+
+	ys, yo := planeView(v.fValue.y, v.GetYStride(), v.GetYWidth(), v.GetYHeight())
+	us, uo := planeView(v.fValue.u, v.GetUVStride(), v.GetUVWidth(), v.GetUVHeight())
+	vs, vo := planeView(v.fValue.v, v.GetUVStride(), v.GetUVWidth(), v.GetUVHeight())
+
+	xsh, ysh := 0, 0
+	switch ratio {
+	case image.YCbCrSubsampleRatio420:
+		xsh, ysh = 1, 1
+	case image.YCbCrSubsampleRatio422:
+		xsh = 1
+	}
+
+	k := opts.rgbCoefs()
+	ystride, uvstride := v.GetYStride(), v.GetUVStride()
+	convertBands(pic.Dy(), func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			sy := pic.Min.Y + y
+			yrow := yo + sy*ystride
+			urow := uo + (sy>>ysh)*uvstride
+			vrow := vo + (sy>>ysh)*uvstride
+			dst := res.Pix[y*res.Stride:]
+			for x := 0; x < pic.Dx(); x++ {
+				sx := pic.Min.X + x
+				yy := (int32(ys[yrow+sx]) - k.yoff) * k.y
+				cb := int32(us[urow+(sx>>xsh)]) - 128
+				cr := int32(vs[vrow+(sx>>xsh)]) - 128
+				dst[x*4] = clampRGB(yy + k.rv*cr)
+				dst[x*4+1] = clampRGB(yy - k.gu*cb - k.gv*cr)
+				dst[x*4+2] = clampRGB(yy + k.bu*cb)
+				dst[x*4+3] = 0xff
+			}
+		}
+	})
+	return res
+}
@@ -0,0 +1,217 @@
+/* GoTheora
+Wrapper for Theora library
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package gotheora
+
+/*
+#cgo CFLAGS: -I/usr/include
+#include "theora/theora.h"
+#include "theora/codec.h"
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+/* Error model.
+   The failures of the library calls are returned as *Error with the
+   result code of libtheora, the name of the operation and the number
+   of the frame if it is known. The errors of the underlying writers,
+   readers and ogg streams are wrapped into *Error too, except io.EOF
+   which is returned as is. The errors match the exported sentinels
+   with errors.Is:
+
+     errors.Is(err, ETheoraException)             any failure of libtheora
+     errors.Is(err, ETheoraDecBadPacketException) the sentinel in Err
+     errors.Is(err, &Error{Code: CodeBadPacket})  the result code
+     errors.Is(err, io.ErrShortWrite)             the wrapped error
+
+   The end of the encoding (ETheoraEncCompletedException) is not a
+   failure and does not match ETheoraException */
+
+// ErrorCode is a result code of libtheora. The values of the legacy
+// OC_* and the TH_* codes are the same
+type ErrorCode int
+
+const (
+	CodeOK ErrorCode = 0
+	// OC_FAULT, TH_EFAULT
+	CodeFault ErrorCode = C.OC_FAULT
+	// OC_EINVAL, TH_EINVAL
+	CodeInvalid ErrorCode = C.OC_EINVAL
+	// OC_DISABLED
+	CodeDisabled ErrorCode = C.OC_DISABLED
+	// OC_BADHEADER, TH_EBADHEADER
+	CodeBadHeader ErrorCode = C.OC_BADHEADER
+	// OC_NOTFORMAT, TH_ENOTFORMAT
+	CodeNotFormat ErrorCode = C.OC_NOTFORMAT
+	// OC_VERSION, TH_EVERSION
+	CodeVersion ErrorCode = C.OC_VERSION
+	// OC_IMPL, TH_EIMPL
+	CodeImpl ErrorCode = C.OC_IMPL
+	// OC_BADPACKET, TH_EBADPACKET
+	CodeBadPacket ErrorCode = C.OC_BADPACKET
+	// OC_NEWPACKET
+	CodeNewPacket ErrorCode = C.OC_NEWPACKET
+	// OC_DUPFRAME, TH_DUPFRAME. Not an error, the packet is a dropped
+	// frame
+	CodeDupFrame ErrorCode = C.OC_DUPFRAME
+)
+
+func (v ErrorCode) String() string {
+	switch v {
+	case CodeOK:
+		return "OK"
+	case CodeFault:
+		return "OC_FAULT"
+	case CodeInvalid:
+		return "OC_EINVAL"
+	case CodeDisabled:
+		return "OC_DISABLED"
+	case CodeBadHeader:
+		return "OC_BADHEADER"
+	case CodeNotFormat:
+		return "OC_NOTFORMAT"
+	case CodeVersion:
+		return "OC_VERSION"
+	case CodeImpl:
+		return "OC_IMPL"
+	case CodeBadPacket:
+		return "OC_BADPACKET"
+	case CodeNewPacket:
+		return "OC_NEWPACKET"
+	case CodeDupFrame:
+		return "OC_DUPFRAME"
+	}
+	return fmt.Sprintf("ErrorCode(%d)", int(v))
+}
+
+func (v ErrorCode) message() string {
+	switch v {
+	case CodeFault:
+		return "Unspecified error"
+	case CodeInvalid:
+		return "General failure"
+	case CodeDisabled:
+		return "Requested action is disabled"
+	case CodeBadHeader:
+		return "Header packet was corrupt/invalid"
+	case CodeNotFormat:
+		return "Packet is not a theora packet"
+	case CodeVersion:
+		return "Bitstream version is not handled"
+	case CodeImpl:
+		return "Feature or action not implemented"
+	case CodeBadPacket:
+		return "Packet is corrupt"
+	case CodeNewPacket:
+		return "Packet is an (ignorable) unhandled extension"
+	case CodeDupFrame:
+		return "Packet is a dropped frame"
+	default:
+		return "Unspecified encoder error"
+	}
+}
+
+// Error is an error of an operation of the wrapper
+type Error struct {
+	// Result code of libtheora, CodeOK for the errors of the wrapper
+	Code ErrorCode
+	// Operation which failed, for example "TheoraEncoder.YUVin"
+	Op string
+	// Number of the frame, -1 if the error is not related to a frame
+	Frame int64
+	// Underlying error: one of the ETheora* sentinels or the error of
+	// the writer, the reader or the ogg stream
+	Err error
+}
+
+func (e *Error) Error() string {
+	var msg string
+	if e.Err != nil {
+		msg = e.Err.Error()
+	} else {
+		msg = e.Code.message()
+	}
+	if e.Frame >= 0 {
+		msg = fmt.Sprintf("frame %d: %s", e.Frame, msg)
+	}
+	if len(e.Op) > 0 {
+		msg = e.Op + ": " + msg
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Failed reports whether the error is a failure of libtheora. The
+// dropped frames and the end of the encoding are not failures
+func (e *Error) Failed() bool {
+	return e.Code < 0 && e.Err != ETheoraEncCompletedException
+}
+
+// Is matches ETheoraException with any failure of libtheora and a
+// target *Error with the same non-zero Code and non-empty Op. The
+// other sentinels are matched through Err
+func (e *Error) Is(target error) bool {
+	if target == ETheoraException {
+		return e.Failed()
+	}
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	return (t.Code == CodeOK || t.Code == e.Code) &&
+		(len(t.Op) == 0 || t.Op == e.Op)
+}
+
+// newError returns the error of op with the result code of libtheora
+func newError(op string, code int) *Error {
+	return &Error{Code: ErrorCode(code), Op: op, Frame: -1}
+}
+
+// newFrameError returns the error of op on the frame. err is one of
+// the sentinels or nil
+func newFrameError(op string, code int, frame int64, err error) *Error {
+	return &Error{Code: ErrorCode(code), Op: op, Frame: frame, Err: err}
+}
+
+// wrapError wraps err of op on the frame. nil and io.EOF are returned
+// as is. An *Error gets the missing operation and frame
+func wrapError(op string, frame int64, err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	if e, ok := err.(*Error); ok {
+		res := *e
+		if len(res.Op) == 0 {
+			res.Op = op
+		}
+		if res.Frame < 0 {
+			res.Frame = frame
+		}
+		return &res
+	}
+	return &Error{Op: op, Frame: frame, Err: err}
+}
+
+// codeOf returns the result code of libtheora carried by err. The
+// errors of the wrapper are reported as CodeFault
+func codeOf(err error) int {
+	var e *Error
+	if errors.As(err, &e) && e.Code != CodeOK {
+		return int(e.Code)
+	}
+	return int(CodeFault)
+}
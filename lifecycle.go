@@ -0,0 +1,149 @@
+/* GoTheora
+Wrapper for Theora library
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package gotheora
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+/* Lifecycle.
+   Every wrapper holds C memory which is released by Close (Done is
+   the same without the result). Close may be called any number of
+   times. The finalizers release the wrappers which were not closed,
+   but the moment of that is not defined, so long-running programs
+   should close everything explicitly.
+
+   A state holds a reference to its info, so the info closed before
+   the state is released only when the state is closed too.
+
+   The wrappers count their live C allocations. In the leak tracking
+   mode the wrappers released by the finalizers without Close are
+   counted as leaked. A test enables the mode, runs the code, closes
+   everything and calls CheckLeaks */
+
+type allocKind int
+
+const (
+	allocTheoraInfo allocKind = iota
+	allocTheoraComment
+	allocTheoraState
+	allocTheoraYUVbuffer
+	allocThInfo
+	allocThComment
+	allocThYCbCrBuffer
+	allocThSetupInfo
+	allocThEncoder
+	allocThDecoder
+	allocOggSyncState
+	allocOggStreamState
+	allocKindCount
+)
+
+var allocKindNames = [allocKindCount]string{
+	"TheoraInfo",
+	"TheoraComment",
+	"TheoraState",
+	"TheoraYUVbuffer",
+	"ThInfo",
+	"ThComment",
+	"ThYCbCrBuffer",
+	"ThSetupInfo",
+	"ThEncoder",
+	"ThDecoder",
+	"oggSyncState",
+	"oggStreamState",
+}
+
+var (
+	fLeakTracking atomic.Bool
+	fLiveAllocs   [allocKindCount]atomic.Int64
+	fLeakedAllocs [allocKindCount]atomic.Int64
+)
+
+func trackAlloc(k allocKind) {
+	fLiveAllocs[k].Add(1)
+}
+
+func trackFree(k allocKind) {
+	fLiveAllocs[k].Add(-1)
+}
+
+// trackFinalized is called by the finalizer of a wrapper which was
+// not closed
+func trackFinalized(k allocKind) {
+	if fLeakTracking.Load() {
+		fLeakedAllocs[k].Add(1)
+	}
+}
+
+func allocCounters(c *[allocKindCount]atomic.Int64) map[string]int64 {
+	res := make(map[string]int64)
+	for k := range c {
+		if n := c[k].Load(); n != 0 {
+			res[allocKindNames[k]] = n
+		}
+	}
+	return res
+}
+
+// SetLeakTracking turns the leak tracking mode on or off. The leaked
+// counters are reset when the mode is turned on
+func SetLeakTracking(value bool) {
+	if value {
+		for k := range fLeakedAllocs {
+			fLeakedAllocs[k].Store(0)
+		}
+	}
+	fLeakTracking.Store(value)
+}
+
+func LeakTracking() bool {
+	return fLeakTracking.Load()
+}
+
+// LiveAllocations returns the number of the live C allocations by the
+// wrapper types. The types without allocations are omitted
+func LiveAllocations() map[string]int64 {
+	return allocCounters(&fLiveAllocs)
+}
+
+// LeakedAllocations returns the number of the wrappers released by
+// the finalizers without Close since the leak tracking mode was
+// turned on
+func LeakedAllocations() map[string]int64 {
+	return allocCounters(&fLeakedAllocs)
+}
+
+func formatAllocCounters(c map[string]int64) string {
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		names[i] = fmt.Sprintf("%s: %d", name, c[name])
+	}
+	return strings.Join(names, ", ")
+}
+
+// CheckLeaks returns ETheoraLeakException if some wrappers are still
+// alive or were released by the finalizers without Close. Run the
+// garbage collector before the check to count the lost wrappers
+func CheckLeaks() error {
+	live, leaked := LiveAllocations(), LeakedAllocations()
+	if len(live) == 0 && len(leaked) == 0 {
+		return nil
+	}
+	return errTheoraLeakException{formatAllocCounters(live), formatAllocCounters(leaked)}
+}
@@ -0,0 +1,345 @@
+/* GoTheora
+Wrapper for Theora library
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package gotheora
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"time"
+)
+
+/* Common comment fields */
+
+const (
+	TagTitle     = "TITLE"
+	TagArtist    = "ARTIST"
+	TagDate      = "DATE"
+	TagLanguage  = "LANGUAGE"
+	TagLicense   = "LICENSE"
+	TagEncodedBy = "ENCODED_BY"
+	// Base64 encoded FLAC picture block
+	TagMetadataBlockPicture = "METADATA_BLOCK_PICTURE"
+)
+
+// Layout of the DATE field written by SetDate
+const commentDateLayout = "2006-01-02"
+
+func (v *TheoraComment) GetTitle() string {
+	return v.Query(TagTitle, 0)
+}
+
+func (v *TheoraComment) SetTitle(AValue string) error {
+	return v.Replace(TagTitle, AValue)
+}
+
+func (v *TheoraComment) GetArtist() string {
+	return v.Query(TagArtist, 0)
+}
+
+func (v *TheoraComment) SetArtist(AValue string) error {
+	return v.Replace(TagArtist, AValue)
+}
+
+// GetDate returns the DATE field as it is stored. It may be a year,
+// a date or any other text
+func (v *TheoraComment) GetDate() string {
+	return v.Query(TagDate, 0)
+}
+
+// SetDate sets the DATE field in the form YYYY-MM-DD
+func (v *TheoraComment) SetDate(AValue time.Time) error {
+	return v.Replace(TagDate, AValue.Format(commentDateLayout))
+}
+
+func (v *TheoraComment) GetLanguage() string {
+	return v.Query(TagLanguage, 0)
+}
+
+func (v *TheoraComment) SetLanguage(AValue string) error {
+	return v.Replace(TagLanguage, AValue)
+}
+
+func (v *TheoraComment) GetLicense() string {
+	return v.Query(TagLicense, 0)
+}
+
+func (v *TheoraComment) SetLicense(AValue string) error {
+	return v.Replace(TagLicense, AValue)
+}
+
+/* METADATA_BLOCK_PICTURE.
+   The picture is stored as a FLAC picture metadata block: the
+   picture type, the MIME type, the description, the width, the
+   height, the color depth, the number of indexed colors and the
+   picture data. All the numbers are 32-bit big-endian, the strings
+   and the data are prefixed with their lengths */
+
+// PictureType is the type of a picture as defined by ID3v2 APIC
+type PictureType uint32
+
+const (
+	PictureOther PictureType = iota
+	// 32x32 pixels PNG file icon
+	PictureFileIcon
+	PictureOtherFileIcon
+	PictureFrontCover
+	PictureBackCover
+	PictureLeaflet
+	PictureMedia
+	PictureLeadArtist
+	PictureArtist
+	PictureConductor
+	PictureBand
+	PictureComposer
+	PictureLyricist
+	PictureRecordingLocation
+	PictureDuringRecording
+	PictureDuringPerformance
+	PictureScreenCapture
+	PictureBrightFish
+	PictureIllustration
+	PictureBandLogo
+	PicturePublisherLogo
+)
+
+// TheoraPicture is a picture attached to the stream
+type TheoraPicture struct {
+	Type        PictureType
+	MIME        string
+	Description string
+	Width       int
+	Height      int
+	// Color depth, bits per pixel
+	Depth int
+	// Number of colors for indexed pictures, 0 otherwise
+	Colors int
+	// Encoded picture data
+	Data []byte
+}
+
+func pictureFormat(data []byte) (mime string, ok bool) {
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png", true
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		return "image/jpeg", true
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return "image/gif", true
+	}
+	return "", false
+}
+
+// colorDepth returns the bits per pixel of the color model reported by
+// DecodeConfig. The PNG decoder reports the truecolor images without
+// alpha as RGBA and RGBA64, the ones with alpha as NRGBA and NRGBA64
+func colorDepth(m color.Model) (depth, colors int) {
+	switch m {
+	case color.GrayModel:
+		return 8, 0
+	case color.Gray16Model:
+		return 16, 0
+	case color.YCbCrModel, color.RGBAModel:
+		return 24, 0
+	case color.RGBA64Model:
+		return 48, 0
+	case color.NRGBA64Model:
+		return 64, 0
+	}
+	if p, ok := m.(color.Palette); ok {
+		return 8, len(p)
+	}
+	return 32, 0
+}
+
+// NewPictureFromData makes a picture of the encoded PNG, JPEG or GIF
+// data. The sizes and the color depth are read from the data
+func NewPictureFromData(ptype PictureType, data []byte, description string) (*TheoraPicture, error) {
+	mime, ok := pictureFormat(data)
+	if !ok {
+		return nil, ETheoraInvalidPictureException
+	}
+	var cfg image.Config
+	var err error
+	switch mime {
+	case "image/png":
+		cfg, err = png.DecodeConfig(bytes.NewReader(data))
+	case "image/jpeg":
+		cfg, err = jpeg.DecodeConfig(bytes.NewReader(data))
+	default:
+		cfg, err = gif.DecodeConfig(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, ETheoraInvalidPictureException
+	}
+	res := &TheoraPicture{
+		Type:        ptype,
+		MIME:        mime,
+		Description: description,
+		Width:       cfg.Width,
+		Height:      cfg.Height,
+		Data:        data,
+	}
+	res.Depth, res.Colors = colorDepth(cfg.ColorModel)
+	return res, nil
+}
+
+// NewPictureFromImage makes a picture of img encoded as PNG
+func NewPictureFromImage(ptype PictureType, img image.Image, description string) (*TheoraPicture, error) {
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		return nil, err
+	}
+	return NewPictureFromData(ptype, buf.Bytes(), description)
+}
+
+// Image decodes the picture data
+func (p *TheoraPicture) Image() (image.Image, error) {
+	switch p.MIME {
+	case "image/png":
+		return png.Decode(bytes.NewReader(p.Data))
+	case "image/jpeg", "image/jpg":
+		return jpeg.Decode(bytes.NewReader(p.Data))
+	case "image/gif":
+		return gif.Decode(bytes.NewReader(p.Data))
+	}
+	return nil, ETheoraInvalidPictureException
+}
+
+// MarshalBinary returns the FLAC picture block
+func (p *TheoraPicture) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	put := func(v uint32) {
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+	putBytes := func(b []byte) {
+		put(uint32(len(b)))
+		buf.Write(b)
+	}
+	put(uint32(p.Type))
+	putBytes([]byte(p.MIME))
+	putBytes([]byte(p.Description))
+	put(uint32(p.Width))
+	put(uint32(p.Height))
+	put(uint32(p.Depth))
+	put(uint32(p.Colors))
+	putBytes(p.Data)
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary parses the FLAC picture block
+func (p *TheoraPicture) UnmarshalBinary(data []byte) error {
+	get := func() (uint32, bool) {
+		if len(data) < 4 {
+			return 0, false
+		}
+		v := binary.BigEndian.Uint32(data)
+		data = data[4:]
+		return v, true
+	}
+	getBytes := func() ([]byte, bool) {
+		n, ok := get()
+		if !ok || uint64(n) > uint64(len(data)) {
+			return nil, false
+		}
+		v := data[:n]
+		data = data[n:]
+		return v, true
+	}
+
+	var nums [5]uint32
+	var mime, desc, pic []byte
+	var ok bool
+	nums[0], ok = get()
+	if ok {
+		mime, ok = getBytes()
+	}
+	if ok {
+		desc, ok = getBytes()
+	}
+	for i := 1; ok && i < len(nums); i++ {
+		nums[i], ok = get()
+	}
+	if ok {
+		pic, ok = getBytes()
+	}
+	if !ok {
+		return ETheoraInvalidPictureException
+	}
+
+	*p = TheoraPicture{
+		Type:        PictureType(nums[0]),
+		MIME:        string(mime),
+		Description: string(desc),
+		Width:       int(nums[1]),
+		Height:      int(nums[2]),
+		Depth:       int(nums[3]),
+		Colors:      int(nums[4]),
+		Data:        bytes.Clone(pic),
+	}
+	return nil
+}
+
+// ParsePicture decodes the value of a METADATA_BLOCK_PICTURE comment
+func ParsePicture(value string) (*TheoraPicture, error) {
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ETheoraInvalidPictureException
+	}
+	res := new(TheoraPicture)
+	err = res.UnmarshalBinary(data)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// AddPicture appends the picture as a METADATA_BLOCK_PICTURE comment
+func (v *TheoraComment) AddPicture(p *TheoraPicture) error {
+	data, err := p.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return v.AddTagChecked(TagMetadataBlockPicture, base64.StdEncoding.EncodeToString(data))
+}
+
+// Pictures returns all the pictures attached to the stream. The
+// comments which can not be parsed are skipped
+func (v *TheoraComment) Pictures() []*TheoraPicture {
+	var res []*TheoraPicture
+	for i := 0; i < v.TagsCount(); i++ {
+		comment := v.GetTag(i)
+		if !commentHasTag(comment, TagMetadataBlockPicture) {
+			continue
+		}
+		p, err := ParsePicture(comment[len(TagMetadataBlockPicture)+1:])
+		if err == nil {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// Picture returns the first picture of the type or nil
+func (v *TheoraComment) Picture(ptype PictureType) *TheoraPicture {
+	for _, p := range v.Pictures() {
+		if p.Type == ptype {
+			return p
+		}
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+/* GoTheora
+Wrapper for Theora library
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package gotheora
+
+/*
+#cgo CFLAGS: -I/usr/include
+#cgo LDFLAGS: -logg
+#include "ogg/ogg.h"
+#include <stdlib.h>
+
+int size_of_struct_ogg_sync_state() {
+    return sizeof(ogg_sync_state);
+}
+
+int size_of_struct_ogg_stream_state() {
+    return sizeof(ogg_stream_state);
+}
+
+*/
+import "C"
+import (
+	"io"
+	"unsafe"
+
+	OGG "github.com/ilya2ik/googg"
+)
+
+/* Low-level helpers around libogg demuxing. The googg packets are
+   filled directly through their C references */
+
+const oggReadChunk = 4096
+
+func oggPacketRef(op OGG.IOGGPacket) *C.ogg_packet {
+	return (*C.ogg_packet)(unsafe.Pointer(op.Ref()))
+}
+
+func oggPacketBytes(op OGG.IOGGPacket) []byte {
+	p := oggPacketRef(op)
+	if p.packet == nil || p.bytes <= 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(p.packet)), int(p.bytes))
+}
+
+/* oggSyncState */
+
+type oggSyncState struct {
+	fValue *C.ogg_sync_state
+	// Position in the physical stream after the last fed byte
+	fFed int64
+}
+
+func newOggSyncState() (*oggSyncState, error) {
+	value := new(oggSyncState)
+
+	mem, err := C.calloc(1, (C.size_t)(C.size_of_struct_ogg_sync_state()))
+	if mem == nil {
+		return nil, errTheoraOutOfMemory{err}
+	}
+	value.fValue = (*C.ogg_sync_state)(mem)
+	C.ogg_sync_init(value.fValue)
+	trackAlloc(allocOggSyncState)
+	return value, nil
+}
+
+func (v *oggSyncState) Done() {
+	if v.fValue != nil {
+		C.ogg_sync_clear(v.fValue)
+		C.free(unsafe.Pointer(v.fValue))
+		v.fValue = nil
+		trackFree(allocOggSyncState)
+	}
+}
+
+func (v *oggSyncState) Reset() {
+	C.ogg_sync_reset(v.fValue)
+}
+
+// ResetAt drops the buffered data. The next fed byte is at pos in the
+// physical stream
+func (v *oggSyncState) ResetAt(pos int64) {
+	C.ogg_sync_reset(v.fValue)
+	v.fFed = pos
+}
+
+// Position returns the position in the physical stream just after
+// the last page returned
+func (v *oggSyncState) Position() int64 {
+	return v.fFed - int64(v.fValue.fill-v.fValue.returned)
+}
+
+// Feed reads the next chunk of the physical stream directly into
+// the sync buffer
+func (v *oggSyncState) Feed(str io.Reader, size int) (int, error) {
+	buf := C.ogg_sync_buffer(v.fValue, C.long(size))
+	if buf == nil {
+		return 0, ETheoraOutOfMemory
+	}
+	n, err := str.Read(unsafe.Slice((*byte)(unsafe.Pointer(buf)), size))
+	if n > 0 {
+		C.ogg_sync_wrote(v.fValue, C.long(n))
+		v.fFed += int64(n)
+	}
+	return n, err
+}
+
+func (v *oggSyncState) PageOut(og *C.ogg_page) int {
+	return int(C.ogg_sync_pageout(v.fValue, og))
+}
+
+func (v *oggSyncState) PageSeek(og *C.ogg_page) int {
+	return int(C.ogg_sync_pageseek(v.fValue, og))
+}
+
+// ReadPage returns the next page of the physical stream str. The
+// unsynced bytes are skipped
+func (v *oggSyncState) ReadPage(str io.Reader, og *C.ogg_page) error {
+	for {
+		res := v.PageOut(og)
+		if res > 0 {
+			return nil
+		}
+		if res < 0 {
+			/* skip the unsynced bytes */
+			continue
+		}
+		n, err := v.Feed(str, oggReadChunk)
+		if n == 0 && err != nil {
+			return err
+		}
+	}
+}
+
+/* oggStreamState */
+
+type oggStreamState struct {
+	fValue *C.ogg_stream_state
+}
+
+func newOggStreamState(serialno int32) (*oggStreamState, error) {
+	value := new(oggStreamState)
+
+	mem, err := C.calloc(1, (C.size_t)(C.size_of_struct_ogg_stream_state()))
+	if mem == nil {
+		return nil, errTheoraOutOfMemory{err}
+	}
+	value.fValue = (*C.ogg_stream_state)(mem)
+	C.ogg_stream_init(value.fValue, C.int(serialno))
+	trackAlloc(allocOggStreamState)
+	return value, nil
+}
+
+func (v *oggStreamState) Done() {
+	if v.fValue != nil {
+		C.ogg_stream_clear(v.fValue)
+		C.free(unsafe.Pointer(v.fValue))
+		v.fValue = nil
+		trackFree(allocOggStreamState)
+	}
+}
+
+func (v *oggStreamState) Reset() {
+	C.ogg_stream_reset(v.fValue)
+}
+
+func (v *oggStreamState) PageIn(og *C.ogg_page) bool {
+	return C.ogg_stream_pagein(v.fValue, og) == 0
+}
+
+func (v *oggStreamState) PacketOut(op OGG.IOGGPacket) int {
+	return int(C.ogg_stream_packetout(v.fValue, oggPacketRef(op)))
+}
+
+func (v *oggStreamState) PacketPeek(op OGG.IOGGPacket) int {
+	return int(C.ogg_stream_packetpeek(v.fValue, oggPacketRef(op)))
+}
+
+// PacketIn submits a copy of data as the next packet of the stream
+func (v *oggStreamState) PacketIn(data []byte, bos, eos bool, granulepos, packetno int64) bool {
+	var op C.ogg_packet
+	buf := C.CBytes(data)
+	defer C.free(buf)
+	op.packet = (*C.uchar)(buf)
+	op.bytes = C.long(len(data))
+	if bos {
+		op.b_o_s = 1
+	}
+	if eos {
+		op.e_o_s = 1
+	}
+	op.granulepos = C.ogg_int64_t(granulepos)
+	op.packetno = C.ogg_int64_t(packetno)
+	return C.ogg_stream_packetin(v.fValue, &op) == 0
+}
+
+// Flush forces the submitted packets into a page. Returns false if
+// there is no data left
+func (v *oggStreamState) Flush(og *C.ogg_page) bool {
+	return C.ogg_stream_flush(v.fValue, og) != 0
+}
+
+/* ogg_page accessors */
+
+func oggPageSerialNo(og *C.ogg_page) int32 {
+	return int32(C.ogg_page_serialno(og))
+}
+
+func oggPageBOS(og *C.ogg_page) bool {
+	return C.ogg_page_bos(og) != 0
+}
+
+func oggPageEOS(og *C.ogg_page) bool {
+	return C.ogg_page_eos(og) != 0
+}
+
+func oggPageGranulePos(og *C.ogg_page) int64 {
+	return int64(C.ogg_page_granulepos(og))
+}
+
+func oggPagePageNo(og *C.ogg_page) int64 {
+	return int64(C.ogg_page_pageno(og))
+}
+
+func oggPageHeader(og *C.ogg_page) []byte {
+	return unsafe.Slice((*byte)(unsafe.Pointer(og.header)), int(og.header_len))
+}
+
+func oggPageBody(og *C.ogg_page) []byte {
+	if og.body_len == 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(og.body)), int(og.body_len))
+}
+
+// oggPageSetPageNo rewrites the page sequence number and the CRC
+func oggPageSetPageNo(og *C.ogg_page, pageno int64) {
+	hdr := oggPageHeader(og)
+	for i := 0; i < 4; i++ {
+		hdr[18+i] = byte(pageno >> (8 * i))
+	}
+	C.ogg_page_checksum_set(og)
+}
+
+// oggPageEndsPacket checks that the last packet of the page is not
+// continued on the next page
+func oggPageEndsPacket(og *C.ogg_page) bool {
+	hdr := oggPageHeader(og)
+	segs := int(hdr[26])
+	return segs == 0 || hdr[26+segs] < 255
+}
+
+func oggPageWrite(w io.Writer, og *C.ogg_page) error {
+	_, err := w.Write(oggPageHeader(og))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(oggPageBody(og))
+	return err
+}
@@ -0,0 +1,157 @@
+/* GoTheora
+Wrapper for Theora library
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package gotheora
+
+import (
+	"context"
+	"errors"
+	"image"
+	"io"
+)
+
+/* Encode and decode pipelines.
+   The pipelines check the context between the frames. When the
+   context is done, EncodeFrames encodes the picture it already holds
+   as the last frame, so the stream is terminated with a valid
+   end-of-stream page, and returns ctx.Err(). DecodeFrames stops
+   before the next frame. Both return the number of the processed
+   frames */
+
+// ITheoraImageSource is the sequence of the pictures for EncodeFrames
+type ITheoraImageSource interface {
+	// NextImage returns the next picture or io.EOF after the last one
+	NextImage(ctx context.Context) (image.Image, error)
+}
+
+// ImageSourceFunc is an ITheoraImageSource function
+type ImageSourceFunc func(ctx context.Context) (image.Image, error)
+
+func (f ImageSourceFunc) NextImage(ctx context.Context) (image.Image, error) {
+	return f(ctx)
+}
+
+// ITheoraFrameSink receives the frames decoded by DecodeFrames
+type ITheoraFrameSink interface {
+	// WriteFrame receives the next frame. The buffer of the frame is
+	// valid until the call returns
+	WriteFrame(ctx context.Context, frame *TheoraFrame) error
+}
+
+// FrameSinkFunc is an ITheoraFrameSink function
+type FrameSinkFunc func(ctx context.Context, frame *TheoraFrame) error
+
+func (f FrameSinkFunc) WriteFrame(ctx context.Context, frame *TheoraFrame) error {
+	return f(ctx, frame)
+}
+
+// EncodeFrames encodes the pictures of src into str with the encoder
+// configured by cfg. One picture is read ahead to mark the last frame
+// of the stream. If the context is done or src fails, the picture
+// read ahead becomes the last frame, the stream is completed and the
+// error is returned. If there is no picture at all, the stream ends
+// with an empty end-of-stream packet after the headers
+func EncodeFrames(ctx context.Context, cfg EncoderConfig, src ITheoraImageSource, str io.Writer) (int64, error) {
+	err := ctx.Err()
+	if err != nil {
+		return 0, err
+	}
+	enc, err := NewTheoraEncoderConfig(cfg, str)
+	if err != nil {
+		return 0, err
+	}
+	pool, err := NewYUVBufferPool(1)
+	if err != nil {
+		enc.Close()
+		return 0, err
+	}
+	defer pool.Close()
+	enc.SetBufferPool(pool)
+
+	err = enc.SaveDefHeadersToStream()
+	if err != nil {
+		enc.Close()
+		return 0, err
+	}
+
+	var cnt int64
+	cur, srcErr := src.NextImage(ctx)
+	for srcErr == nil {
+		var next image.Image
+		srcErr = ctx.Err()
+		if srcErr == nil {
+			next, srcErr = src.NextImage(ctx)
+		}
+		err = enc.SaveImageToStream(cur, srcErr != nil)
+		if err != nil {
+			enc.Close()
+			return cnt, err
+		}
+		cnt++
+		cur = next
+	}
+
+	if cnt == 0 {
+		/* no frame is marked as the last one */
+		err = enc.SaveEOSToStream()
+		if err != nil {
+			enc.Close()
+			return 0, err
+		}
+	}
+	err = enc.Close()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		srcErr = ctxErr
+	} else if srcErr == io.EOF {
+		return cnt, err
+	}
+	if err != nil {
+		return cnt, errors.Join(srcErr, err)
+	}
+	return cnt, srcErr
+}
+
+// DecodeFrames reads the frames of the reader and passes them to sink
+// until io.EOF. If the reader has no buffer pool, a pool is used for
+// the time of the call, so the frames are decoded without allocations
+func DecodeFrames(ctx context.Context, reader ITheoraReader, sink ITheoraFrameSink) (int64, error) {
+	if reader.BufferPool() == nil {
+		pool, err := NewYUVBufferPool(1)
+		if err != nil {
+			return 0, err
+		}
+		reader.SetBufferPool(pool)
+		defer func() {
+			reader.SetBufferPool(nil)
+			pool.Close()
+		}()
+	}
+
+	var cnt int64
+	for {
+		err := ctx.Err()
+		if err != nil {
+			return cnt, err
+		}
+		frame, err := reader.ReadFrame()
+		if err == io.EOF {
+			return cnt, nil
+		}
+		if err != nil {
+			return cnt, err
+		}
+		err = sink.WriteFrame(ctx, frame)
+		frame.Release()
+		if err != nil {
+			return cnt, err
+		}
+		cnt++
+	}
+}
@@ -0,0 +1,256 @@
+/* GoTheora
+Wrapper for Theora library
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package gotheora
+
+/*
+#cgo CFLAGS: -I/usr/include
+#include "theora/theora.h"
+*/
+import "C"
+import (
+	"image"
+	"sync"
+)
+
+/* YUVBufferPool.
+   A pool of the frame buffers keyed by the frame size and the chroma
+   format. The buffers keep their planes in C memory between the uses,
+   so the frames of the same size are converted, encoded and decoded
+   without new allocations. A buffer taken from the pool is returned
+   with Put when it is not needed anymore. The content of a buffer
+   taken from the pool is undefined until it is filled. The pool is
+   safe for concurrent use */
+
+type IYUVBufferPool interface {
+	Get(width, height int, chroma_format image.YCbCrSubsampleRatio) (ITheoraYUVbuffer, error)
+	GetInfo(inf ITheoraInfo) (ITheoraYUVbuffer, error)
+	Put(buf ITheoraYUVbuffer)
+	Len() int
+	Close() error
+
+	Clone(src ITheoraYUVbuffer) (ITheoraYUVbuffer, error)
+	ConvertFromRasterImageInfo(inf ITheoraInfo, aData image.Image) (ITheoraYUVbuffer, error)
+	ConvertFromRasterImageOptions(chroma_format image.YCbCrSubsampleRatio, aData image.Image, opts ConvertOptions) (ITheoraYUVbuffer, error)
+}
+
+// yuvBufferKey is the frame size and the chroma format of the buffer
+type yuvBufferKey struct {
+	width, height int
+	format        image.YCbCrSubsampleRatio
+}
+
+// chromaSize returns the size of the chroma planes
+func (k yuvBufferKey) chromaSize() (int, int) {
+	w, h := k.width, k.height
+	if k.format != image.YCbCrSubsampleRatio444 {
+		w >>= 1
+	}
+	if k.format == image.YCbCrSubsampleRatio420 {
+		h >>= 1
+	}
+	return w, h
+}
+
+func (k yuvBufferKey) valid() bool {
+	return k.width > 0 && k.height > 0 &&
+		(k.format == image.YCbCrSubsampleRatio444 ||
+			k.format == image.YCbCrSubsampleRatio422 ||
+			k.format == image.YCbCrSubsampleRatio420)
+}
+
+// yuvBufferKeyOf returns the key of the buffer. The buffers which do
+// not own their packed planes can not be pooled
+func yuvBufferKeyOf(buf *TheoraYUVbuffer) (yuvBufferKey, bool) {
+	if buf.Ref() == nil || !buf.GetOwnData() ||
+		buf.GetYStride() != buf.GetYWidth() || buf.GetUVStride() != buf.GetUVWidth() {
+		return yuvBufferKey{}, false
+	}
+	return yuvBufferKeyOfSize(buf)
+}
+
+// yuvBufferKeyOfSize finds the chroma format by the sizes of the planes
+func yuvBufferKeyOfSize(buf ITheoraYUVbuffer) (yuvBufferKey, bool) {
+	res := yuvBufferKey{width: buf.GetYWidth(), height: buf.GetYHeight()}
+	for _, f := range []image.YCbCrSubsampleRatio{
+		image.YCbCrSubsampleRatio420,
+		image.YCbCrSubsampleRatio422,
+		image.YCbCrSubsampleRatio444} {
+		res.format = f
+		if w, h := res.chromaSize(); w == buf.GetUVWidth() && h == buf.GetUVHeight() {
+			return res, res.valid()
+		}
+	}
+	return yuvBufferKey{}, false
+}
+
+type YUVBufferPool struct {
+	fLock    sync.Mutex
+	fFree    map[yuvBufferKey][]*TheoraYUVbuffer
+	fMaxFree int
+	fClosed  bool
+}
+
+// NewYUVBufferPool creates a pool which keeps up to maxFree free
+// buffers for each frame size, 0 keeps all the returned buffers
+func NewYUVBufferPool(maxFree int) (IYUVBufferPool, error) {
+	if maxFree < 0 {
+		return nil, newError("NewYUVBufferPool", C.OC_EINVAL)
+	}
+	value := new(YUVBufferPool)
+	value.fFree = make(map[yuvBufferKey][]*TheoraYUVbuffer)
+	value.fMaxFree = maxFree
+	return value, nil
+}
+
+func (v *YUVBufferPool) get(key yuvBufferKey) (*TheoraYUVbuffer, error) {
+	if !key.valid() {
+		return nil, newError("YUVBufferPool.Get", C.OC_EINVAL)
+	}
+	v.fLock.Lock()
+	if free := v.fFree[key]; len(free) > 0 {
+		res := free[len(free)-1]
+		free[len(free)-1] = nil
+		v.fFree[key] = free[:len(free)-1]
+		v.fLock.Unlock()
+		return res, nil
+	}
+	v.fLock.Unlock()
+
+	buf, err := NewTheoraYUVbuffer()
+	if err != nil {
+		return nil, err
+	}
+	res := buf.(*TheoraYUVbuffer)
+	uvw, uvh := key.chromaSize()
+	err = res.Alloc(key.width, key.height, uvw, uvh)
+	if err != nil {
+		res.Done()
+		return nil, err
+	}
+	return res, nil
+}
+
+// Get takes a buffer with the planes for the frame of the given size
+// and chroma format
+func (v *YUVBufferPool) Get(width, height int, chroma_format image.YCbCrSubsampleRatio) (ITheoraYUVbuffer, error) {
+	res, err := v.get(yuvBufferKey{width, height, chroma_format})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetInfo takes a buffer for the frames of the stream described by inf
+func (v *YUVBufferPool) GetInfo(inf ITheoraInfo) (ITheoraYUVbuffer, error) {
+	return v.Get(inf.GetWidth(), inf.GetHeight(), inf.GetPixelFormat())
+}
+
+// Put returns the buffer to the pool. The buffers which can not be
+// pooled or do not fit into the pool are closed. The buffer must not
+// be used after Put
+func (v *YUVBufferPool) Put(buf ITheoraYUVbuffer) {
+	res, ok := buf.(*TheoraYUVbuffer)
+	if !ok || res == nil {
+		return
+	}
+	key, ok := yuvBufferKeyOf(res)
+	if ok {
+		v.fLock.Lock()
+		free := v.fFree[key]
+		if !v.fClosed && (v.fMaxFree == 0 || len(free) < v.fMaxFree) {
+			v.fFree[key] = append(free, res)
+			v.fLock.Unlock()
+			return
+		}
+		v.fLock.Unlock()
+	}
+	res.Done()
+}
+
+// Len returns the number of the free buffers in the pool
+func (v *YUVBufferPool) Len() int {
+	v.fLock.Lock()
+	defer v.fLock.Unlock()
+	res := 0
+	for _, free := range v.fFree {
+		res += len(free)
+	}
+	return res
+}
+
+// Close releases the free buffers. The buffers returned after Close
+// are released by Put. It is safe to call Close more than once
+func (v *YUVBufferPool) Close() error {
+	v.fLock.Lock()
+	free := v.fFree
+	v.fFree = make(map[yuvBufferKey][]*TheoraYUVbuffer)
+	v.fClosed = true
+	v.fLock.Unlock()
+
+	for _, bufs := range free {
+		for _, buf := range bufs {
+			buf.Done()
+		}
+	}
+	return nil
+}
+
+// Clone copies the picture of src into a buffer taken from the pool
+func (v *YUVBufferPool) Clone(src ITheoraYUVbuffer) (ITheoraYUVbuffer, error) {
+	key, ok := yuvBufferKeyOfSize(src)
+	if !ok {
+		return src.Clone()
+	}
+	res, err := v.get(key)
+	if err != nil {
+		return nil, err
+	}
+	err = src.CopyTo(res)
+	if err != nil {
+		v.Put(res)
+		return nil, err
+	}
+	return res, nil
+}
+
+// ConvertFromRasterImageInfo converts aData into a buffer taken from
+// the pool like TheoraYUVbuffer.ConvertFromRasterImageInfo
+func (v *YUVBufferPool) ConvertFromRasterImageInfo(inf ITheoraInfo, aData image.Image) (ITheoraYUVbuffer, error) {
+	opts := ConvertOptionsOf(inf)
+	frame, _, ok := infoLayout(inf, opts, aData.Bounds())
+	if !ok {
+		return nil, ETheoraConvertException
+	}
+	res, err := v.get(yuvBufferKey{frame.X, frame.Y, inf.GetPixelFormat()})
+	if err != nil {
+		return nil, err
+	}
+	if !res.ConvertFromRasterImageInfo(inf, aData) {
+		v.Put(res)
+		return nil, ETheoraConvertException
+	}
+	return res, nil
+}
+
+// ConvertFromRasterImageOptions converts aData into a buffer taken
+// from the pool like TheoraYUVbuffer.ConvertFromRasterImageOptions
+func (v *YUVBufferPool) ConvertFromRasterImageOptions(chroma_format image.YCbCrSubsampleRatio, aData image.Image, opts ConvertOptions) (ITheoraYUVbuffer, error) {
+	frame, _ := opts.Layout(aData.Bounds().Dx(), aData.Bounds().Dy())
+	res, err := v.get(yuvBufferKey{frame.X, frame.Y, chroma_format})
+	if err != nil {
+		return nil, err
+	}
+	if !res.ConvertFromRasterImageOptions(chroma_format, aData, opts) {
+		v.Put(res)
+		return nil, ETheoraConvertException
+	}
+	return res, nil
+}
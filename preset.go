@@ -0,0 +1,147 @@
+/* GoTheora
+Wrapper for Theora library
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package gotheora
+
+/* Encoder presets.
+   A preset sets the rate control, keyframe, speed and filtering
+   fields of an EncoderConfig together. The bitrates of the presets
+   are given in bits per pixel and depend on the picture size and the
+   frame rate of the config, so the preset is applied after they are
+   set. Any field can be overridden after the preset */
+
+// Speed levels of EncoderConfig
+const (
+	// Keep the speed level chosen by the encoder
+	SpeedLevelDefault = -1
+	// The fastest level supported by the encoder
+	SpeedLevelFastest = 1 << 16
+)
+
+type Preset int
+
+const (
+	// Best quality for long-term storage, slow
+	PresetArchive Preset = iota
+	// Bitrate limited streams for progressive download, keyframes
+	// every two seconds for seeking
+	PresetWeb
+	// Sharp static content with rare changes, screen recordings
+	PresetScreencast
+	// Real-time streaming, the fastest encoding with short keyframe
+	// intervals and dropped frames
+	PresetLowLatency
+)
+
+func (p Preset) String() string {
+	switch p {
+	case PresetArchive:
+		return "archive"
+	case PresetWeb:
+		return "web"
+	case PresetScreencast:
+		return "screencast"
+	case PresetLowLatency:
+		return "low-latency"
+	}
+	return "unknown"
+}
+
+// bitrate returns the bitrate for bpp bits per pixel of the picture
+func (c EncoderConfig) bitrate(bpp float64) int {
+	if c.FPSDenominator <= 0 {
+		return 0
+	}
+	fps := float64(c.FPSNumerator) / float64(c.FPSDenominator)
+	return min(int(float64(c.Width*c.Height)*fps*bpp), maxBitrate)
+}
+
+// keyframeSeconds returns the number of frames in s seconds, at
+// least 1
+func (c EncoderConfig) keyframeSeconds(s int) int {
+	if c.FPSDenominator <= 0 {
+		return 1
+	}
+	return max(s*c.FPSNumerator/c.FPSDenominator, 1)
+}
+
+// ApplyPreset sets the fields of the preset. The picture size and the
+// frame rate must be set before
+func (c *EncoderConfig) ApplyPreset(p Preset) {
+	switch p {
+	case PresetArchive:
+		c.Quality = 56
+		c.Bitrate = 0
+		c.KeyframeFrequency = 256
+		c.KeyframeMinDistance = 8
+		c.KeyframeAuto = true
+		c.KeyframeAutoThreshold = 80
+		c.DropFrames = false
+		c.Quick = false
+		c.NoiseSensitivity = 0
+		c.Sharpness = 0
+		c.SpeedLevel = 0
+		c.RateFlags = 0
+		c.RateBuffer = 0
+	case PresetWeb:
+		c.Quality = 40
+		c.Bitrate = c.bitrate(0.1)
+		c.KeyframeFrequency = c.keyframeSeconds(2)
+		c.KeyframeMinDistance = min(8, c.KeyframeFrequency)
+		c.KeyframeAuto = true
+		c.KeyframeAutoThreshold = 80
+		c.DropFrames = false
+		c.Quick = true
+		c.NoiseSensitivity = 1
+		c.Sharpness = 1
+		c.SpeedLevel = 1
+		c.RateFlags = RateCapOverflow
+		c.RateBuffer = 0
+	case PresetScreencast:
+		c.Quality = 48
+		c.Bitrate = 0
+		c.KeyframeFrequency = c.keyframeSeconds(10)
+		c.KeyframeMinDistance = min(4, c.KeyframeFrequency)
+		c.KeyframeAuto = true
+		c.KeyframeAutoThreshold = 90
+		c.DropFrames = false
+		c.Quick = false
+		c.NoiseSensitivity = 0
+		c.Sharpness = 0
+		c.SpeedLevel = 1
+		c.RateFlags = 0
+		c.RateBuffer = 0
+	case PresetLowLatency:
+		c.Quality = 32
+		c.Bitrate = c.bitrate(0.08)
+		c.KeyframeFrequency = c.keyframeSeconds(1)
+		c.KeyframeMinDistance = 1
+		c.KeyframeAuto = true
+		c.KeyframeAutoThreshold = 80
+		c.DropFrames = true
+		c.Quick = true
+		c.NoiseSensitivity = 1
+		c.Sharpness = 2
+		c.SpeedLevel = SpeedLevelFastest
+		c.RateFlags = RateDropFrames | RateCapOverflow
+		/* a quarter of a second */
+		c.RateBuffer = max(c.keyframeSeconds(1)/4, 1)
+	}
+}
+
+// NewEncoderConfigPreset returns the configuration of the preset for
+// a w x h picture at fpsNum/fpsDen frames per second
+func NewEncoderConfigPreset(w, h, fpsNum, fpsDen int, p Preset) EncoderConfig {
+	res := NewEncoderConfig(w, h)
+	res.FPSNumerator = fpsNum
+	res.FPSDenominator = fpsDen
+	res.ApplyPreset(p)
+	return res
+}
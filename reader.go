@@ -0,0 +1,402 @@
+/* GoTheora
+Wrapper for Theora library
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package gotheora
+
+/*
+#cgo CFLAGS: -I/usr/include
+#include "theora/theora.h"
+*/
+import "C"
+import (
+	"errors"
+	"io"
+	"time"
+
+	OGG "github.com/ilya2ik/googg"
+)
+
+// TheoraFrame is a decoded video frame together with its position
+// in the stream
+type TheoraFrame struct {
+	// Decoded picture. The buffer is owned by the frame
+	Buffer ITheoraYUVbuffer
+	// Granule position of the frame
+	GranulePos int64
+	// Zero-based index of the frame
+	Number int64
+	// Presentation time of the frame
+	Time time.Duration
+	// The frame is a keyframe
+	KeyFrame bool
+
+	fpool IYUVBufferPool
+}
+
+// Release returns the buffer of the frame to the buffer pool of the
+// reader or closes it if the reader has no pool. The buffer must not
+// be used after Release
+func (f *TheoraFrame) Release() {
+	if f.Buffer == nil {
+		return
+	}
+	if f.fpool != nil {
+		f.fpool.Put(f.Buffer)
+	} else {
+		f.Buffer.Close()
+	}
+	f.Buffer = nil
+}
+
+type ITheoraReader interface {
+	Info() ITheoraInfo
+	Comment() ITheoraComment
+	Decoder() ITheoraDecoder
+	SerialNo() int32
+	SetBufferPool(pool IYUVBufferPool)
+	BufferPool() IYUVBufferPool
+
+	ReadFrame() (*TheoraFrame, error)
+	ReadFrameTelemetry() (*TheoraFrame, *TheoraTelemetry, error)
+	Seek(t time.Duration) error
+	SeekFrame(frame int64) error
+	Close() error
+}
+
+/* TheoraReader */
+
+type TheoraReader struct {
+	freader  io.Reader
+	fsync    *oggSyncState
+	fstream  *oggStreamState
+	fpacket  OGG.IOGGPacket
+	finfo    ITheoraInfo
+	fcomment ITheoraComment
+	fdecoder ITheoraDecoder
+	fserial  int32
+	feos     bool
+
+	fseeker    io.ReadSeeker
+	fdataStart int64
+	fsize      int64
+	fskeleton  *skeletonIndex
+
+	// The planes of the decoder and the pool for the copies of them
+	fyuv  ITheoraYUVbuffer
+	fpool IYUVBufferPool
+}
+
+// NewTheoraReader reads the physical ogg stream from str, selects the
+// first theora logical stream in it and consumes its three header
+// packets. After that the decoder is ready and the frames can be
+// obtained with ReadFrame. If str is an io.ReadSeeker, the reader
+// can seek with Seek and SeekFrame. The keyframe index of an Ogg
+// Skeleton 4.0 stream is used for seeking if it is present
+func NewTheoraReader(str io.Reader) (ITheoraReader, error) {
+	value := new(TheoraReader)
+	value.freader = str
+
+	var err error
+	value.fsync, err = newOggSyncState()
+	if err != nil {
+		return nil, err
+	}
+	if seeker, ok := str.(io.ReadSeeker); ok {
+		pos, err := seeker.Seek(0, io.SeekCurrent)
+		if err == nil {
+			value.fseeker = seeker
+			value.fsync.ResetAt(pos)
+		}
+	}
+	value.fpacket, err = OGG.NewPacket()
+	if err != nil {
+		value.Close()
+		return nil, err
+	}
+	value.finfo, err = NewTheoraInfo()
+	if err != nil {
+		value.Close()
+		return nil, err
+	}
+	value.finfo.Init()
+	value.fcomment, err = NewTheoraComment()
+	if err != nil {
+		value.Close()
+		return nil, err
+	}
+	value.fcomment.Init()
+	value.fdecoder, err = NewTheoraDecoder(value.finfo)
+	if err != nil {
+		value.Close()
+		return nil, err
+	}
+
+	err = value.findStream()
+	if err == nil {
+		err = value.readHeaders()
+	}
+	if err != nil {
+		value.Close()
+		return nil, wrapError("NewTheoraReader", -1, err)
+	}
+	value.fdataStart = value.fsync.Position()
+	return value, nil
+}
+
+// findStream checks the first packet of each beginning-of-stream page
+// until the theora identification header is found
+func (v *TheoraReader) findStream() error {
+	var og C.ogg_page
+	for {
+		err := v.readPage(&og)
+		if err != nil {
+			if err == io.EOF {
+				return ETheoraNoStreamException
+			}
+			return err
+		}
+		if !oggPageBOS(&og) {
+			return ETheoraNoStreamException
+		}
+
+		if v.fskeleton == nil {
+			pos := v.fsync.Position() - int64(og.header_len+og.body_len)
+			v.fskeleton = newSkeletonIndex(&og, pos)
+			if v.fskeleton != nil {
+				continue
+			}
+		}
+
+		stream, err := newOggStreamState(oggPageSerialNo(&og))
+		if err != nil {
+			return err
+		}
+		if stream.PageIn(&og) && stream.PacketOut(v.fpacket) > 0 {
+			if v.fdecoder.Header(v.fcomment, v.fpacket) == nil {
+				v.fstream = stream
+				v.fserial = oggPageSerialNo(&og)
+				return nil
+			}
+		}
+		stream.Done()
+	}
+}
+
+// readHeaders consumes the comment and the setup header packets
+func (v *TheoraReader) readHeaders() error {
+	for !v.fdecoder.IsReady() {
+		res := v.fstream.PacketOut(v.fpacket)
+		if res > 0 {
+			err := v.fdecoder.Header(v.fcomment, v.fpacket)
+			if err != nil {
+				return err
+			}
+		} else if res == 0 {
+			err := v.nextPage()
+			if err != nil {
+				if err == io.EOF {
+					return newError("NewTheoraReader", C.OC_BADHEADER)
+				}
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readPage returns the next page of the physical stream
+func (v *TheoraReader) readPage(og *C.ogg_page) error {
+	return v.fsync.ReadPage(v.freader, og)
+}
+
+// nextPage submits the next page of the selected logical stream
+func (v *TheoraReader) nextPage() error {
+	if v.feos {
+		return io.EOF
+	}
+	var og C.ogg_page
+	for {
+		err := v.readPage(&og)
+		if err != nil {
+			return err
+		}
+		if oggPageSerialNo(&og) == v.fserial {
+			v.fstream.PageIn(&og)
+			v.feos = oggPageEOS(&og)
+			return nil
+		}
+		if v.fskeleton != nil && oggPageSerialNo(&og) == v.fskeleton.fserial {
+			v.fskeleton.PageIn(&og, v.fserial)
+		}
+	}
+}
+
+// Info returns the info of the stream. It is released by Close
+func (v *TheoraReader) Info() ITheoraInfo {
+	return v.finfo
+}
+
+// Comment returns the comments of the stream. It is released by Close
+func (v *TheoraReader) Comment() ITheoraComment {
+	return v.fcomment
+}
+
+func (v *TheoraReader) Decoder() ITheoraDecoder {
+	return v.fdecoder
+}
+
+func (v *TheoraReader) SerialNo() int32 {
+	return v.fserial
+}
+
+// SetBufferPool sets the pool for the buffers of the decoded frames.
+// The frames are returned to the pool with TheoraFrame.Release. The
+// pool is not closed with the reader
+func (v *TheoraReader) SetBufferPool(pool IYUVBufferPool) {
+	v.fpool = pool
+}
+
+func (v *TheoraReader) BufferPool() IYUVBufferPool {
+	return v.fpool
+}
+
+// ReadFrame decodes the next frame of the stream. Dropped (duplicated)
+// frames are returned as copies of the previous frame. io.EOF is
+// returned after the last frame
+func (v *TheoraReader) ReadFrame() (*TheoraFrame, error) {
+	frame, _, err := v.readFrame(false)
+	return frame, err
+}
+
+// ReadFrameTelemetry decodes the next frame like ReadFrame and also
+// returns the macroblock data of its packet. The telemetry is nil for
+// dropped frames. If the packet is decoded but can not be parsed, the
+// error holds the number of the frame and the frame is skipped. The
+// overlays enabled with Decoder().SetTelemetry are rendered into the
+// frame
+func (v *TheoraReader) ReadFrameTelemetry() (*TheoraFrame, *TheoraTelemetry, error) {
+	return v.readFrame(true)
+}
+
+func (v *TheoraReader) readFrame(telemetry bool) (*TheoraFrame, *TheoraTelemetry, error) {
+	/* the number of the frame for the errors */
+	state := v.fdecoder.State()
+	next := state.GranuleFrame(state.GetGranulePos()) + 1
+	for {
+		res := v.fstream.PacketOut(v.fpacket)
+		if res == 0 {
+			err := v.nextPage()
+			if err != nil {
+				return nil, nil, wrapError("TheoraReader.ReadFrame", next, err)
+			}
+			continue
+		}
+		if res < 0 {
+			/* a gap in the data, skip it */
+			continue
+		}
+
+		if p := oggPacketRef(v.fpacket); p.bytes == 0 && p.e_o_s != 0 && state.GetGranulePos() < 0 {
+			/* the end of a stream without frames, see SaveEOSToStream.
+			   The decoder would repeat a frame it has never decoded */
+			continue
+		}
+
+		err := v.fdecoder.PacketIn(v.fpacket)
+		if errors.Is(err, ETheoraDecBadPacketException) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, wrapError("TheoraReader.ReadFrame", next, err)
+		}
+
+		var tm *TheoraTelemetry
+		if telemetry {
+			tm, err = ParseTelemetry(v.fpacket, v.finfo)
+			if err != nil {
+				return nil, nil, wrapError("TheoraReader.ReadFrameTelemetry", next, err)
+			}
+		}
+		frame, err := v.frame()
+		return frame, tm, wrapError("TheoraReader.ReadFrame", next, err)
+	}
+}
+
+func (v *TheoraReader) frame() (*TheoraFrame, error) {
+	var err error
+	if v.fyuv == nil {
+		v.fyuv, err = NewTheoraYUVbuffer()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = v.fdecoder.YUVout(v.fyuv)
+	if err != nil {
+		return nil, err
+	}
+
+	res := new(TheoraFrame)
+	if v.fpool != nil {
+		res.Buffer, err = v.fpool.Clone(v.fyuv)
+		res.fpool = v.fpool
+	} else {
+		res.Buffer, err = v.fyuv.Clone()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state := v.fdecoder.State()
+	res.GranulePos = state.GetGranulePos()
+	res.Number = state.GranuleFrame(res.GranulePos)
+	res.KeyFrame = ThPacketIsKeyframe(v.fpacket)
+	if v.finfo.GetFPSNumerator() > 0 {
+		res.Time = time.Duration(float64(res.Number) *
+			float64(v.finfo.GetFPSDenominator()) /
+			float64(v.finfo.GetFPSNumerator()) * float64(time.Second))
+	}
+	return res, nil
+}
+
+// Close releases the demuxer, the decoder, the info and the comment.
+// The info and the comment returned by Info and Comment belong to the
+// reader and must not be used after Close. It is safe to call Close
+// more than once
+func (v *TheoraReader) Close() error {
+	if v.fskeleton != nil {
+		v.fskeleton.Done()
+		v.fskeleton = nil
+	}
+	if v.fyuv != nil {
+		v.fyuv.Close()
+		v.fyuv = nil
+	}
+	if v.fdecoder != nil {
+		v.fdecoder.Close()
+		v.fdecoder = nil
+	}
+	if v.fstream != nil {
+		v.fstream.Done()
+		v.fstream = nil
+	}
+	if v.fsync != nil {
+		v.fsync.Done()
+		v.fsync = nil
+	}
+	/* the data of the packet belongs to the released stream */
+	v.fpacket = nil
+	if v.fcomment != nil {
+		v.fcomment.Close()
+	}
+	if v.finfo != nil {
+		v.finfo.Close()
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+/* GoTheora
+Wrapper for Theora library
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package gotheora
+
+/*
+#cgo CFLAGS: -I/usr/include
+#include "theora/theora.h"
+*/
+import "C"
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+/* Seeking.
+   The pages are bisected by their granule positions. The granule
+   position of the last packet ending on a page holds the number of
+   the keyframe this packet depends on, so the first bisection finds
+   the keyframe of the requested frame and the second one finds the
+   page where this keyframe starts. The decoding is resumed from that
+   page: the frames before the keyframe are dropped, the frames from
+   the keyframe up to the requested one are decoded silently. If the
+   stream has a skeleton keyframe index, the decoding is resumed
+   from the page of the nearest indexed keyframe instead */
+
+// Size of the range scanned page by page at the end of a bisection
+const seekLinearRange = 2 * oggReadChunk
+
+// seekPage is a page of the selected stream ending a packet
+type seekPage struct {
+	offset     int64
+	granulepos int64
+}
+
+// repositionAt drops all the buffered data and continues reading the
+// physical stream from pos
+func (v *TheoraReader) repositionAt(pos int64) error {
+	_, err := v.fseeker.Seek(pos, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	v.fsync.ResetAt(pos)
+	v.fstream.Reset()
+	v.feos = false
+	return nil
+}
+
+// scanPages reads the pages starting in [from, to) and calls fn for
+// each page of the selected stream ending a packet. The scan stops
+// when fn returns false
+func (v *TheoraReader) scanPages(from, to int64, fn func(pg seekPage) bool) error {
+	err := v.repositionAt(from)
+	if err != nil {
+		return err
+	}
+	var og C.ogg_page
+	pos := from
+	for pos < to {
+		res := v.fsync.PageSeek(&og)
+		if res < 0 {
+			pos -= int64(res)
+			continue
+		}
+		if res == 0 {
+			n, err := v.fsync.Feed(v.freader, oggReadChunk)
+			if n == 0 {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+			continue
+		}
+		pg := seekPage{offset: pos, granulepos: oggPageGranulePos(&og)}
+		pos += int64(res)
+		if oggPageSerialNo(&og) == v.fserial && pg.granulepos >= 0 {
+			if !fn(pg) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// lastPageBefore returns the last page of the selected stream ending
+// a packet of a frame with the number less than frame
+func (v *TheoraReader) lastPageBefore(frame int64) (seekPage, bool, error) {
+	state := v.fdecoder.State()
+	var best seekPage
+	found := false
+
+	lo, hi := v.fdataStart, v.fsize
+	for hi-lo > seekLinearRange {
+		mid := lo + (hi-lo)/2
+		var pg seekPage
+		ok := false
+		err := v.scanPages(mid, hi, func(p seekPage) bool {
+			pg, ok = p, true
+			return false
+		})
+		if err != nil {
+			return best, false, err
+		}
+		if ok && state.GranuleFrame(pg.granulepos) < frame {
+			best, found = pg, true
+			lo = pg.offset + 1
+		} else {
+			hi = mid
+		}
+	}
+
+	err := v.scanPages(lo, hi, func(p seekPage) bool {
+		if state.GranuleFrame(p.granulepos) >= frame {
+			return false
+		}
+		best, found = p, true
+		return true
+	})
+	return best, found, err
+}
+
+// Seek positions the reader so that the next ReadFrame returns the
+// frame shown at the time t
+func (v *TheoraReader) Seek(t time.Duration) error {
+	if v.finfo.GetFPSNumerator() <= 0 || v.finfo.GetFPSDenominator() <= 0 {
+		return newError("TheoraReader.Seek", C.OC_EINVAL)
+	}
+	if t < 0 {
+		t = 0
+	}
+	num := int64(v.finfo.GetFPSNumerator())
+	den := int64(v.finfo.GetFPSDenominator()) * int64(time.Second)
+	return v.SeekFrame(int64(t) * num / den)
+}
+
+// SeekFrame positions the reader so that the next ReadFrame returns
+// the frame with the zero-based number frame. The stream given to
+// NewTheoraReader must be an io.ReadSeeker. If the frame is past the
+// end of the stream, io.EOF is returned by SeekFrame or by the next
+// ReadFrame
+func (v *TheoraReader) SeekFrame(frame int64) error {
+	if v.fseeker == nil {
+		return ETheoraNotSeekableException
+	}
+	if frame < 0 {
+		return newError("TheoraReader.SeekFrame", C.OC_EINVAL)
+	}
+	var err error
+	v.fsize, err = v.fseeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	if v.fskeleton != nil {
+		ok, err := v.seekIndexed(frame)
+		if ok || err != nil {
+			return err
+		}
+	}
+
+	state := v.fdecoder.State()
+	shift := v.finfo.GranuleShift()
+
+	/* the keyframe of the requested frame */
+	var keyframe int64
+	pg, ok, err := v.lastPageBefore(frame)
+	if err != nil {
+		return err
+	}
+	if ok {
+		keyframe = max(state.GranuleFrame(pg.granulepos>>shift<<shift), 0)
+	}
+
+	/* the page the keyframe starts on */
+	start, next := v.fdataStart, int64(0)
+	pg, ok, err = v.lastPageBefore(keyframe)
+	if err != nil {
+		return err
+	}
+	if ok {
+		start = pg.offset
+		next = state.GranuleFrame(pg.granulepos) + 1
+	}
+	err = v.repositionAt(start)
+	if err != nil {
+		return err
+	}
+	if ok {
+		/* drop the packets ending on the start page */
+		err = v.nextPage()
+		if err != nil {
+			return err
+		}
+		for v.fstream.PacketOut(v.fpacket) != 0 {
+		}
+	}
+
+	return v.decodeUpTo(next, keyframe, frame)
+}
+
+// seekIndexed positions the reader with the skeleton keyframe index.
+// Returns false if the index can not be used
+func (v *TheoraReader) seekIndexed(frame int64) (bool, error) {
+	offset, keyframe, ok := v.fskeleton.Keyframe(frame, v.finfo, v.fsize)
+	if !ok || offset < v.fdataStart {
+		return false, nil
+	}
+	err := v.repositionAt(offset)
+	if err != nil {
+		return false, err
+	}
+	/* the first packet starting on the page must be the keyframe */
+	res := 0
+	for res == 0 {
+		err = v.nextPage()
+		if err != nil {
+			if err == io.EOF {
+				return false, nil
+			}
+			return false, err
+		}
+		res = v.fstream.PacketPeek(v.fpacket)
+		if res < 0 {
+			v.fstream.PacketOut(v.fpacket)
+			res = 0
+		}
+	}
+	if !ThPacketIsKeyframe(v.fpacket) {
+		return false, nil
+	}
+	return true, v.decodeUpTo(keyframe, keyframe, frame)
+}
+
+// decodeUpTo reads the packets numbered from next. The packets before
+// the keyframe are dropped, the packets from the keyframe up to the
+// frame are decoded without output
+func (v *TheoraReader) decodeUpTo(next, keyframe, frame int64) error {
+	state := v.fdecoder.State()
+	shift := v.finfo.GranuleShift()
+	/* the granule position of the frame before the first one */
+	granule0 := -state.GranuleFrame(0)
+
+	for next < frame {
+		res := v.fstream.PacketPeek(v.fpacket)
+		if res == 0 {
+			err := v.nextPage()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		v.fstream.PacketOut(v.fpacket)
+		if res < 0 {
+			continue
+		}
+		if data := oggPacketBytes(v.fpacket); len(data) > 0 && data[0]&0x80 != 0 {
+			/* a header packet */
+			continue
+		}
+
+		if next == keyframe {
+			if g := keyframe - 1 + granule0; g >= 0 {
+				v.fdecoder.SetGranulePos(g << shift)
+			}
+		}
+		if next >= keyframe {
+			err := v.fdecoder.PacketIn(v.fpacket)
+			if err != nil && !errors.Is(err, ETheoraDecBadPacketException) {
+				return wrapError("TheoraReader.SeekFrame", next, err)
+			}
+		}
+		next++
+	}
+
+	if frame == keyframe {
+		if g := keyframe - 1 + granule0; g >= 0 {
+			v.fdecoder.SetGranulePos(g << shift)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,467 @@
+/* GoTheora
+Wrapper for Theora library
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package gotheora
+
+/*
+#cgo CFLAGS: -I/usr/include
+#include "ogg/ogg.h"
+#include "theora/theora.h"
+*/
+import "C"
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+/* Ogg Skeleton 4.0.
+   The skeleton is a separate logical stream describing the other
+   streams of the segment. Its beginning-of-stream page (fishead) is
+   the first page of the segment, the fisbone and the index packets
+   follow the beginning-of-stream pages of the other streams, the
+   end-of-stream page follows all the header pages. All the numbers
+   are little-endian.
+
+   The index lists the byte offsets of the pages the keyframes start
+   on together with their presentation times. The keypoints are
+   delta-coded and written as variable-length numbers, 7 bits per
+   byte, the high bit marks the last byte of a number */
+
+const (
+	skeletonVersionMajor = 4
+	skeletonVersionMinor = 0
+
+	skeletonFisheadSize = 80
+	skeletonFisboneSize = 52
+	skeletonIndexSize   = 42
+	// Space reserved for one keypoint of the index
+	skeletonKeypointSize = 10
+)
+
+var (
+	skeletonFisheadID = []byte("fishead\x00")
+	skeletonFisboneID = []byte("fisbone\x00")
+	skeletonIndexID   = []byte("index\x00")
+)
+
+// skeletonKeypoint is a keyframe of the index
+type skeletonKeypoint struct {
+	// Offset of the page from the beginning of the segment
+	offset int64
+	// Presentation time numerator of the keyframe
+	time int64
+}
+
+func putVarint(buf *bytes.Buffer, n uint64) {
+	for n > 0x7f {
+		buf.WriteByte(byte(n & 0x7f))
+		n >>= 7
+	}
+	buf.WriteByte(byte(n) | 0x80)
+}
+
+func getVarint(data []byte) (n uint64, size int, ok bool) {
+	for i, b := range data {
+		if i > 9 {
+			break
+		}
+		n |= uint64(b&0x7f) << (7 * i)
+		if b&0x80 != 0 {
+			return n, i + 1, true
+		}
+	}
+	return 0, 0, false
+}
+
+func buildFishead(segLength, contentOffset int64) []byte {
+	var buf bytes.Buffer
+	put := func(v any) {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	buf.Write(skeletonFisheadID)
+	put(uint16(skeletonVersionMajor))
+	put(uint16(skeletonVersionMinor))
+	/* presentation time and base time, zero in milliseconds */
+	put([4]int64{0, 1000, 0, 1000})
+	/* no UTC time */
+	buf.Write(make([]byte, 20))
+	put(segLength)
+	put(contentOffset)
+	return buf.Bytes()
+}
+
+func buildFisbone(inf ITheoraInfo, serial int32) []byte {
+	var buf bytes.Buffer
+	put := func(v any) {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	buf.Write(skeletonFisboneID)
+	/* offset of the message header fields from this field */
+	put(uint32(skeletonFisboneSize - 8))
+	put(serial)
+	put(uint32(3))
+	put(int64(inf.GetFPSNumerator()))
+	put(int64(inf.GetFPSDenominator()))
+	/* base granule and preroll */
+	put(int64(0))
+	put(uint32(0))
+	buf.WriteByte(byte(inf.GranuleShift()))
+	buf.Write(make([]byte, 3))
+	fmt.Fprintf(&buf, "Content-Type: video/theora\r\nRole: video/main\r\nName: video_%d\r\n", serial)
+	return buf.Bytes()
+}
+
+// buildIndex builds the index packet of exactly size bytes. The
+// keypoints are thinned out until they fit
+func buildIndex(serial int32, kps []skeletonKeypoint, den, last int64, size int) []byte {
+	for {
+		var buf bytes.Buffer
+		put := func(v any) {
+			binary.Write(&buf, binary.LittleEndian, v)
+		}
+		buf.Write(skeletonIndexID)
+		put(serial)
+		put(int64(len(kps)))
+		put(den)
+		/* the first sample time and the end time of the last sample */
+		put(int64(0))
+		put(last)
+		var offset, time int64
+		for _, kp := range kps {
+			putVarint(&buf, uint64(kp.offset-offset))
+			putVarint(&buf, uint64(kp.time-time))
+			offset, time = kp.offset, kp.time
+		}
+		if buf.Len() <= size {
+			buf.Write(make([]byte, size-buf.Len()))
+			return buf.Bytes()
+		}
+		/* keep every second keypoint. A single keypoint which does
+		   not fit is dropped, the empty index always fits */
+		if len(kps) <= 1 {
+			kps = nil
+			continue
+		}
+		thin := kps[:0:0]
+		for i := 0; i < len(kps); i += 2 {
+			thin = append(thin, kps[i])
+		}
+		kps = thin
+	}
+}
+
+// parseFishead returns the segment length of a version 4 fishead
+// packet. The length is 0 if it is unknown
+func parseFishead(data []byte) (segLength int64, ok bool) {
+	if len(data) < skeletonFisheadSize || !bytes.HasPrefix(data, skeletonFisheadID) {
+		return 0, false
+	}
+	if binary.LittleEndian.Uint16(data[8:]) < skeletonVersionMajor {
+		return 0, true
+	}
+	return int64(binary.LittleEndian.Uint64(data[64:])), true
+}
+
+// parseIndex decodes the index packet. The keypoint times are
+// returned with their denominator
+func parseIndex(data []byte) (serial int32, kps []skeletonKeypoint, den int64, ok bool) {
+	if len(data) < skeletonIndexSize || !bytes.HasPrefix(data, skeletonIndexID) {
+		return 0, nil, 0, false
+	}
+	serial = int32(binary.LittleEndian.Uint32(data[6:]))
+	n := int64(binary.LittleEndian.Uint64(data[10:]))
+	den = int64(binary.LittleEndian.Uint64(data[18:]))
+	if n < 0 || den <= 0 || n > int64(len(data)-skeletonIndexSize)/2 {
+		return 0, nil, 0, false
+	}
+	data = data[skeletonIndexSize:]
+	kps = make([]skeletonKeypoint, 0, n)
+	var offset, time int64
+	for i := int64(0); i < n; i++ {
+		d, size, ok := getVarint(data)
+		if !ok {
+			return 0, nil, 0, false
+		}
+		data = data[size:]
+		offset += int64(d)
+		d, size, ok = getVarint(data)
+		if !ok {
+			return 0, nil, 0, false
+		}
+		data = data[size:]
+		time += int64(d)
+		kps = append(kps, skeletonKeypoint{offset, time})
+	}
+	return serial, kps, den, true
+}
+
+/* Skeleton writer */
+
+// countingWriter counts the bytes written to the segment
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (v *countingWriter) Write(p []byte) (int, error) {
+	n, err := v.w.Write(p)
+	v.n += int64(n)
+	return n, err
+}
+
+type skeletonWriter struct {
+	fstream    *oggStreamState
+	fwriter    *countingWriter
+	fserial    int32
+	fpacketno  int64
+	fkeypoints int
+	// Output to patch the fishead and the index at the end, nil if
+	// the output can not seek
+	fseeker io.WriteSeeker
+	fbase   int64
+
+	fheadAt    int64
+	findexAt   int64
+	fcontentAt int64
+	findex     []skeletonKeypoint
+}
+
+func (v *skeletonWriter) Done() {
+	if v.fstream != nil {
+		v.fstream.Done()
+		v.fstream = nil
+	}
+}
+
+// writePackets submits the packets to the stream and writes them on
+// their own pages
+func (v *skeletonWriter) writePackets(stream *oggStreamState, w io.Writer, bos, eos bool, packets ...[]byte) error {
+	for i, p := range packets {
+		if !stream.PacketIn(p, bos && i == 0, eos && i == len(packets)-1, 0, v.fpacketno) {
+			return ETheoraOutOfMemory
+		}
+		v.fpacketno++
+	}
+	var og C.ogg_page
+	for stream.Flush(&og) {
+		err := oggPageWrite(w, &og)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *skeletonWriter) indexSize() int {
+	return skeletonIndexSize + v.fkeypoints*skeletonKeypointSize
+}
+
+// EnableSkeleton makes the encoder write an Ogg Skeleton 4.0 stream
+// describing the theora stream. If the output is an io.WriteSeeker
+// and keypoints is positive, the space for an index of up to
+// keypoints keyframes is reserved and the index is written by Close.
+// Every keyframe of SaveYUVBufferToStream and SaveImageToStream starts
+// a new page and is indexed. Must be called before the headers are
+// saved
+func (v *TheoraEncoder) EnableSkeleton(keypoints int) error {
+	if v.fHeadersSaved || v.fSkeleton != nil || keypoints < 0 {
+		return newError("TheoraEncoder.EnableSkeleton", C.OC_EINVAL)
+	}
+	skel := new(skeletonWriter)
+	if seeker, ok := v.fwriter.(io.WriteSeeker); ok && keypoints > 0 {
+		pos, err := seeker.Seek(0, io.SeekCurrent)
+		if err == nil {
+			skel.fseeker = seeker
+			skel.fbase = pos
+			skel.fkeypoints = keypoints
+		}
+	}
+	skel.fwriter = &countingWriter{w: v.fwriter}
+	v.fwriter = skel.fwriter
+	v.fSkeleton = skel
+	return nil
+}
+
+// writeHead starts the skeleton stream of the theora stream serial
+// with the fishead page. The skeleton serial follows the theora one
+func (v *skeletonWriter) writeHead(serial int32) error {
+	v.fserial = serial + 1
+	var err error
+	v.fstream, err = newOggStreamState(v.fserial)
+	if err != nil {
+		return err
+	}
+	v.fheadAt = v.fwriter.n
+	return v.writePackets(v.fstream, v.fwriter, true, false, buildFishead(0, 0))
+}
+
+// writeBones writes the fisbone and the reserved index pages
+func (v *skeletonWriter) writeBones(inf ITheoraInfo, serial int32) error {
+	err := v.writePackets(v.fstream, v.fwriter, false, false, buildFisbone(inf, serial))
+	if err != nil || v.fseeker == nil {
+		return err
+	}
+	v.findexAt = v.fwriter.n
+	return v.writePackets(v.fstream, v.fwriter, false, false,
+		buildIndex(serial, nil, 1, 0, v.indexSize()))
+}
+
+// writeEOS ends the skeleton stream after all the header pages
+func (v *skeletonWriter) writeEOS() error {
+	err := v.writePackets(v.fstream, v.fwriter, false, true, []byte{})
+	v.fcontentAt = v.fwriter.n
+	return err
+}
+
+// addKeyframe records the keyframe starting at the next page
+func (v *skeletonWriter) addKeyframe(frame int64, inf ITheoraInfo) {
+	if v.fseeker == nil {
+		return
+	}
+	v.findex = append(v.findex, skeletonKeypoint{
+		offset: v.fwriter.n,
+		time:   frame * int64(inf.GetFPSDenominator()),
+	})
+}
+
+// patch rewrites the fishead and the index pages in place. The
+// packets keep their sizes, so the pages keep their layout
+func (v *skeletonWriter) patch(inf ITheoraInfo, serial int32, frames int64) error {
+	if v.fseeker == nil {
+		return nil
+	}
+	end := v.fwriter.n
+	stream, err := newOggStreamState(v.fserial)
+	if err != nil {
+		return err
+	}
+	defer stream.Done()
+
+	var head, bone, index bytes.Buffer
+	v.fpacketno = 0
+	err = v.writePackets(stream, &head, true, false, buildFishead(end, v.fcontentAt))
+	if err == nil {
+		err = v.writePackets(stream, &bone, false, false, buildFisbone(inf, serial))
+	}
+	if err == nil {
+		den := max(int64(inf.GetFPSNumerator()), 1)
+		last := frames * int64(inf.GetFPSDenominator())
+		err = v.writePackets(stream, &index, false, false,
+			buildIndex(serial, v.findex, den, last, v.indexSize()))
+	}
+	for _, p := range []struct {
+		at   int64
+		data []byte
+	}{{v.fheadAt, head.Bytes()}, {v.findexAt, index.Bytes()}} {
+		if err != nil {
+			break
+		}
+		_, err = v.fseeker.Seek(v.fbase+p.at, io.SeekStart)
+		if err == nil {
+			_, err = v.fseeker.Write(p.data)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	_, err = v.fseeker.Seek(v.fbase+end, io.SeekStart)
+	return err
+}
+
+/* Skeleton reader */
+
+type skeletonIndex struct {
+	fstream *oggStreamState
+	fserial int32
+	// Offset of the segment in the physical stream
+	fbase     int64
+	fsegment  int64
+	fden      int64
+	fkeypoint []skeletonKeypoint
+}
+
+// newSkeletonIndex checks whether the beginning-of-stream page og at
+// pos starts a skeleton stream
+func newSkeletonIndex(og *C.ogg_page, pos int64) *skeletonIndex {
+	seg, ok := parseFishead(oggPageBody(og))
+	if !ok {
+		return nil
+	}
+	stream, err := newOggStreamState(oggPageSerialNo(og))
+	if err != nil {
+		return nil
+	}
+	stream.PageIn(og)
+	var op C.ogg_packet
+	C.ogg_stream_packetout(stream.fValue, &op)
+	return &skeletonIndex{
+		fstream:  stream,
+		fserial:  oggPageSerialNo(og),
+		fbase:    pos,
+		fsegment: seg,
+	}
+}
+
+// PageIn looks for the index of the theora stream serial
+func (v *skeletonIndex) PageIn(og *C.ogg_page, serial int32) {
+	if v.fstream == nil {
+		return
+	}
+	v.fstream.PageIn(og)
+	var op C.ogg_packet
+	for {
+		res := C.ogg_stream_packetout(v.fstream.fValue, &op)
+		if res == 0 {
+			break
+		}
+		if res < 0 || op.bytes <= 0 {
+			continue
+		}
+		data := unsafe.Slice((*byte)(unsafe.Pointer(op.packet)), int(op.bytes))
+		if s, kps, den, ok := parseIndex(data); ok && s == serial {
+			v.fkeypoint, v.fden = kps, den
+		}
+	}
+	if oggPageEOS(og) {
+		v.Done()
+	}
+}
+
+func (v *skeletonIndex) Done() {
+	if v.fstream != nil {
+		v.fstream.Done()
+		v.fstream = nil
+	}
+}
+
+// Keyframe returns the offset in the physical stream and the number
+// of the last indexed keyframe not after the frame. The index is
+// used only if the segment length matches the stream size
+func (v *skeletonIndex) Keyframe(frame int64, inf ITheoraInfo, size int64) (offset, keyframe int64, ok bool) {
+	if v.fsegment <= 0 || v.fbase+v.fsegment != size || len(v.fkeypoint) == 0 {
+		return 0, 0, false
+	}
+	num := int64(inf.GetFPSNumerator())
+	den := v.fden * int64(inf.GetFPSDenominator())
+	if num <= 0 || den <= 0 {
+		return 0, 0, false
+	}
+	for _, kp := range v.fkeypoint {
+		f := (kp.time*num + den/2) / den
+		if f > frame {
+			break
+		}
+		offset, keyframe, ok = v.fbase+kp.offset, f, true
+	}
+	return offset, keyframe, ok
+}
@@ -0,0 +1,47 @@
+/* GoTheora
+Wrapper for Theora library
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package gotheora
+
+/*
+#cgo CFLAGS: -I/usr/include
+#include "theora/theoradec.h"
+*/
+import "C"
+import (
+	"runtime/cgo"
+	"unsafe"
+)
+
+// StripeDecodedFunc receives a part of the frame decoded so far. buf
+// refers to the decoder memory and is valid only during the call.
+// The new rows of the luma plane are in the range [yfrag0*8, yfragEnd*8),
+// for the subsampled chroma planes the range is halved
+type StripeDecodedFunc func(buf IThYCbCrBuffer, yfrag0, yfragEnd int)
+
+// stripeHandle passes the Go callback through the C context pointer
+type stripeHandle uintptr
+
+func newStripeHandle(cb StripeDecodedFunc) stripeHandle {
+	return stripeHandle(cgo.NewHandle(cb))
+}
+
+func (h *stripeHandle) release() {
+	if *h != 0 {
+		cgo.Handle(*h).Delete()
+		*h = 0
+	}
+}
+
+//export gotheoraStripeDecoded
+func gotheoraStripeDecoded(ctx unsafe.Pointer, buf *C.th_img_plane, yfrag0, yfragEnd C.int) {
+	cb := cgo.Handle(uintptr(ctx)).Value().(StripeDecodedFunc)
+	cb(newThYCbCrBufferView(buf), int(yfrag0), int(yfragEnd))
+}
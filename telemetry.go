@@ -0,0 +1,639 @@
+/* GoTheora
+Wrapper for Theora library
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package gotheora
+
+/*
+#cgo CFLAGS: -I/usr/include
+#include "theora/theoradec.h"
+#include "theora/theora.h"
+*/
+import "C"
+import (
+	"image"
+	"unsafe"
+
+	OGG "github.com/ilya2ik/googg"
+)
+
+/* Macroblock coding modes */
+
+type MBMode int
+
+const (
+	ModeInterNoMV MBMode = iota
+	ModeIntra
+	ModeInterMV
+	ModeInterMVLast
+	ModeInterMVLast2
+	ModeGoldenNoMV
+	ModeGoldenMV
+	ModeInterMVFour
+	NModes
+)
+
+func (m MBMode) String() string {
+	switch m {
+	case ModeInterNoMV:
+		return "INTER_NOMV"
+	case ModeIntra:
+		return "INTRA"
+	case ModeInterMV:
+		return "INTER_MV"
+	case ModeInterMVLast:
+		return "INTER_MV_LAST"
+	case ModeInterMVLast2:
+		return "INTER_MV_LAST2"
+	case ModeGoldenNoMV:
+		return "GOLDEN_NOMV"
+	case ModeGoldenMV:
+		return "GOLDEN_MV"
+	case ModeInterMVFour:
+		return "INTER_MV_FOUR"
+	}
+	return "UNKNOWN"
+}
+
+// MotionVector is given in half-pixel units. As in the Theora
+// bitstream, positive Y points up
+type MotionVector struct {
+	X, Y int
+}
+
+// TheoraMBTelemetry describes one macroblock of a frame
+type TheoraMBTelemetry struct {
+	// Coding mode of the macroblock
+	Mode MBMode
+	// At least one of the luma blocks is coded
+	Coded bool
+	// Motion vectors of the four luma blocks in raster order (top row
+	// first). All four are the same except for ModeInterMVFour
+	MV [4]MotionVector
+	// Quantizer index used for the macroblock
+	QI int
+}
+
+// TheoraTelemetry is the per-macroblock data of a frame taken from
+// the video packet
+type TheoraTelemetry struct {
+	KeyFrame bool
+	// Quantizer indices declared in the frame header
+	QIs []int
+	// Size of the frame in macroblocks
+	MBWidth  int
+	MBHeight int
+	// Macroblocks in raster order, the top row first
+	MBs []TheoraMBTelemetry
+}
+
+// MB returns the macroblock at column x and row y counting from the
+// top left corner
+func (v *TheoraTelemetry) MB(x, y int) *TheoraMBTelemetry {
+	return &v.MBs[y*v.MBWidth+x]
+}
+
+// TelemetryOptions selects the telemetry overlays rendered by libtheora
+// into the decoded frames. libtheora must be built with telemetry
+// support, otherwise OC_IMPL is returned
+type TelemetryOptions struct {
+	// Bit mask of macroblock modes (1 << MBMode) to highlight
+	MBModes int
+	// Bit mask of macroblock modes whose motion vectors are drawn
+	MVs int
+	// Show the quantizer indices
+	QI bool
+	// Scale of the bit usage graph. Zero disables the graph
+	Bits int
+}
+
+/* TheoraDecoder telemetry requests */
+
+// SetTelemetry enables or disables the telemetry overlays for the
+// following frames
+func (v *TheoraDecoder) SetTelemetry(opt TelemetryOptions) error {
+	qi := 0
+	if opt.QI {
+		qi = 1
+	}
+	reqs := []struct {
+		req   int
+		value int
+	}{
+		{C.TH_DECCTL_SET_TELEMETRY_MBMODE, opt.MBModes},
+		{C.TH_DECCTL_SET_TELEMETRY_MV, opt.MVs},
+		{C.TH_DECCTL_SET_TELEMETRY_QI, qi},
+		{C.TH_DECCTL_SET_TELEMETRY_BITS, opt.Bits},
+	}
+	for _, r := range reqs {
+		cv := C.int(r.value)
+		R := v.control(r.req, unsafe.Pointer(&cv), unsafe.Sizeof(cv))
+		if R != 0 {
+			return newError("TheoraDecoder.SetTelemetry", R)
+		}
+	}
+	return nil
+}
+
+/* Frame header parser. Only the parts of the packet preceding the
+   DCT coefficients are read: the frame header, the coded block
+   flags, the macroblock modes, the motion vectors and the block
+   level quantizer indices */
+
+type theoraBitReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *theoraBitReader) read(n int) (int, error) {
+	v := 0
+	for i := 0; i < n; i++ {
+		if r.pos >= len(r.data)*8 {
+			return 0, ETheoraDecBadPacketException
+		}
+		bit := (r.data[r.pos>>3] >> (7 - uint(r.pos&7))) & 1
+		v = (v << 1) | int(bit)
+		r.pos++
+	}
+	return v, nil
+}
+
+// unary reads the count of 1 bits before a 0 bit, up to max
+func (r *theoraBitReader) unary(max int) (int, error) {
+	n := 0
+	for n < max {
+		b, err := r.read(1)
+		if err != nil {
+			return 0, err
+		}
+		if b == 0 {
+			break
+		}
+		n++
+	}
+	return n, nil
+}
+
+var sbRunStart = []int{1, 2, 4, 6, 10, 18, 34}
+var sbRunBits = []int{0, 1, 1, 2, 3, 4, 12}
+
+var blockRunStart = []int{1, 3, 5, 7, 11, 15}
+var blockRunBits = []int{1, 1, 1, 2, 2, 4}
+
+// longRun reads a run-length encoded string of n bits used for the
+// super block flags and the block level qi
+func (r *theoraBitReader) longRun(n int) ([]bool, error) {
+	res := make([]bool, 0, n)
+	if n == 0 {
+		return res, nil
+	}
+	flag, err := r.read(1)
+	if err != nil {
+		return nil, err
+	}
+	for len(res) < n {
+		code, err := r.unary(6)
+		if err != nil {
+			return nil, err
+		}
+		extra, err := r.read(sbRunBits[code])
+		if err != nil {
+			return nil, err
+		}
+		run := sbRunStart[code] + extra
+		for i := 0; i < run && len(res) < n; i++ {
+			res = append(res, flag != 0)
+		}
+		if run == 4129 && len(res) < n {
+			flag, err = r.read(1)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			flag ^= 1
+		}
+	}
+	return res, nil
+}
+
+// shortRun reads a run-length encoded string of n bits used for the
+// block coded flags
+func (r *theoraBitReader) shortRun(n int) ([]bool, error) {
+	res := make([]bool, 0, n)
+	if n == 0 {
+		return res, nil
+	}
+	flag, err := r.read(1)
+	if err != nil {
+		return nil, err
+	}
+	for len(res) < n {
+		code, err := r.unary(5)
+		if err != nil {
+			return nil, err
+		}
+		extra, err := r.read(blockRunBits[code])
+		if err != nil {
+			return nil, err
+		}
+		run := blockRunStart[code] + extra
+		for i := 0; i < run && len(res) < n; i++ {
+			res = append(res, flag != 0)
+		}
+		flag ^= 1
+	}
+	return res, nil
+}
+
+func (r *theoraBitReader) mvComp(fixed bool) (int, error) {
+	if fixed {
+		bits, err := r.read(6)
+		if err != nil {
+			return 0, err
+		}
+		if bits&1 != 0 {
+			return -(bits >> 1), nil
+		}
+		return bits >> 1, nil
+	}
+	bits, err := r.read(3)
+	if err != nil {
+		return 0, err
+	}
+	var mv int
+	switch bits {
+	case 0:
+		return 0, nil
+	case 1:
+		return 1, nil
+	case 2:
+		return -1, nil
+	case 3, 4:
+		mv = bits - 1
+		bits, err = r.read(1)
+	default:
+		mv = 1 << (bits - 3)
+		bits, err = r.read(bits - 2)
+		mv += bits >> 1
+		bits &= 1
+	}
+	if err != nil {
+		return 0, err
+	}
+	if bits != 0 {
+		return -mv, nil
+	}
+	return mv, nil
+}
+
+func (r *theoraBitReader) mv(fixed bool) (MotionVector, error) {
+	var res MotionVector
+	var err error
+	res.X, err = r.mvComp(fixed)
+	if err != nil {
+		return res, err
+	}
+	res.Y, err = r.mvComp(fixed)
+	return res, err
+}
+
+// Hilbert order of the blocks inside a super block as (x, y) pairs,
+// y counting from the bottom
+var sbBlockOrder = [16][2]int{
+	{0, 0}, {1, 0}, {1, 1}, {0, 1},
+	{0, 2}, {0, 3}, {1, 3}, {1, 2},
+	{2, 2}, {2, 3}, {3, 3}, {3, 2},
+	{3, 1}, {2, 1}, {2, 0}, {3, 0},
+}
+
+// Order of the macroblocks inside a super block
+var sbMBOrder = [4][2]int{{0, 0}, {0, 1}, {1, 1}, {1, 0}}
+
+var modeAlphabets = [7][8]MBMode{
+	{ModeInterMVLast, ModeInterMVLast2, ModeInterMV, ModeInterNoMV, ModeIntra, ModeGoldenNoMV, ModeGoldenMV, ModeInterMVFour},
+	{ModeInterMVLast, ModeInterMVLast2, ModeInterNoMV, ModeInterMV, ModeIntra, ModeGoldenNoMV, ModeGoldenMV, ModeInterMVFour},
+	{ModeInterMVLast, ModeInterMV, ModeInterMVLast2, ModeInterNoMV, ModeIntra, ModeGoldenNoMV, ModeGoldenMV, ModeInterMVFour},
+	{ModeInterMVLast, ModeInterMV, ModeInterNoMV, ModeInterMVLast2, ModeIntra, ModeGoldenNoMV, ModeGoldenMV, ModeInterMVFour},
+	{ModeInterNoMV, ModeInterMVLast, ModeInterMVLast2, ModeInterMV, ModeIntra, ModeGoldenNoMV, ModeGoldenMV, ModeInterMVFour},
+	{ModeInterNoMV, ModeGoldenNoMV, ModeInterMVLast, ModeInterMVLast2, ModeInterMV, ModeIntra, ModeGoldenMV, ModeInterMVFour},
+	{ModeInterNoMV, ModeIntra, ModeInterMV, ModeInterMVLast, ModeInterMVLast2, ModeGoldenNoMV, ModeGoldenMV, ModeInterMVFour},
+}
+
+type theoraPlaneGrid struct {
+	nhfrags, nvfrags int
+	nhsbs, nvsbs     int
+	first            int
+}
+
+// ParseTelemetry reads the macroblock data of the video packet op.
+// inf must contain the stream headers. Empty packets (dropped frames)
+// give nil
+func ParseTelemetry(op OGG.IOGGPacket, inf ITheoraInfo) (*TheoraTelemetry, error) {
+	data := oggPacketBytes(op)
+	if len(data) == 0 {
+		return nil, nil
+	}
+	r := &theoraBitReader{data: data}
+
+	/* frame header */
+	b, err := r.read(1)
+	if err != nil {
+		return nil, err
+	}
+	if b != 0 {
+		return nil, ETheoraDecBadPacketException
+	}
+	ftype, err := r.read(1)
+	if err != nil {
+		return nil, err
+	}
+	res := new(TheoraTelemetry)
+	res.KeyFrame = ftype == 0
+	for len(res.QIs) < 3 {
+		qi, err := r.read(6)
+		if err != nil {
+			return nil, err
+		}
+		res.QIs = append(res.QIs, qi)
+		if len(res.QIs) == 3 {
+			break
+		}
+		more, err := r.read(1)
+		if err != nil {
+			return nil, err
+		}
+		if more == 0 {
+			break
+		}
+	}
+	if res.KeyFrame {
+		if _, err = r.read(3); err != nil {
+			return nil, err
+		}
+	}
+
+	/* geometry */
+	res.MBWidth = inf.GetWidth() >> 4
+	res.MBHeight = inf.GetHeight() >> 4
+	var planes [3]theoraPlaneGrid
+	planes[0].nhfrags = res.MBWidth * 2
+	planes[0].nvfrags = res.MBHeight * 2
+	ch, cv := planes[0].nhfrags, planes[0].nvfrags
+	switch inf.GetPixelFormat() {
+	case image.YCbCrSubsampleRatio420:
+		ch, cv = ch>>1, cv>>1
+	case image.YCbCrSubsampleRatio422:
+		ch = ch >> 1
+	}
+	planes[1].nhfrags, planes[1].nvfrags = ch, cv
+	planes[2].nhfrags, planes[2].nvfrags = ch, cv
+	nsbs, nfrags := 0, 0
+	for i := range planes {
+		p := &planes[i]
+		p.nhsbs = (p.nhfrags + 3) >> 2
+		p.nvsbs = (p.nvfrags + 3) >> 2
+		p.first = nfrags
+		nsbs += p.nhsbs * p.nvsbs
+		nfrags += p.nhfrags * p.nvfrags
+	}
+
+	/* blocks of each super block in the coded order, as indices in
+	   the raster (bottom-up) order of all the planes */
+	sbfrags := make([][]int, 0, nsbs)
+	for i := range planes {
+		p := &planes[i]
+		for sby := 0; sby < p.nvsbs; sby++ {
+			for sbx := 0; sbx < p.nhsbs; sbx++ {
+				frags := make([]int, 0, 16)
+				for _, o := range sbBlockOrder {
+					x := sbx*4 + o[0]
+					y := sby*4 + o[1]
+					if x < p.nhfrags && y < p.nvfrags {
+						frags = append(frags, p.first+y*p.nhfrags+x)
+					}
+				}
+				sbfrags = append(sbfrags, frags)
+			}
+		}
+	}
+
+	/* coded block flags */
+	coded := make([]bool, nfrags)
+	if res.KeyFrame {
+		for i := range coded {
+			coded[i] = true
+		}
+	} else {
+		partial, err := r.longRun(nsbs)
+		if err != nil {
+			return nil, err
+		}
+		nfull, npartfrags := 0, 0
+		for i, p := range partial {
+			if p {
+				npartfrags += len(sbfrags[i])
+			} else {
+				nfull++
+			}
+		}
+		full, err := r.longRun(nfull)
+		if err != nil {
+			return nil, err
+		}
+		bits, err := r.shortRun(npartfrags)
+		if err != nil {
+			return nil, err
+		}
+		fi, bi := 0, 0
+		for i, p := range partial {
+			for _, f := range sbfrags[i] {
+				if p {
+					coded[f] = bits[bi]
+					bi++
+				} else {
+					coded[f] = full[fi]
+				}
+			}
+			if !p {
+				fi++
+			}
+		}
+	}
+
+	/* macroblocks in the coded order */
+	type mbref struct{ x, y int }
+	mbs := make([]mbref, 0, res.MBWidth*res.MBHeight)
+	for sby := 0; sby < planes[0].nvsbs; sby++ {
+		for sbx := 0; sbx < planes[0].nhsbs; sbx++ {
+			for _, o := range sbMBOrder {
+				x := sbx*2 + o[0]
+				y := sby*2 + o[1]
+				if x < res.MBWidth && y < res.MBHeight {
+					mbs = append(mbs, mbref{x, y})
+				}
+			}
+		}
+	}
+	/* luma blocks of the macroblock in the raster (bottom-up) order */
+	lumaFrags := func(m mbref) [4]int {
+		var f [4]int
+		for i := 0; i < 4; i++ {
+			f[i] = (m.y*2+(i>>1))*planes[0].nhfrags + m.x*2 + (i & 1)
+		}
+		return f
+	}
+
+	modes := make([]MBMode, len(mbs))
+	mbcoded := make([]bool, len(mbs))
+	for i, m := range mbs {
+		for _, f := range lumaFrags(m) {
+			if coded[f] {
+				mbcoded[i] = true
+				break
+			}
+		}
+	}
+
+	/* macroblock modes */
+	if res.KeyFrame {
+		for i := range modes {
+			modes[i] = ModeIntra
+		}
+	} else {
+		scheme, err := r.read(3)
+		if err != nil {
+			return nil, err
+		}
+		var alphabet [8]MBMode
+		if scheme == 0 {
+			for mode := 0; mode < int(NModes); mode++ {
+				mi, err := r.read(3)
+				if err != nil {
+					return nil, err
+				}
+				alphabet[mi] = MBMode(mode)
+			}
+		} else {
+			alphabet = modeAlphabets[scheme-1]
+		}
+		for i := range mbs {
+			if !mbcoded[i] {
+				modes[i] = ModeInterNoMV
+				continue
+			}
+			var mi int
+			if scheme == 7 {
+				mi, err = r.read(3)
+			} else {
+				mi, err = r.unary(7)
+			}
+			if err != nil {
+				return nil, err
+			}
+			modes[i] = alphabet[mi]
+		}
+	}
+
+	/* motion vectors */
+	mvs := make([][4]MotionVector, len(mbs))
+	if !res.KeyFrame {
+		fixed, err := r.read(1)
+		if err != nil {
+			return nil, err
+		}
+		var last1, last2 MotionVector
+		for i, m := range mbs {
+			var mv MotionVector
+			switch modes[i] {
+			case ModeInterMVFour:
+				prior := last1
+				for bi, f := range lumaFrags(m) {
+					if coded[f] {
+						mvs[i][bi], err = r.mv(fixed != 0)
+						if err != nil {
+							return nil, err
+						}
+						last1 = mvs[i][bi]
+					}
+				}
+				last2 = prior
+				continue
+			case ModeGoldenMV:
+				mv, err = r.mv(fixed != 0)
+			case ModeInterMVLast2:
+				mv = last2
+				last2 = last1
+				last1 = mv
+			case ModeInterMVLast:
+				mv = last1
+			case ModeInterMV:
+				mv, err = r.mv(fixed != 0)
+				last2 = last1
+				last1 = mv
+			}
+			if err != nil {
+				return nil, err
+			}
+			mvs[i] = [4]MotionVector{mv, mv, mv, mv}
+		}
+	}
+
+	/* block level qi */
+	qii := make([]int, nfrags)
+	if len(res.QIs) > 1 {
+		order := make([]int, 0, nfrags)
+		for _, frags := range sbfrags {
+			for _, f := range frags {
+				if coded[f] {
+					order = append(order, f)
+				}
+			}
+		}
+		bits, err := r.longRun(len(order))
+		if err != nil {
+			return nil, err
+		}
+		second := make([]int, 0, len(order))
+		for i, f := range order {
+			if bits[i] {
+				qii[f] = 1
+				second = append(second, f)
+			}
+		}
+		if len(res.QIs) == 3 {
+			bits, err = r.longRun(len(second))
+			if err != nil {
+				return nil, err
+			}
+			for i, f := range second {
+				if bits[i] {
+					qii[f]++
+				}
+			}
+		}
+	}
+
+	/* results in the top-down raster order */
+	res.MBs = make([]TheoraMBTelemetry, res.MBWidth*res.MBHeight)
+	for i, m := range mbs {
+		mb := res.MB(m.x, res.MBHeight-1-m.y)
+		mb.Mode = modes[i]
+		mb.Coded = mbcoded[i]
+		/* the top luma row comes first */
+		mb.MV = [4]MotionVector{mvs[i][2], mvs[i][3], mvs[i][0], mvs[i][1]}
+		mb.QI = res.QIs[0]
+		for _, f := range lumaFrags(m) {
+			if coded[f] {
+				mb.QI = res.QIs[qii[f]]
+				break
+			}
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,133 @@
+/* GoTheora
+A round-trip test of the picture placement: a picture of odd sizes is
+encoded at the top left corner, in the center and at a custom offset,
+decoded and converted back with ToRGBA
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io"
+
+	"example.com/ilya2ik/gotheora/internal/testutil"
+	Theora "github.com/ilya2ik/gotheora"
+)
+
+const (
+	WIDTH  = 37
+	HEIGHT = 23
+	// Allowed difference of the decoded components
+	TOLERANCE = 12
+)
+
+// picture is a smooth gradient, every edge of it has its own colors
+func picture() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, WIDTH, HEIGHT))
+	for y := 0; y < HEIGHT; y++ {
+		for x := 0; x < WIDTH; x++ {
+			img.Set(x, y, color.RGBA{uint8(32 + x*5), uint8(32 + y*8), 128, 255})
+		}
+	}
+	return img
+}
+
+func near(a, b color.Color) bool {
+	ar, ag, ab, _ := a.RGBA()
+	br, bg, bb, _ := b.RGBA()
+	d := func(u, v uint32) bool {
+		u, v = u>>8, v>>8
+		return u <= v+TOLERANCE && v <= u+TOLERANCE
+	}
+	return d(ar, br) && d(ag, bg) && d(ab, bb)
+}
+
+// decode encodes one frame with the configuration and returns the
+// decoded buffer together with the info of the stream
+func decode(cfg Theora.EncoderConfig) (Theora.ITheoraReader, *Theora.TheoraFrame) {
+	data := testutil.Stream{
+		Config: cfg,
+		Frames: 1,
+		Frame:  func(i int) image.Image { return picture() },
+	}.Encode()
+
+	reader, err := Theora.NewTheoraReader(bytes.NewReader(data))
+	testutil.Check(err)
+	frame, err := reader.ReadFrame()
+	testutil.Check(err)
+	return reader, frame
+}
+
+func testAlign(name string, align Theora.PictureAlign, offset, want image.Point) {
+	cfg := Theora.NewEncoderConfig(WIDTH, HEIGHT)
+	cfg.Quality = 63
+	cfg.SerialNo = 1
+	cfg.Align = align
+	cfg.Offset = offset
+	reader, frame := decode(cfg)
+	defer reader.Close()
+	defer frame.Release()
+	inf := reader.Info()
+
+	frameSize := image.Pt((want.X+WIDTH+15)&^15, (want.Y+HEIGHT+15)&^15)
+	testutil.Expect(name+": frame size", inf.GetWidth() == frameSize.X && inf.GetHeight() == frameSize.Y)
+	testutil.Expect(name+": picture size", inf.GetFrameWidth() == WIDTH && inf.GetFrameHeight() == HEIGHT)
+	testutil.Expect(name+": picture offset", inf.GetOffsetX() == want.X && inf.GetOffsetY() == want.Y)
+
+	/* the picture is cropped */
+	src := picture()
+	rgba := frame.Buffer.ToRGBA(inf)
+	testutil.Expect(name+": ToRGBA crops the picture", rgba != nil && rgba.Bounds() == src.Bounds())
+	if rgba == nil || rgba.Bounds() != src.Bounds() {
+		return
+	}
+	ok := true
+	for y := 0; y < HEIGHT; y++ {
+		for x := 0; x < WIDTH; x++ {
+			ok = ok && near(rgba.At(x, y), src.At(x, y))
+		}
+	}
+	testutil.Expect(name+": picture is decoded", ok)
+
+	/* the padding repeats the nearest edge pixel of the picture */
+	whole := frame.Buffer.ToRGBA(nil)
+	testutil.Expect(name+": ToRGBA without info returns the frame",
+		whole != nil && whole.Bounds() == image.Rect(0, 0, frameSize.X, frameSize.Y))
+	if whole == nil || whole.Bounds().Dx() != frameSize.X || whole.Bounds().Dy() != frameSize.Y {
+		return
+	}
+	pic := image.Rectangle{Min: want, Max: want.Add(image.Pt(WIDTH, HEIGHT))}
+	ok = true
+	for y := 0; y < frameSize.Y; y++ {
+		for x := 0; x < frameSize.X; x++ {
+			if image.Pt(x, y).In(pic) {
+				ok = ok && near(whole.At(x, y), rgba.At(x-want.X, y-want.Y))
+				continue
+			}
+			ex := min(max(x, pic.Min.X), pic.Max.X-1) - want.X
+			ey := min(max(y, pic.Min.Y), pic.Max.Y-1) - want.Y
+			ok = ok && near(whole.At(x, y), src.At(ex, ey))
+		}
+	}
+	testutil.Expect(name+": padding replicates the edges", ok)
+
+	_, err := reader.ReadFrame()
+	testutil.Expect(name+": one frame", err == io.EOF)
+}
+
+func main() {
+	testAlign("top left", Theora.AlignTopLeft, image.Point{}, image.Pt(0, 0))
+	/* (48 - 37) / 2 and (32 - 23) / 2 rounded down to even */
+	testAlign("center", Theora.AlignCenter, image.Point{}, image.Pt(4, 4))
+	testAlign("custom", Theora.AlignCustom, image.Pt(7, 5), image.Pt(7, 5))
+
+	testutil.Exit()
+}
@@ -0,0 +1,168 @@
+/* GoTheora
+A test of the plane memory against the cgo pointer passing rules
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+/* Run with the full cgo checks:
+
+   GOEXPERIMENT=cgocheck2 go run .
+
+   (GODEBUG=cgocheck=2 go run . before Go 1.21). The checks abort the
+   program as soon as a Go pointer is stored in C memory. Without the
+   checks the program fails with the exit status 2 */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"example.com/ilya2ik/gotheora/internal/testutil"
+	Theora "github.com/ilya2ik/gotheora"
+)
+
+const (
+	WIDTH        = 96
+	HEIGHT       = 64
+	FRAMES_COUNT = 12
+)
+
+func cgocheckEnabled() bool {
+	if strings.Contains(os.Getenv("GODEBUG"), "cgocheck=2") {
+		return true
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			if s.Key == "GOEXPERIMENT" && strings.Contains(s.Value, "cgocheck2") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// testSetData fills the planes from go slices, drops the slices and
+// checks that the buffer holds its own copies
+func testSetData() {
+	buf, err := Theora.NewTheoraYUVbuffer()
+	testutil.Check(err)
+	defer buf.Done()
+
+	buf.SetYWidth(16)
+	buf.SetYHeight(16)
+	buf.SetYStride(16)
+	buf.SetUVWidth(8)
+	buf.SetUVHeight(8)
+	buf.SetUVStride(8)
+	sizes := []int{16 * 16, 8 * 8, 8 * 8}
+	for i, set := range []func([]byte){buf.SetYData, buf.SetUData, buf.SetVData} {
+		data := bytes.Repeat([]byte{byte(i + 1)}, sizes[i])
+		set(data)
+		clear(data)
+	}
+	runtime.GC()
+
+	ok := buf.GetOwnData()
+	for i, data := range [][]byte{buf.GetYData(), buf.GetUData(), buf.GetVData()} {
+		ok = ok && len(data) > 0 && data[0] == byte(i+1) && data[len(data)-1] == byte(i+1)
+	}
+	testutil.Expect("SetData copies the planes into C memory", ok)
+	testutil.Expect("SetData reports no allocation failure", buf.Err() == nil)
+
+	/* a second set of the same size reuses the plane */
+	buf.SetYData(make([]byte, 256))
+	testutil.Expect("SetData replaces the plane", buf.GetYData()[0] == 0)
+
+	testutil.Check(buf.Alloc(32, 32, 16, 16))
+	testutil.Expect("Alloc resizes the planes", len(buf.GetYData()) == 32*32 && len(buf.GetUData()) == 16*16)
+}
+
+// testRoundTrip encodes the converted frames and decodes them back
+func testRoundTrip() {
+	cfg := Theora.NewEncoderConfig(WIDTH, HEIGHT)
+	cfg.SerialNo = 1
+	info, err := cfg.NewTheoraInfo()
+	testutil.Check(err)
+
+	var out bytes.Buffer
+	enc, err := Theora.NewTheoraEncoderConfig(cfg, &out)
+	testutil.Check(err)
+	testutil.Check(enc.SaveDefHeadersToStream())
+	for i := 0; i < FRAMES_COUNT; i++ {
+		buf, err := Theora.NewTheoraYUVbuffer()
+		testutil.Check(err)
+		if i%2 == 0 {
+			testutil.Expect(fmt.Sprintf("convert RGBA frame %d", i), buf.ConvertFromRasterImageInfo(info, testutil.Gradient(WIDTH, HEIGHT, i)))
+		} else {
+			/* the image.YCbCr path */
+			src, err := Theora.NewTheoraYUVbuffer()
+			testutil.Check(err)
+			testutil.Expect(fmt.Sprintf("convert RGBA frame %d", i), src.ConvertFromRasterImageInfo(info, testutil.Gradient(WIDTH, HEIGHT, i)))
+			ycc := src.ToYCbCr(info)
+			src.Done()
+			runtime.GC()
+			testutil.Expect(fmt.Sprintf("convert YCbCr frame %d", i),
+				buf.ConvertFromRasterImageOptions(info.GetPixelFormat(), ycc, cfg.ConvertOptions()))
+		}
+		runtime.GC()
+		testutil.Check(enc.SaveYUVBufferToStream(buf, i == FRAMES_COUNT-1))
+		buf.Done()
+		/* a second Done must not free the planes again */
+		buf.Done()
+	}
+	testutil.Check(enc.Close())
+
+	reader, err := Theora.NewTheoraReader(bytes.NewReader(out.Bytes()))
+	testutil.Check(err)
+	defer reader.Close()
+	var frames []*Theora.TheoraFrame
+	for {
+		frame, err := reader.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		testutil.Check(err)
+		frames = append(frames, frame)
+		runtime.GC()
+	}
+	testutil.Expect("all the frames decoded", len(frames) == FRAMES_COUNT)
+
+	/* the cloned frames hold their own planes after the decoder has
+	   moved on */
+	ok := true
+	for _, frame := range frames {
+		buf := frame.Buffer
+		ok = ok && buf.GetOwnData() && len(buf.GetYData()) == buf.GetYWidth()*buf.GetYHeight()
+		rgba := buf.ToRGBA(reader.Info())
+		ok = ok && rgba != nil && rgba.Rect.Dx() == WIDTH && rgba.Rect.Dy() == HEIGHT
+		clone, err := buf.Clone()
+		testutil.Check(err)
+		ok = ok && bytes.Equal(clone.GetUData(), buf.GetUData())
+		clone.Done()
+	}
+	testutil.Expect("decoded frames own their planes", ok)
+}
+
+func main() {
+	if !cgocheckEnabled() {
+		/* the test proves nothing without the checks */
+		fmt.Println("the full cgo checks are disabled, run with GOEXPERIMENT=cgocheck2")
+		os.Exit(2)
+	}
+
+	testSetData()
+	testRoundTrip()
+	runtime.GC()
+
+	testutil.Exit()
+}
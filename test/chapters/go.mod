@@ -0,0 +1,15 @@
+module example.com/ilya2ik/gotheora/chapters
+
+go 1.21.6
+
+replace (
+	example.com/ilya2ik/gotheora/internal/testutil => ../internal/testutil
+	github.com/ilya2ik/gotheora => ../..
+)
+
+require (
+	example.com/ilya2ik/gotheora/internal/testutil v0.0.0-00010101000000-000000000000
+	github.com/ilya2ik/gotheora v0.0.0-00010101000000-000000000000
+)
+
+require github.com/ilya2ik/googg v0.0.0-20240204133425-def2bc5c6d62 // indirect
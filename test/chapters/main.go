@@ -0,0 +1,138 @@
+/* GoTheora
+A test of the chapter markers: the chapter comments are written and
+parsed back, the encoder codes the chapter starts and the forced
+frames as keyframes
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"example.com/ilya2ik/gotheora/internal/testutil"
+	Theora "github.com/ilya2ik/gotheora"
+)
+
+const (
+	WIDTH        = 64
+	HEIGHT       = 48
+	FPS          = 10
+	FRAMES_COUNT = 30
+)
+
+func newComment() Theora.ITheoraComment {
+	tc, err := Theora.NewTheoraComment()
+	testutil.Check(err)
+	tc.Init()
+	return tc
+}
+
+func comments(tc Theora.ITheoraComment) []string {
+	var res []string
+	for i := 0; i < tc.TagsCount(); i++ {
+		res = append(res, tc.GetTag(i))
+	}
+	return res
+}
+
+func testSerialize() {
+	tc := newComment()
+	defer tc.Close()
+	tc.AddTag("TITLE", "chapters")
+
+	chapters := Theora.Chapters{
+		{Start: 3*time.Hour + 4*time.Minute + 5*time.Second + 6*time.Millisecond},
+		{Start: 0, Name: "Intro"},
+		{Start: time.Minute + 2345*time.Millisecond, Name: "Part two = the middle"},
+	}
+	testutil.Check(chapters.AssignToComment(tc))
+	testutil.Expect("chapter comments are numbered by the start time", reflect.DeepEqual(comments(tc), []string{
+		"TITLE=chapters",
+		"CHAPTER001=00:00:00.000",
+		"CHAPTER001NAME=Intro",
+		"CHAPTER002=00:01:02.345",
+		"CHAPTER002NAME=Part two = the middle",
+		"CHAPTER003=03:04:05.006",
+	}))
+	testutil.Expect("chapters are parsed back", reflect.DeepEqual(Theora.ChaptersFromComment(tc), chapters.Sorted()))
+
+	/* the chapters are replaced, the other comments are kept */
+	testutil.Check(Theora.Chapters{{Start: 5 * time.Second, Name: "Only"}}.AssignToComment(tc))
+	testutil.Expect("chapters are replaced", reflect.DeepEqual(comments(tc), []string{
+		"TITLE=chapters",
+		"CHAPTER001=00:00:05.000",
+		"CHAPTER001NAME=Only",
+	}))
+
+	Theora.RemoveChapters(tc)
+	testutil.Expect("chapters are removed", reflect.DeepEqual(comments(tc), []string{"TITLE=chapters"}))
+
+	err := Theora.Chapters{{Start: time.Second, Name: "\xff"}}.AssignToComment(tc)
+	testutil.Expect("invalid chapter name is refused", errors.Is(err, Theora.ETheoraInvalidCommentException))
+}
+
+func testParse() {
+	tc := newComment()
+	defer tc.Close()
+	tc.AddTag("chapter010", "1:02.5")
+	tc.AddTag("Chapter010Name", "lower case")
+	tc.AddTag("CHAPTER002", "7")
+	tc.AddTag("CHAPTER003", "bad")
+	tc.AddTag("CHAPTER004NAME", "no start")
+	tc.AddTag("CHAPTERS", "not a chapter")
+
+	want := Theora.Chapters{
+		{Start: 7 * time.Second},
+		{Start: time.Minute + 2500*time.Millisecond, Name: "lower case"},
+	}
+	testutil.Expect("chapters are parsed in the order of the numbers", reflect.DeepEqual(Theora.ChaptersFromComment(tc), want))
+}
+
+// static is the same noise frame each time, so the encoder has no
+// reason to code a keyframe by itself
+var static = testutil.Noise(WIDTH, HEIGHT, 0)
+
+func testKeyframes() {
+	cfg := Theora.NewEncoderConfig(WIDTH, HEIGHT)
+	cfg.FPSNumerator, cfg.FPSDenominator = FPS, 1
+	cfg.SerialNo = 1
+	var out bytes.Buffer
+	enc, err := Theora.NewTheoraEncoderConfig(cfg, &out)
+	testutil.Check(err)
+	/* 1.05 s is between the frames 10 and 11, the first frame shown
+	   at it is 11 */
+	enc.SetChapters(Theora.Chapters{
+		{Start: 0},
+		{Start: 2 * time.Second},
+		{Start: 1050 * time.Millisecond},
+	})
+	testutil.Check(enc.SaveDefHeadersToStream())
+	for i := 0; i < FRAMES_COUNT; i++ {
+		if i == 5 {
+			enc.ForceKeyframe()
+		}
+		testutil.Check(enc.SaveImageToStream(static, i == FRAMES_COUNT-1))
+	}
+	testutil.Check(enc.Close())
+
+	kf := testutil.Keyframes(out.Bytes())
+	testutil.Expect("chapter starts and forced frames are keyframes", fmt.Sprint(kf) == "[0 5 11 20]")
+}
+
+func main() {
+	testSerialize()
+	testParse()
+	testKeyframes()
+
+	testutil.Exit()
+}
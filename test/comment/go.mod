@@ -0,0 +1,9 @@
+module example.com/ilya2ik/gotheora/comment
+
+go 1.21.6
+
+replace github.com/ilya2ik/gotheora => ../..
+
+require github.com/ilya2ik/gotheora v0.0.0-00010101000000-000000000000
+
+require github.com/ilya2ik/googg v0.0.0-20240204133425-def2bc5c6d62 // indirect
@@ -0,0 +1,162 @@
+/* GoTheora
+An example of editing the comments of an .ogv file without re-encoding
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	Theora "github.com/ilya2ik/gotheora"
+)
+
+func check(e error) {
+	if e != nil {
+		fmt.Fprintln(os.Stderr, e)
+		os.Exit(1)
+	}
+}
+
+type tagList []string
+
+func (v *tagList) String() string {
+	return strings.Join(*v, ", ")
+}
+
+func (v *tagList) Set(s string) error {
+	*v = append(*v, s)
+	return nil
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] input.ogv [output.ogv]\n", os.Args[0])
+	fmt.Fprintln(os.Stderr, "Without output.ogv the input file is rewritten in place")
+	flag.PrintDefaults()
+}
+
+func main() {
+	var set, add, del tagList
+	list := flag.Bool("l", false, "list the comments and exit")
+	clear := flag.Bool("w", false, "remove all the existing comments first")
+	vendor := flag.String("vendor", "", "replace the vendor string")
+	poster := flag.String("p", "", "attach a PNG, JPEG or GIF file as the front cover")
+	flag.Var(&set, "t", "set the only value of a tag, TAG=value (repeatable)")
+	flag.Var(&add, "a", "append a comment, TAG=value (repeatable)")
+	flag.Var(&del, "d", "remove all the comments with the tag (repeatable)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 || flag.NArg() > 2 {
+		usage()
+		os.Exit(2)
+	}
+	input := flag.Arg(0)
+	output := input
+	if flag.NArg() == 2 {
+		output = flag.Arg(1)
+	}
+
+	/* Read the existing comments */
+
+	inf, err := os.Open(input)
+	check(err)
+	reader, err := Theora.NewTheoraReader(bufio.NewReader(inf))
+	check(err)
+	/* the comment is released with the reader */
+	defer reader.Close()
+	old := reader.Comment()
+	inf.Close()
+
+	if *list {
+		fmt.Printf("Vendor: %s\n", old.GetVendor())
+		for i := 0; i < old.TagsCount(); i++ {
+			tag := old.GetTag(i)
+			if len(tag) > 80 {
+				tag = tag[:77] + "..."
+			}
+			fmt.Println(tag)
+		}
+		for _, p := range old.Pictures() {
+			fmt.Printf("Picture: type %d, %s, %dx%d, %d bytes, %q\n",
+				p.Type, p.MIME, p.Width, p.Height, len(p.Data), p.Description)
+		}
+		return
+	}
+
+	/* Build the new comment header */
+
+	tc, err := Theora.NewTheoraComment()
+	check(err)
+	tc.Init()
+	defer tc.Done()
+
+	tc.SetVendor(old.GetVendor())
+	if len(*vendor) > 0 {
+		tc.SetVendor(*vendor)
+	}
+	if !*clear {
+		for i := 0; i < old.TagsCount(); i++ {
+			if tc.AddChecked(old.GetTag(i)) != nil {
+				fmt.Fprintf(os.Stderr, "Invalid comment %q dropped\n", old.GetTag(i))
+			}
+		}
+	}
+	for _, tag := range del {
+		tc.Remove(tag)
+	}
+	for _, c := range set {
+		tag, value, ok := strings.Cut(c, "=")
+		if !ok {
+			check(Theora.ETheoraInvalidCommentException)
+		}
+		check(tc.Replace(tag, value))
+	}
+	for _, c := range add {
+		check(tc.AddChecked(c))
+	}
+	if len(*poster) > 0 {
+		data, err := os.ReadFile(*poster)
+		check(err)
+		pic, err := Theora.NewPictureFromData(Theora.PictureFrontCover, data, filepath.Base(*poster))
+		check(err)
+		check(tc.AddPicture(pic))
+	}
+
+	/* Rewrite the file. The result goes to a temporary file next to
+	   the output, which replaces the output on success */
+
+	inf, err = os.Open(input)
+	check(err)
+	defer inf.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(output), ".comment-*.ogv")
+	check(err)
+	defer os.Remove(tmp.Name())
+	st, err := inf.Stat()
+	check(err)
+	check(tmp.Chmod(st.Mode().Perm()))
+
+	w := bufio.NewWriter(tmp)
+	err = Theora.RewriteComment(bufio.NewReader(inf), w, tc)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	check(err)
+	check(os.Rename(tmp.Name(), output))
+}
@@ -0,0 +1,131 @@
+/* GoTheora
+A test of the encoder config validation: each wrong field is reported
+as ETheoraInvalidConfigException naming the field
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"example.com/ilya2ik/gotheora/internal/testutil"
+	Theora "github.com/ilya2ik/gotheora"
+)
+
+const (
+	WIDTH  = 64
+	HEIGHT = 48
+)
+
+// configErrors splits the joined errors of Validate
+func configErrors(err error) []error {
+	if err == nil {
+		return nil
+	}
+	if j, ok := err.(interface{ Unwrap() []error }); ok {
+		return j.Unwrap()
+	}
+	return []error{err}
+}
+
+// hasField checks that err is a config error of the field
+func hasField(err error, field string) bool {
+	return errors.Is(err, Theora.ETheoraInvalidConfigException) &&
+		strings.HasPrefix(err.Error(), "Invalid encoder config. "+field+":")
+}
+
+type badConfig struct {
+	name  string
+	field string
+	set   func(c *Theora.EncoderConfig)
+}
+
+var badConfigs = []badConfig{
+	{"zero width", "Width/Height", func(c *Theora.EncoderConfig) { c.Width = 0 }},
+	{"negative height", "Width/Height", func(c *Theora.EncoderConfig) { c.Height = -1 }},
+	{"frame width not a multiple of 16", "FrameWidth/FrameHeight", func(c *Theora.EncoderConfig) { c.FrameWidth = 72 }},
+	{"too large frame", "FrameWidth/FrameHeight", func(c *Theora.EncoderConfig) {
+		c.Align = Theora.AlignTopLeft
+		c.FrameWidth = 0x100000
+	}},
+	{"picture does not fit the frame", "FrameWidth/FrameHeight", func(c *Theora.EncoderConfig) { c.FrameHeight = 32 }},
+	{"negative offset", "Offset", func(c *Theora.EncoderConfig) {
+		c.Align = Theora.AlignCustom
+		c.Offset = image.Pt(-2, 0)
+	}},
+	{"too large offset", "Offset", func(c *Theora.EncoderConfig) {
+		c.Align = Theora.AlignCustom
+		c.Offset = image.Pt(256, 0)
+	}},
+	{"zero frame rate", "FPSNumerator/FPSDenominator", func(c *Theora.EncoderConfig) { c.FPSNumerator = 0 }},
+	{"negative frame rate", "FPSNumerator/FPSDenominator", func(c *Theora.EncoderConfig) { c.FPSDenominator = -1 }},
+	{"aspect without denominator", "AspectNumerator/AspectDenominator", func(c *Theora.EncoderConfig) { c.AspectNumerator = 1 }},
+	{"too large aspect", "AspectNumerator/AspectDenominator", func(c *Theora.EncoderConfig) {
+		c.AspectNumerator, c.AspectDenominator = 1<<24, 1
+	}},
+	{"unknown colorspace", "Colorspace", func(c *Theora.EncoderConfig) { c.Colorspace = Theora.NSpaces }},
+	{"4:4:0 pixel format", "PixelFormat", func(c *Theora.EncoderConfig) { c.PixelFormat = image.YCbCrSubsampleRatio440 }},
+	{"quality 64", "Quality", func(c *Theora.EncoderConfig) { c.Quality = 64 }},
+	{"negative bitrate", "Bitrate", func(c *Theora.EncoderConfig) { c.Bitrate = -1 }},
+	{"too large bitrate", "Bitrate", func(c *Theora.EncoderConfig) { c.Bitrate = 1 << 24 }},
+	{"zero keyframe frequency", "KeyframeFrequency", func(c *Theora.EncoderConfig) {
+		c.KeyframeFrequency, c.KeyframeMinDistance = 0, 0
+	}},
+	{"keyframe min distance above frequency", "KeyframeMinDistance", func(c *Theora.EncoderConfig) { c.KeyframeMinDistance = 65 }},
+	{"keyframe threshold 101", "KeyframeAutoThreshold", func(c *Theora.EncoderConfig) { c.KeyframeAutoThreshold = 101 }},
+	{"negative noise sensitivity", "NoiseSensitivity", func(c *Theora.EncoderConfig) { c.NoiseSensitivity = -1 }},
+	{"sharpness 3", "Sharpness", func(c *Theora.EncoderConfig) { c.Sharpness = 3 }},
+	{"unknown rate flags", "RateFlags", func(c *Theora.EncoderConfig) { c.RateFlags = 0x100 }},
+	{"negative rate buffer", "RateBuffer", func(c *Theora.EncoderConfig) { c.RateBuffer = -1 }},
+}
+
+func init() {
+	if strconv.IntSize == 64 {
+		/* the frame rate fields are 32 bits in the header */
+		big := int64(math.MaxUint32) + 1
+		badConfigs = append(badConfigs, badConfig{"frame rate over 32 bits", "FPSNumerator/FPSDenominator",
+			func(c *Theora.EncoderConfig) { c.FPSNumerator = int(big) }})
+	}
+}
+
+func main() {
+	testutil.Expect("default config is valid", Theora.NewEncoderConfig(WIDTH, HEIGHT).Validate() == nil)
+
+	for _, bc := range badConfigs {
+		cfg := Theora.NewEncoderConfig(WIDTH, HEIGHT)
+		bc.set(&cfg)
+		errs := configErrors(cfg.Validate())
+		ok := len(errs) > 0
+		for _, err := range errs {
+			ok = ok && hasField(err, bc.field)
+		}
+		testutil.Expect(fmt.Sprintf("%s is reported for %s", bc.name, bc.field), ok)
+	}
+
+	/* all the problems are reported together */
+	cfg := Theora.NewEncoderConfig(WIDTH, HEIGHT)
+	cfg.Quality = -1
+	cfg.Sharpness = 3
+	err := cfg.Validate()
+	errs := configErrors(err)
+	testutil.Expect("all the wrong fields are reported", len(errs) == 2 &&
+		hasField(errs[0], "Quality") && hasField(errs[1], "Sharpness") &&
+		errors.Is(err, Theora.ETheoraInvalidConfigException))
+
+	_, err = Theora.NewTheoraEncoderConfig(cfg, io.Discard)
+	testutil.Expect("encoder is not made of a wrong config", errors.Is(err, Theora.ETheoraInvalidConfigException))
+
+	testutil.Exit()
+}
@@ -0,0 +1,119 @@
+/* GoTheora
+A test of the typed encoder controls: the quality, the bitrate, the
+keyframe distance, the speed level and the rate control
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+
+	"example.com/ilya2ik/gotheora/internal/testutil"
+	Theora "github.com/ilya2ik/gotheora"
+)
+
+const (
+	WIDTH        = 64
+	HEIGHT       = 48
+	FRAMES_COUNT = 20
+)
+
+func noise(i int) image.Image {
+	return testutil.Noise(WIDTH, HEIGHT, i)
+}
+
+// encode encodes the frames made by frame after the controls are
+// applied by setup
+func encode(frame func(i int) image.Image, setup func(enc Theora.ITheoraEncoder)) []byte {
+	cfg := Theora.NewEncoderConfig(WIDTH, HEIGHT)
+	cfg.SerialNo = 1
+	return testutil.Stream{Config: cfg, Frames: FRAMES_COUNT, Frame: frame, Setup: setup}.Encode()
+}
+
+func isInvalid(err error) bool {
+	var terr *Theora.Error
+	return errors.As(err, &terr) && terr.Code == Theora.CodeInvalid &&
+		errors.Is(err, Theora.ETheoraException)
+}
+
+func testQuality() {
+	low := encode(noise, func(enc Theora.ITheoraEncoder) {
+		testutil.Check(enc.SetQuality(4))
+	})
+	high := encode(noise, func(enc Theora.ITheoraEncoder) {
+		testutil.Check(enc.SetQuality(60))
+	})
+	testutil.Expect("lower quality gives a smaller stream", len(low) < len(high))
+
+	encode(noise, func(enc Theora.ITheoraEncoder) {
+		testutil.Expect("quality 64 is refused", isInvalid(enc.SetQuality(64)))
+	})
+}
+
+func testBitrate() {
+	high := encode(noise, func(enc Theora.ITheoraEncoder) {
+		testutil.Check(enc.SetQuality(63))
+	})
+	low := encode(noise, func(enc Theora.ITheoraEncoder) {
+		testutil.Check(enc.SetBitrate(16000))
+		testutil.Check(enc.SetRateFlags(Theora.RateDropFrames))
+		n, err := enc.SetRateBuffer(10)
+		testutil.Expect("rate buffer is set in the bitrate mode", err == nil && n > 0)
+		testutil.Expect("quality is refused in the bitrate mode", isInvalid(enc.SetQuality(10)))
+	})
+	testutil.Expect("low bitrate gives a smaller stream", len(low) < len(high))
+}
+
+func testKeyframes() {
+	static := func(i int) image.Image { return noise(0) }
+	data := encode(static, func(enc Theora.ITheoraEncoder) {
+		n, err := enc.SetKeyframeFrequencyForce(8)
+		testutil.Expect("keyframe frequency is set", err == nil && n == 8)
+	})
+	kf := testutil.Keyframes(data)
+	testutil.Expect("keyframes every 8 frames", fmt.Sprint(kf) == "[0 8 16]")
+
+	encode(static, func(enc Theora.ITheoraEncoder) {
+		/* the distance is limited by the granule shift of the default
+		   keyframe frequency 64 */
+		n, err := enc.SetKeyframeFrequencyForce(1000)
+		testutil.Expect("keyframe frequency is limited by the granule shift", err == nil && n > 0 && n <= 64)
+	})
+}
+
+func testSpeed() {
+	encode(noise, func(enc Theora.ITheoraEncoder) {
+		top, err := enc.GetSpeedLevelMax()
+		testutil.Expect("speed level max", err == nil && top > 0)
+		testutil.Check(enc.SetSpeedLevel(top))
+		level, err := enc.GetSpeedLevel()
+		testutil.Expect("speed level is set", err == nil && level == top)
+		testutil.Expect("speed level above max is refused", isInvalid(enc.SetSpeedLevel(top+1)))
+		_, err = enc.SetVP3Compatible(true)
+		testutil.Expect("VP3 compatible mode", err == nil)
+	})
+}
+
+func testRaw() {
+	encode(noise, func(enc Theora.ITheoraEncoder) {
+		testutil.Expect("unknown request is not implemented", enc.Control(0x7fff, nil) == int(Theora.CodeImpl))
+	})
+}
+
+func main() {
+	testQuality()
+	testBitrate()
+	testKeyframes()
+	testSpeed()
+	testRaw()
+
+	testutil.Exit()
+}
@@ -0,0 +1,246 @@
+/* GoTheora
+A test of the fast RGB and YCbCr conversion paths against the generic
+path through At and color.NRGBAModel, and of ToYCbCr
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+	"runtime"
+
+	"example.com/ilya2ik/gotheora/internal/testutil"
+	Theora "github.com/ilya2ik/gotheora"
+)
+
+// generic hides the type of the image, so it is converted through At
+// and color.NRGBAModel row by row in one goroutine
+type generic struct {
+	image.Image
+}
+
+var rnd = rand.New(rand.NewSource(1))
+
+/* The sources. Every image has a non-zero Bounds().Min */
+
+func newNRGBA(r image.Rectangle) image.Image {
+	img := image.NewNRGBA(r)
+	rnd.Read(img.Pix)
+	return img
+}
+
+func newRGBA(r image.Rectangle) image.Image {
+	img := image.NewRGBA(r)
+	for i := 0; i < len(img.Pix); i += 4 {
+		/* premultiplied: the components do not exceed the alpha.
+		   The opaque and transparent pixels are kept as well */
+		var a int
+		switch rnd.Intn(4) {
+		case 0:
+			a = 0
+		case 1:
+			a = 255
+		default:
+			a = rnd.Intn(256)
+		}
+		img.Pix[i] = uint8(rnd.Intn(a + 1))
+		img.Pix[i+1] = uint8(rnd.Intn(a + 1))
+		img.Pix[i+2] = uint8(rnd.Intn(a + 1))
+		img.Pix[i+3] = uint8(a)
+	}
+	return img
+}
+
+func newGray(r image.Rectangle) image.Image {
+	img := image.NewGray(r)
+	rnd.Read(img.Pix)
+	return img
+}
+
+func newPaletted(r image.Rectangle) image.Image {
+	pal := make(color.Palette, 256)
+	for i := range pal {
+		pal[i] = color.NRGBA{uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), uint8(rnd.Intn(256))}
+	}
+	img := image.NewPaletted(r, pal)
+	rnd.Read(img.Pix)
+	return img
+}
+
+// newYCbCr returns the image with the samples inside the RGB gamut, so
+// the fast path and the path through RGB do not clamp differently
+func newYCbCr(ratio image.YCbCrSubsampleRatio) func(r image.Rectangle) image.Image {
+	return func(r image.Rectangle) image.Image {
+		img := image.NewYCbCr(r, ratio)
+		for i := range img.Y {
+			img.Y[i] = uint8(64 + rnd.Intn(129))
+		}
+		for i := range img.Cb {
+			img.Cb[i] = uint8(96 + rnd.Intn(65))
+			img.Cr[i] = uint8(96 + rnd.Intn(65))
+		}
+		return img
+	}
+}
+
+// convert converts the image and returns the buffer
+func convert(ratio image.YCbCrSubsampleRatio, img image.Image, opts Theora.ConvertOptions) Theora.ITheoraYUVbuffer {
+	buf, err := Theora.NewTheoraYUVbuffer()
+	testutil.Check(err)
+	if !buf.ConvertFromRasterImageOptions(ratio, img, opts) {
+		buf.Close()
+		return nil
+	}
+	return buf
+}
+
+// planeDiff returns the maximal difference of the samples of the plane
+func planeDiff(a, b []byte, stride, w, h int) int {
+	res := 0
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			d := int(a[y*stride+x]) - int(b[y*stride+x])
+			res = max(res, d, -d)
+		}
+	}
+	return res
+}
+
+// sameBuffers checks that the planes of the buffers differ by 1 at most
+func sameBuffers(a, b Theora.ITheoraYUVbuffer) bool {
+	if a.GetYWidth() != b.GetYWidth() || a.GetYHeight() != b.GetYHeight() ||
+		a.GetUVWidth() != b.GetUVWidth() || a.GetUVHeight() != b.GetUVHeight() ||
+		a.GetYStride() != b.GetYStride() || a.GetUVStride() != b.GetUVStride() {
+		return false
+	}
+	d := planeDiff(a.GetYData(), b.GetYData(), a.GetYStride(), a.GetYWidth(), a.GetYHeight())
+	d = max(d, planeDiff(a.GetUData(), b.GetUData(), a.GetUVStride(), a.GetUVWidth(), a.GetUVHeight()))
+	d = max(d, planeDiff(a.GetVData(), b.GetVData(), a.GetUVStride(), a.GetUVWidth(), a.GetUVHeight()))
+	return d <= 1
+}
+
+var ratios = []struct {
+	name  string
+	ratio image.YCbCrSubsampleRatio
+}{
+	{"4:2:0", image.YCbCrSubsampleRatio420},
+	{"4:2:2", image.YCbCrSubsampleRatio422},
+	{"4:4:4", image.YCbCrSubsampleRatio444},
+}
+
+var sources = []struct {
+	name string
+	new  func(r image.Rectangle) image.Image
+}{
+	{"NRGBA", newNRGBA},
+	{"RGBA", newRGBA},
+	{"Gray", newGray},
+	{"Paletted", newPaletted},
+	{"YCbCr 4:4:4", newYCbCr(image.YCbCrSubsampleRatio444)},
+	{"YCbCr 4:2:2", newYCbCr(image.YCbCrSubsampleRatio422)},
+	{"YCbCr 4:2:0", newYCbCr(image.YCbCrSubsampleRatio420)},
+}
+
+// rects have odd sizes and odd minimal points. The tall one is split
+// by convertBands into several bands
+var rects = []image.Rectangle{
+	image.Rect(3, 5, 3+33, 5+17),
+	image.Rect(-7, 11, -7+61, 11+161),
+}
+
+var options = []struct {
+	name string
+	opts Theora.ConvertOptions
+}{
+	/* BT.601 image.YCbCr is copied without going through RGB */
+	{"BT.601", Theora.ConvertOptions{}},
+	{"BT.709 full range centered", Theora.ConvertOptions{
+		Matrix: Theora.MatrixBT709, Range: Theora.RangeFull, Align: Theora.AlignCenter}},
+	{"BT.601 at (5, 3)", Theora.ConvertOptions{
+		Align: Theora.AlignCustom, Offset: image.Pt(5, 3)}},
+}
+
+// testToYCbCr converts a picture at odd offsets into the frame and
+// back. The luma is random, the chroma is constant, so the chroma does
+// not depend on the subsampling
+func testToYCbCr(ratio image.YCbCrSubsampleRatio, name string) {
+	cfg := Theora.NewEncoderConfig(33, 17)
+	cfg.Align = Theora.AlignCustom
+	cfg.Offset = image.Pt(5, 3)
+	cfg.PixelFormat = ratio
+	inf, err := cfg.NewTheoraInfo()
+	testutil.Check(err)
+	defer inf.Close()
+
+	src := image.NewYCbCr(image.Rect(0, 0, 33, 17), image.YCbCrSubsampleRatio444)
+	rnd.Read(src.Y)
+	for i := range src.Cb {
+		src.Cb[i], src.Cr[i] = 90, 170
+	}
+	buf, err := Theora.NewTheoraYUVbuffer()
+	testutil.Check(err)
+	defer buf.Close()
+	testutil.Expect("convert YCbCr to "+name, buf.ConvertFromRasterImageInfo(inf, src))
+
+	whole := buf.ToYCbCr(nil)
+	testutil.Expect("ToYCbCr without info returns the frame "+name,
+		whole != nil && whole.Bounds() == image.Rect(0, 0, 48, 32))
+
+	ycc := buf.ToYCbCr(inf)
+	testutil.Expect("ToYCbCr returns the picture region "+name,
+		ycc != nil && ycc.Bounds() == image.Rect(5, 3, 38, 20) && ycc.SubsampleRatio == ratio)
+	if ycc == nil || ycc.Bounds() != image.Rect(5, 3, 38, 20) {
+		return
+	}
+	near := func(a, b uint8) bool { return a <= b+1 && b <= a+1 }
+	luma, chroma := true, true
+	for y := 0; y < 17; y++ {
+		for x := 0; x < 33; x++ {
+			luma = luma && near(ycc.Y[ycc.YOffset(5+x, 3+y)], src.Y[src.YOffset(x, y)])
+			co := ycc.COffset(5+x, 3+y)
+			chroma = chroma && near(ycc.Cb[co], 90) && near(ycc.Cr[co], 170)
+		}
+	}
+	testutil.Expect("ToYCbCr luma is in the full range "+name, luma)
+	testutil.Expect("ToYCbCr chroma is in the full range "+name, chroma)
+}
+
+func main() {
+	/* several bands are converted concurrently */
+	runtime.GOMAXPROCS(max(runtime.GOMAXPROCS(0), 4))
+
+	for _, src := range sources {
+		for _, r := range rects {
+			img := src.new(r)
+			for _, ratio := range ratios {
+				for _, o := range options {
+					fast := convert(ratio.ratio, img, o.opts)
+					slow := convert(ratio.ratio, generic{img}, o.opts)
+					name := fmt.Sprintf("%s %dx%d at %v to %s, %s", src.name, r.Dx(), r.Dy(), r.Min, ratio.name, o.name)
+					testutil.Expect(name, fast != nil && slow != nil && sameBuffers(fast, slow))
+					if fast != nil {
+						fast.Close()
+					}
+					if slow != nil {
+						slow.Close()
+					}
+				}
+			}
+		}
+	}
+
+	for _, ratio := range ratios {
+		testToYCbCr(ratio.ratio, ratio.name)
+	}
+
+	testutil.Exit()
+}
@@ -0,0 +1,124 @@
+/* GoTheora
+An example of using an Theora decoder
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"os"
+
+	Theora "github.com/ilya2ik/gotheora"
+)
+
+func check(e error) {
+	if e != nil {
+		panic(e)
+	}
+}
+
+const INPUT_FILE = "../encoder/output.ogv"
+const OUTPUT_FILE = "output.y4m"
+
+func main() {
+	/* Open the input file and read the theora headers */
+
+	inf, err := os.Open(INPUT_FILE)
+	check(err)
+	defer inf.Close()
+
+	reader, err := Theora.NewTheoraReader(bufio.NewReader(inf))
+	check(err)
+	defer reader.Close()
+
+	/* The buffers of the decoded frames are returned to the pool and
+	   reused for the next frames */
+	pool, err := Theora.NewYUVBufferPool(2)
+	check(err)
+	defer pool.Close()
+	reader.SetBufferPool(pool)
+
+	info := reader.Info()
+	comment := reader.Comment()
+
+	fmt.Printf("Vendor: %s\n", comment.GetVendor())
+	for i := 0; i < comment.TagsCount(); i++ {
+		fmt.Printf("Comment: %s\n", comment.GetTag(i))
+	}
+	fmt.Printf("Frame: %dx%d, %d/%d fps\n", info.GetFrameWidth(), info.GetFrameHeight(),
+		info.GetFPSNumerator(), info.GetFPSDenominator())
+
+	/* Create the output file in YUV4MPEG2 format */
+
+	outf, err := os.Create(OUTPUT_FILE)
+	check(err)
+	defer outf.Close()
+
+	w := info.GetFrameWidth()
+	h := info.GetFrameHeight()
+	chroma := info.GetPixelFormat()
+
+	var cs string
+	switch chroma {
+	case image.YCbCrSubsampleRatio444:
+		cs = "444"
+	case image.YCbCrSubsampleRatio422:
+		cs = "422"
+	default:
+		cs = "420jpeg"
+	}
+	fmt.Fprintf(outf, "YUV4MPEG2 W%d H%d F%d:%d Ip A0:0 C%s\n", w, h,
+		info.GetFPSNumerator(), info.GetFPSDenominator(), cs)
+
+	/* Decode the frames one by one and save the visible
+	   region of the planes */
+
+	writePlane := func(data []byte, stride, x, y, w, h int) {
+		for r := y; r < y+h; r++ {
+			_, err := outf.Write(data[r*stride+x : r*stride+x+w])
+			check(err)
+		}
+	}
+
+	cnt := 0
+	for {
+		frame, err := reader.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		check(err)
+
+		buf := frame.Buffer
+		x := info.GetOffsetX()
+		y := info.GetOffsetY()
+		cw, ch, cx, cy := w, h, x, y
+		if chroma != image.YCbCrSubsampleRatio444 {
+			cw, cx = (w+1)>>1, x>>1
+		}
+		if chroma == image.YCbCrSubsampleRatio420 {
+			ch, cy = (h+1)>>1, y>>1
+		}
+
+		_, err = outf.WriteString("FRAME\n")
+		check(err)
+		writePlane(buf.GetYData(), buf.GetYStride(), x, y, w, h)
+		writePlane(buf.GetUData(), buf.GetUVStride(), cx, cy, cw, ch)
+		writePlane(buf.GetVData(), buf.GetUVStride(), cx, cy, cw, ch)
+
+		fmt.Printf("Frame %d (granulepos %d, time %v, keyframe %v)\n",
+			frame.Number, frame.GranulePos, frame.Time, frame.KeyFrame)
+		frame.Release()
+		cnt++
+	}
+
+	fmt.Printf("Finished. %d frames decoded\n", cnt)
+}
@@ -11,6 +11,8 @@ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"image"
 	"image/png"
@@ -36,6 +38,9 @@ const CFG_BITRATE = 45    // desirable bitrate value kbps
 const CFG_DELTATIME = 250 // delta time between two closest frames
 
 func main() {
+	skeleton := flag.Int("skeleton", 0, "add a skeleton track with an index of up to this count of keyframes")
+	flag.Parse()
+
 	/* read the list of files in the specified directory and
 	   save it to the frames array */
 
@@ -72,42 +77,21 @@ func main() {
 	/* initialize the video codec configuration.
 	   detailed info: https://www.theora.org/doc/Theora.pdf */
 
-	info, err := Theora.NewTheoraInfo()
+	/* the frame is rounded up to a multiple of 16, the picture is
+	   centered in it and the padding repeats the picture edges */
+	cfg := Theora.NewEncoderConfig(w, h)
+	cfg.FPSNumerator = 1000 / CFG_DELTATIME
+	cfg.FPSDenominator = 1
+	cfg.PixelFormat = CFG_CHROMA
+	cfg.Quality = CFG_QUALITY * 63 / 10
+	cfg.Bitrate = CFG_BITRATE * 1000
+	cfg.KeyframeFrequency = 32768
+	cfg.KeyframeMinDistance = 8
+	cfg.KeyframeAutoThreshold = 80
+
+	info, err := cfg.NewTheoraInfo()
 	check(err)
 
-	info.SetWidth(((w + 15) >> 4) << 4)
-	info.SetHeight(((h + 15) >> 4) << 4)
-	info.SetFrameWidth(w)
-	info.SetFrameHeight(h)
-	info.SetOffsetX(0)
-	info.SetOffsetY(0)
-
-	video_fps_denominator := 1
-	video_aspect_numerator := 0
-	video_aspect_denominator := 0
-	video_quality := CFG_QUALITY * 63 / 10
-	video_fps_numerator := 1000 / CFG_DELTATIME
-	video_rate := CFG_BITRATE * 1000
-
-	info.SetFPSNumerator(video_fps_numerator)
-	info.SetFPSDenominator(video_fps_denominator)
-	info.SetAspectNumerator(video_aspect_numerator)
-	info.SetAspectDenominator(video_aspect_denominator)
-	info.SetColorspace(Theora.Unspec)
-	info.SetPixelFormat(CFG_CHROMA)
-	info.SetTargetBitrate(video_rate)
-	info.SetQuality(video_quality)
-
-	info.SetDropFrames(false)
-	info.SetQuick(true)
-	info.SetKeyframeAuto(true)
-	info.SetKeyframeFrequency(32768)
-	info.SetKeyframeFrequencyForce(32768)
-	info.SetKeyframeDataTargetBitrate(int(float64(video_rate) * 1.5))
-	info.SetKeyframeAutoThreshold(80)
-	info.SetKeyframeMindistance(8)
-	info.SetNoiseSensitivity(1)
-
 	/* Create the output file */
 
 	outf, err := os.Create(OUTPUT_FILE)
@@ -118,6 +102,16 @@ func main() {
 
 	enc, err := Theora.NewTheoraEncoder(info, outf)
 	check(err)
+	/* Reuse the frame buffers, all the frames have the same size */
+	pool, err := Theora.NewYUVBufferPool(2)
+	check(err)
+	defer pool.Close()
+	enc.SetBufferPool(pool)
+
+	/* Add a skeleton track with the keyframe index for fast seeking */
+	if *skeleton > 0 {
+		check(enc.EnableSkeleton(*skeleton))
+	}
 
 	/* Save the basic theora headers and the additional metadata */
 	comment, err := Theora.NewTheoraComment()
@@ -155,12 +149,11 @@ func main() {
 					fmt.Printf("Finished")
 					next = false
 				} else {
-					buf, err := Theora.NewTheoraYUVbuffer()
-					check(err)
-					if buf.ConvertFromRasterImage(CFG_CHROMA, frame.img) {
-						check(enc.SaveYUVBufferToStream(buf, frame.loc == (total-1)))
-					} else {
+					err := enc.SaveImageToStream(frame.img, frame.loc == (total-1))
+					if errors.Is(err, Theora.ETheoraConvertException) {
 						fmt.Printf("Can't ConvertFromRasterImage at frame %d\n", frame.loc)
+					} else {
+						check(err)
 					}
 				}
 			}
@@ -0,0 +1,133 @@
+/* GoTheora
+A test of the error model: the result codes, the operations, the
+frame numbers and the sentinels
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+
+	"example.com/ilya2ik/gotheora/internal/testutil"
+	Theora "github.com/ilya2ik/gotheora"
+)
+
+const (
+	WIDTH        = 64
+	HEIGHT       = 48
+	FRAMES_COUNT = 4
+)
+
+// failingWriter accepts limit bytes and fails after that
+type failingWriter struct {
+	limit int
+}
+
+var errWriteFailed = errors.New("write failed")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		return 0, errWriteFailed
+	}
+	w.limit -= len(p)
+	return len(p), nil
+}
+
+func newEncoder(str *failingWriter) Theora.ITheoraEncoder {
+	cfg := Theora.NewEncoderConfig(WIDTH, HEIGHT)
+	cfg.SerialNo = 1
+	enc, err := Theora.NewTheoraEncoderConfig(cfg, str)
+	testutil.Check(err)
+	return enc
+}
+
+// testCodes checks the code of the library and the operation
+func testCodes() {
+	enc := newEncoder(&failingWriter{1 << 20})
+	defer enc.Close()
+	testutil.Check(enc.SaveDefHeadersToStream())
+
+	err := enc.SetSerialNo(2)
+	var terr *Theora.Error
+	testutil.Expect("SetSerialNo after the headers is *Error", errors.As(err, &terr))
+	testutil.Expect("the code is OC_EINVAL", terr != nil && terr.Code == Theora.CodeInvalid)
+	testutil.Expect("the operation is SetSerialNo", terr != nil && terr.Op == "TheoraEncoder.SetSerialNo")
+	testutil.Expect("the code matches", errors.Is(err, &Theora.Error{Code: Theora.CodeInvalid}))
+	testutil.Expect("the other code does not match", !errors.Is(err, &Theora.Error{Code: Theora.CodeBadPacket}))
+	testutil.Expect("a failure matches ETheoraException", errors.Is(err, Theora.ETheoraException))
+}
+
+// testFrame checks the frame number and the sentinel of a frame of a
+// wrong size
+func testFrame() {
+	enc := newEncoder(&failingWriter{1 << 20})
+	defer enc.Close()
+	testutil.Check(enc.SaveDefHeadersToStream())
+	for i := 0; i < FRAMES_COUNT; i++ {
+		testutil.Check(enc.SaveImageToStream(testutil.Gradient(WIDTH, HEIGHT, i), false))
+	}
+
+	buf, err := Theora.NewTheoraYUVbuffer()
+	testutil.Check(err)
+	defer buf.Close()
+	testutil.Expect("convert the frame of other size", buf.ConvertFromRasterImage(image.YCbCrSubsampleRatio420, testutil.Gradient(WIDTH*2, HEIGHT, 0)))
+	err = enc.SaveYUVBufferToStream(buf, true)
+	var terr *Theora.Error
+	testutil.Expect("the frame of other size is *Error", errors.As(err, &terr))
+	testutil.Expect("the frame number is known", terr != nil && terr.Frame == FRAMES_COUNT)
+	testutil.Expect("the frame differs", errors.Is(err, Theora.ETheoraEncDifferException))
+	fmt.Printf("     %v\n", err)
+
+	err = enc.SaveImageToStream(testutil.Gradient(WIDTH*2, HEIGHT*2, 0), true)
+	testutil.Expect("the image does not fit", errors.Is(err, Theora.ETheoraConvertException))
+	testutil.Expect("the conversion is not a failure of libtheora", !errors.Is(err, Theora.ETheoraException))
+}
+
+// testWriter checks that the errors of the writer are wrapped
+func testWriter() {
+	enc := newEncoder(&failingWriter{64})
+	defer enc.Close()
+	err := enc.SaveDefHeadersToStream()
+	var terr *Theora.Error
+	testutil.Expect("the writer error is wrapped", errors.Is(err, errWriteFailed))
+	testutil.Expect("the writer error is *Error", errors.As(err, &terr) && len(terr.Op) > 0)
+	testutil.Expect("the writer error is not a failure of libtheora", !errors.Is(err, Theora.ETheoraException))
+}
+
+// testCompleted checks that the end of the encoding is not a failure
+func testCompleted() {
+	enc := newEncoder(&failingWriter{1 << 20})
+	defer enc.Close()
+	testutil.Check(enc.SaveDefHeadersToStream())
+	testutil.Check(enc.SaveImageToStream(testutil.Gradient(WIDTH, HEIGHT, 0), true))
+	_, err := enc.DoPacketOut(true)
+	testutil.Expect("no packets after the last one", err != nil)
+	testutil.Expect("the end of the encoding is not a failure", !errors.Is(err, Theora.ETheoraException))
+}
+
+// testReader checks the errors of the reader
+func testReader() {
+	_, err := Theora.NewTheoraReader(bytes.NewReader([]byte("not an ogg stream")))
+	testutil.Expect("no stream", errors.Is(err, Theora.ETheoraNoStreamException))
+	var terr *Theora.Error
+	testutil.Expect("no stream is *Error of NewTheoraReader", errors.As(err, &terr) && terr.Op == "NewTheoraReader")
+}
+
+func main() {
+	testCodes()
+	testFrame()
+	testWriter()
+	testCompleted()
+	testReader()
+
+	testutil.Exit()
+}
@@ -0,0 +1,303 @@
+/* GoTheora
+A round-trip test of the TheoraInfo fields
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+
+	"example.com/ilya2ik/gotheora/internal/testutil"
+	Theora "github.com/ilya2ik/gotheora"
+)
+
+/* Every case sets some fields of the info, reads them back with the
+   getters, encodes a few frames into memory and compares the info
+   decoded from the emitted header. The fields not stored in the
+   header are checked by the getters only */
+
+const FRAMES_COUNT = 3
+
+type infoCase struct {
+	name string
+	set  func(inf Theora.ITheoraInfo)
+	// Checks the values read back with the getters
+	get func(inf Theora.ITheoraInfo) error
+	// The fields are stored in the header, get also checks the info
+	// decoded from it
+	header bool
+}
+
+func expect(name string, got, want any) error {
+	if got != want {
+		return fmt.Errorf("%s = %v, want %v", name, got, want)
+	}
+	return nil
+}
+
+func expectAll(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func frameSize(w, h, pw, ph, x, y int) func(inf Theora.ITheoraInfo) error {
+	return func(inf Theora.ITheoraInfo) error {
+		return expectAll(
+			expect("Width", inf.GetWidth(), w),
+			expect("Height", inf.GetHeight(), h),
+			expect("FrameWidth", inf.GetFrameWidth(), pw),
+			expect("FrameHeight", inf.GetFrameHeight(), ph),
+			expect("OffsetX", inf.GetOffsetX(), x),
+			expect("OffsetY", inf.GetOffsetY(), y))
+	}
+}
+
+func flag(name string, set func(inf Theora.ITheoraInfo, v bool), get func(inf Theora.ITheoraInfo) bool, value bool) infoCase {
+	return infoCase{
+		name: fmt.Sprintf("%s=%v", name, value),
+		set:  func(inf Theora.ITheoraInfo) { set(inf, value) },
+		get: func(inf Theora.ITheoraInfo) error {
+			return expect(name, get(inf), value)
+		},
+	}
+}
+
+func number(name string, set func(inf Theora.ITheoraInfo, v int), get func(inf Theora.ITheoraInfo) int, value int) infoCase {
+	return infoCase{
+		name: fmt.Sprintf("%s=%d", name, value),
+		set:  func(inf Theora.ITheoraInfo) { set(inf, value) },
+		get: func(inf Theora.ITheoraInfo) error {
+			return expect(name, get(inf), value)
+		},
+	}
+}
+
+var cases = []infoCase{
+	{
+		name: "Default",
+		set:  func(inf Theora.ITheoraInfo) {},
+		get: func(inf Theora.ITheoraInfo) error {
+			return expectAll(
+				frameSize(64, 48, 64, 48, 0, 0)(inf),
+				expect("FPSNumerator", inf.GetFPSNumerator(), 25),
+				expect("FPSDenominator", inf.GetFPSDenominator(), 1),
+				expect("PixelFormat", inf.GetPixelFormat(), image.YCbCrSubsampleRatio420),
+				expect("Quality", inf.GetQuality(), 32))
+		},
+		header: true,
+	},
+	{
+		name: "Picture",
+		set: func(inf Theora.ITheoraInfo) {
+			inf.SetWidth(80)
+			inf.SetHeight(64)
+			inf.SetFrameWidth(70)
+			inf.SetFrameHeight(50)
+			inf.SetOffsetX(4)
+			inf.SetOffsetY(6)
+		},
+		get:    frameSize(80, 64, 70, 50, 4, 6),
+		header: true,
+	},
+	{
+		name: "FPS",
+		set: func(inf Theora.ITheoraInfo) {
+			inf.SetFPSNumerator(30000)
+			inf.SetFPSDenominator(1001)
+		},
+		get: func(inf Theora.ITheoraInfo) error {
+			return expectAll(
+				expect("FPSNumerator", inf.GetFPSNumerator(), 30000),
+				expect("FPSDenominator", inf.GetFPSDenominator(), 1001))
+		},
+		header: true,
+	},
+	{
+		name: "Aspect",
+		set: func(inf Theora.ITheoraInfo) {
+			inf.SetAspectNumerator(4)
+			inf.SetAspectDenominator(3)
+		},
+		get: func(inf Theora.ITheoraInfo) error {
+			return expectAll(
+				expect("AspectNumerator", inf.GetAspectNumerator(), 4),
+				expect("AspectDenominator", inf.GetAspectDenominator(), 3))
+		},
+		header: true,
+	},
+	{
+		name: "Colorspace",
+		set:  func(inf Theora.ITheoraInfo) { inf.SetColorspace(Theora.ITURec470BG) },
+		get: func(inf Theora.ITheoraInfo) error {
+			return expect("Colorspace", inf.GetColorspace(), Theora.ITURec470BG)
+		},
+		header: true,
+	},
+	{
+		name: "PixelFormat=422",
+		set:  func(inf Theora.ITheoraInfo) { inf.SetPixelFormat(image.YCbCrSubsampleRatio422) },
+		get: func(inf Theora.ITheoraInfo) error {
+			return expect("PixelFormat", inf.GetPixelFormat(), image.YCbCrSubsampleRatio422)
+		},
+		header: true,
+	},
+	{
+		name: "PixelFormat=444",
+		set:  func(inf Theora.ITheoraInfo) { inf.SetPixelFormat(image.YCbCrSubsampleRatio444) },
+		get: func(inf Theora.ITheoraInfo) error {
+			return expect("PixelFormat", inf.GetPixelFormat(), image.YCbCrSubsampleRatio444)
+		},
+		header: true,
+	},
+	{
+		name: "Quality",
+		set:  func(inf Theora.ITheoraInfo) { inf.SetQuality(17) },
+		get: func(inf Theora.ITheoraInfo) error {
+			return expect("Quality", inf.GetQuality(), 17)
+		},
+		header: true,
+	},
+	{
+		name: "TargetBitrate",
+		set:  func(inf Theora.ITheoraInfo) { inf.SetTargetBitrate(200000) },
+		get: func(inf Theora.ITheoraInfo) error {
+			return expect("TargetBitrate", inf.GetTargetBitrate(), 200000)
+		},
+		header: true,
+	},
+	{
+		name: "KeyframeFrequencyForce",
+		set:  func(inf Theora.ITheoraInfo) { inf.SetKeyframeFrequencyForce(32) },
+		get: func(inf Theora.ITheoraInfo) error {
+			return expect("KeyframeFrequencyForce", inf.GetKeyframeFrequencyForce(), 32)
+		},
+		header: true,
+	},
+	flag("DropFrames", Theora.ITheoraInfo.SetDropFrames, Theora.ITheoraInfo.GetDropFrames, true),
+	flag("DropFrames", Theora.ITheoraInfo.SetDropFrames, Theora.ITheoraInfo.GetDropFrames, false),
+	flag("KeyframeAuto", Theora.ITheoraInfo.SetKeyframeAuto, Theora.ITheoraInfo.GetKeyframeAuto, true),
+	flag("KeyframeAuto", Theora.ITheoraInfo.SetKeyframeAuto, Theora.ITheoraInfo.GetKeyframeAuto, false),
+	flag("Quick", Theora.ITheoraInfo.SetQuick, Theora.ITheoraInfo.GetQuick, true),
+	flag("Quick", Theora.ITheoraInfo.SetQuick, Theora.ITheoraInfo.GetQuick, false),
+	number("KeyframeFrequency", Theora.ITheoraInfo.SetKeyframeFrequency, Theora.ITheoraInfo.GetKeyframeFrequency, 48),
+	number("KeyframeMindistance", Theora.ITheoraInfo.SetKeyframeMindistance, Theora.ITheoraInfo.GetKeyframeMindistance, 4),
+	number("KeyframeAutoThreshold", Theora.ITheoraInfo.SetKeyframeAutoThreshold, Theora.ITheoraInfo.GetKeyframeAutoThreshold, 60),
+	number("KeyframeDataTargetBitrate", Theora.ITheoraInfo.SetKeyframeDataTargetBitrate, Theora.ITheoraInfo.GetKeyframeDataTargetBitrate, 300000),
+	number("NoiseSensitivity", Theora.ITheoraInfo.SetNoiseSensitivity, Theora.ITheoraInfo.GetNoiseSensitivity, 2),
+	number("Sharpness", Theora.ITheoraInfo.SetSharpness, Theora.ITheoraInfo.GetSharpness, 1),
+}
+
+// newInfo returns the base info of all the cases
+func newInfo() (Theora.ITheoraInfo, error) {
+	inf, err := Theora.NewTheoraInfo()
+	if err != nil {
+		return nil, err
+	}
+	inf.Init()
+	inf.SetWidth(64)
+	inf.SetHeight(48)
+	inf.SetFrameWidth(64)
+	inf.SetFrameHeight(48)
+	inf.SetFPSNumerator(25)
+	inf.SetFPSDenominator(1)
+	inf.SetPixelFormat(image.YCbCrSubsampleRatio420)
+	inf.SetQuality(32)
+	inf.SetKeyframeFrequency(64)
+	inf.SetKeyframeFrequencyForce(64)
+	return inf, nil
+}
+
+// encode writes the headers and a few frames of a gradient
+func encode(inf Theora.ITheoraInfo) ([]byte, error) {
+	var out bytes.Buffer
+	err := testutil.Stream{
+		Info:   inf,
+		Frames: FRAMES_COUNT,
+		Frame: func(i int) image.Image {
+			return testutil.Gradient(inf.GetFrameWidth(), inf.GetFrameHeight(), i)
+		},
+		Setup: func(enc Theora.ITheoraEncoder) {
+			testutil.Check(enc.SetSerialNo(1))
+		},
+	}.EncodeTo(&out)
+	return out.Bytes(), err
+}
+
+// decode counts the frames and passes the info of the header to fn
+// before the reader is closed
+func decode(data []byte, fn func(inf Theora.ITheoraInfo) error) (int, error) {
+	reader, err := Theora.NewTheoraReader(bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	cnt := 0
+	for {
+		_, err = reader.ReadFrame()
+		if err == io.EOF {
+			return cnt, fn(reader.Info())
+		}
+		if err != nil {
+			return cnt, err
+		}
+		cnt++
+	}
+}
+
+func run(c infoCase) error {
+	inf, err := newInfo()
+	if err != nil {
+		return err
+	}
+	c.set(inf)
+	err = c.get(inf)
+	if err != nil {
+		return fmt.Errorf("getter: %v", err)
+	}
+
+	data, err := encode(inf)
+	if err != nil {
+		return fmt.Errorf("encode: %v", err)
+	}
+	var header error
+	cnt, err := decode(data, func(dec Theora.ITheoraInfo) error {
+		if c.header {
+			header = c.get(dec)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("decode: %v", err)
+	}
+	if cnt != FRAMES_COUNT {
+		return fmt.Errorf("decode: %d frames, want %d", cnt, FRAMES_COUNT)
+	}
+	if header != nil {
+		return fmt.Errorf("header: %v", header)
+	}
+	return nil
+}
+
+func main() {
+	for _, c := range cases {
+		err := run(c)
+		testutil.Expect(c.name, err == nil)
+		if err != nil {
+			fmt.Printf("     %v\n", err)
+		}
+	}
+	testutil.Exit()
+}
@@ -0,0 +1,54 @@
+/* GoTheora
+The pictures of the test streams
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package testutil
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+)
+
+// Noise returns the frame i of random gray pixels. The frames of the
+// same index are equal
+func Noise(w, h, i int) image.Image {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	rand.New(rand.NewSource(int64(i + 1))).Read(img.Pix)
+	return img
+}
+
+// Gradient returns the frame i of a color gradient. The blue channel
+// changes from frame to frame
+func Gradient(w, h, i int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 4), uint8(y * 4), uint8(i * 16), 255})
+		}
+	}
+	return img
+}
+
+// Motion returns the frames of a gray noise texture. The frame i is
+// the texture moved to the right by i pixels, up to count pixels, so
+// every frame differs from the previous one and the motion search has
+// the only best match
+func Motion(w, h, count int) func(i int) image.Image {
+	texture := make([]uint8, (w+count)*h)
+	rand.New(rand.NewSource(1)).Read(texture)
+	return func(i int) image.Image {
+		img := image.NewGray(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			row := texture[y*(w+count)+count-i:]
+			copy(img.Pix[y*img.Stride:(y+1)*img.Stride], row)
+		}
+		return img
+	}
+}
@@ -0,0 +1,166 @@
+/* GoTheora
+The ogg pages of the test streams
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package testutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+/* The pages are parsed in Go, independently of libogg, so the
+   streams written by the wrapper can be checked byte by byte */
+
+const (
+	PageContinued = 0x01
+	PageBOS       = 0x02
+	PageEOS       = 0x04
+
+	pageHeaderSize = 27
+)
+
+var errBadPage = errors.New("bad ogg page")
+
+// Page is an ogg page
+type Page struct {
+	HeaderType byte
+	Granule    int64
+	Serial     uint32
+	Sequence   uint32
+	CRC        uint32
+	Lacing     []byte
+	Body       []byte
+}
+
+// Packet is a packet of a logical stream. Granule is the granule
+// position of the page where the packet ends if it is the last packet
+// ending on that page, otherwise -1
+type Packet struct {
+	Data    []byte
+	Granule int64
+}
+
+var crcTable = func() (res [256]uint32) {
+	for i := range res {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		res[i] = r
+	}
+	return
+}()
+
+func pageCRC(data []byte) uint32 {
+	var crc uint32
+	for _, b := range data {
+		crc = crc<<8 ^ crcTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+// Bytes returns the page with the CRC calculated from its content
+func (p Page) Bytes() []byte {
+	res := make([]byte, pageHeaderSize, pageHeaderSize+len(p.Lacing)+len(p.Body))
+	copy(res, "OggS")
+	res[5] = p.HeaderType
+	binary.LittleEndian.PutUint64(res[6:], uint64(p.Granule))
+	binary.LittleEndian.PutUint32(res[14:], p.Serial)
+	binary.LittleEndian.PutUint32(res[18:], p.Sequence)
+	res[26] = byte(len(p.Lacing))
+	res = append(res, p.Lacing...)
+	res = append(res, p.Body...)
+	binary.LittleEndian.PutUint32(res[22:], pageCRC(res))
+	return res
+}
+
+// ValidCRC tells whether the CRC of the page matches its content
+func (p Page) ValidCRC() bool {
+	return binary.LittleEndian.Uint32(p.Bytes()[22:]) == p.CRC
+}
+
+// NewPage makes a page of the packets, each packet ends on the page
+func NewPage(serial, sequence uint32, headerType byte, granule int64, packets ...[]byte) Page {
+	p := Page{HeaderType: headerType, Granule: granule, Serial: serial, Sequence: sequence}
+	for _, packet := range packets {
+		n := len(packet)
+		for ; n >= 255; n -= 255 {
+			p.Lacing = append(p.Lacing, 255)
+		}
+		p.Lacing = append(p.Lacing, byte(n))
+		p.Body = append(p.Body, packet...)
+	}
+	return p
+}
+
+// ParsePages splits the physical stream into the pages
+func ParsePages(data []byte) ([]Page, error) {
+	var res []Page
+	for len(data) > 0 {
+		if len(data) < pageHeaderSize || !bytes.HasPrefix(data, []byte("OggS")) || data[4] != 0 {
+			return res, errBadPage
+		}
+		nsegs := int(data[26])
+		if len(data) < pageHeaderSize+nsegs {
+			return res, errBadPage
+		}
+		p := Page{
+			HeaderType: data[5],
+			Granule:    int64(binary.LittleEndian.Uint64(data[6:])),
+			Serial:     binary.LittleEndian.Uint32(data[14:]),
+			Sequence:   binary.LittleEndian.Uint32(data[18:]),
+			CRC:        binary.LittleEndian.Uint32(data[22:]),
+			Lacing:     bytes.Clone(data[pageHeaderSize : pageHeaderSize+nsegs]),
+		}
+		size := 0
+		for _, l := range p.Lacing {
+			size += int(l)
+		}
+		data = data[pageHeaderSize+nsegs:]
+		if len(data) < size {
+			return res, errBadPage
+		}
+		p.Body = bytes.Clone(data[:size])
+		data = data[size:]
+		res = append(res, p)
+	}
+	return res, nil
+}
+
+// Packets joins the packets of the logical stream serial
+func Packets(pages []Page, serial uint32) []Packet {
+	var res []Packet
+	var cur []byte
+	for _, p := range pages {
+		if p.Serial != serial {
+			continue
+		}
+		last := -1
+		body := p.Body
+		for _, l := range p.Lacing {
+			cur = append(cur, body[:l]...)
+			body = body[l:]
+			if l < 255 {
+				res = append(res, Packet{Data: cur, Granule: -1})
+				cur = nil
+				last = len(res) - 1
+			}
+		}
+		if last >= 0 {
+			res[last].Granule = p.Granule
+		}
+	}
+	return res
+}
@@ -0,0 +1,121 @@
+/* GoTheora
+The encoded test streams
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package testutil
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"os"
+
+	Theora "github.com/ilya2ik/gotheora"
+)
+
+// Stream describes a test stream
+type Stream struct {
+	// The encoder is made of Config with NewTheoraEncoderConfig, or of
+	// Info with NewTheoraEncoder if Info is set
+	Config Theora.EncoderConfig
+	Info   Theora.ITheoraInfo
+	// The number of the frames and the picture of the frame i
+	Frames int
+	Frame  func(i int) image.Image
+	// Setup is called before the headers are saved
+	Setup func(enc Theora.ITheoraEncoder)
+}
+
+// EncodeTo encodes the stream into out. The last frame ends the
+// stream
+func (s Stream) EncodeTo(out io.Writer) error {
+	var enc Theora.ITheoraEncoder
+	var err error
+	if s.Info != nil {
+		enc, err = Theora.NewTheoraEncoder(s.Info, out)
+	} else {
+		enc, err = Theora.NewTheoraEncoderConfig(s.Config, out)
+	}
+	if err != nil {
+		return err
+	}
+	defer enc.Close()
+	if s.Setup != nil {
+		s.Setup(enc)
+	}
+	err = enc.SaveDefHeadersToStream()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < s.Frames; i++ {
+		err = enc.SaveImageToStream(s.Frame(i), i == s.Frames-1)
+		if err != nil {
+			return err
+		}
+	}
+	return enc.Close()
+}
+
+// Encode encodes the stream into memory
+func (s Stream) Encode() []byte {
+	var out bytes.Buffer
+	Check(s.EncodeTo(&out))
+	return out.Bytes()
+}
+
+// EncodeFile encodes the stream into a temporary file, so the output
+// is seekable, and returns its content
+func (s Stream) EncodeFile() []byte {
+	file, err := os.CreateTemp("", "testutil-*.ogv")
+	Check(err)
+	defer os.Remove(file.Name())
+	defer file.Close()
+	Check(s.EncodeTo(file))
+	data, err := os.ReadFile(file.Name())
+	Check(err)
+	return data
+}
+
+// ReadFrames decodes the frames of data and passes them to fn. The
+// frame is released after fn returns. Returns the number of the
+// frames decoded before io.EOF or the error
+func ReadFrames(data []byte, fn func(reader Theora.ITheoraReader, frame *Theora.TheoraFrame)) (int, error) {
+	reader, err := Theora.NewTheoraReader(bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	cnt := 0
+	for {
+		frame, err := reader.ReadFrame()
+		if err == io.EOF {
+			return cnt, nil
+		}
+		if err != nil {
+			return cnt, err
+		}
+		if fn != nil {
+			fn(reader, frame)
+		}
+		frame.Release()
+		cnt++
+	}
+}
+
+// Keyframes returns the numbers of the keyframes of the stream
+func Keyframes(data []byte) []int64 {
+	var res []int64
+	_, err := ReadFrames(data, func(reader Theora.ITheoraReader, frame *Theora.TheoraFrame) {
+		if frame.KeyFrame {
+			res = append(res, frame.Number)
+		}
+	})
+	Check(err)
+	return res
+}
@@ -0,0 +1,49 @@
+/* GoTheora
+The shared harness and fixtures of the test programs
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+// Package testutil is the harness of the test programs: the checks
+// are printed one per line as "ok   name" or "FAIL name", Exit prints
+// the summary and sets the exit status
+package testutil
+
+import (
+	"fmt"
+	"os"
+)
+
+var failed int
+
+// Check panics on the error. It is used where the test can not go on
+func Check(e error) {
+	if e != nil {
+		panic(e)
+	}
+}
+
+// Expect prints the result of the named check and counts the failures
+func Expect(name string, ok bool) {
+	if ok {
+		fmt.Printf("ok   %s\n", name)
+	} else {
+		failed++
+		fmt.Printf("FAIL %s\n", name)
+	}
+}
+
+// Exit prints the summary and exits with the status 1 if any check
+// failed. It is also used to stop the test early after a failure
+func Exit() {
+	if failed > 0 {
+		fmt.Printf("%d checks failed\n", failed)
+		os.Exit(1)
+	}
+	fmt.Println("All checks passed")
+	os.Exit(0)
+}
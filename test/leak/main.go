@@ -0,0 +1,201 @@
+/* GoTheora
+A test of the explicit lifecycle of the wrappers in the leak tracking
+mode
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"runtime"
+	"time"
+
+	"example.com/ilya2ik/gotheora/internal/testutil"
+	Theora "github.com/ilya2ik/gotheora"
+)
+
+const (
+	WIDTH        = 64
+	HEIGHT       = 48
+	FRAMES_COUNT = 8
+)
+
+func live(kind string) int64 {
+	return Theora.LiveAllocations()[kind]
+}
+
+// collect runs the garbage collector until the finalizers have had
+// the time to run
+func collect() {
+	for i := 0; i < 10; i++ {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// testEncode closes the info before the encoder. The encoder state
+// keeps the info until the encoder is closed
+func testEncode() []byte {
+	cfg := Theora.NewEncoderConfig(WIDTH, HEIGHT)
+	cfg.SerialNo = 1
+	info, err := cfg.NewTheoraInfo()
+	testutil.Check(err)
+
+	var out bytes.Buffer
+	enc, err := Theora.NewTheoraEncoder(info, &out)
+	testutil.Check(err)
+	testutil.Check(info.Close())
+	testutil.Expect("closed info is kept by the encoder state", live("TheoraInfo") == 1)
+
+	testutil.Check(enc.SetSerialNo(1))
+	testutil.Check(enc.SaveDefHeadersToStream())
+	for i := 0; i < FRAMES_COUNT; i++ {
+		img := testutil.Gradient(WIDTH, HEIGHT, i)
+		buf, err := Theora.NewTheoraYUVbuffer()
+		testutil.Check(err)
+		testutil.Expect(fmt.Sprintf("convert frame %d", i), buf.ConvertFromRasterImage(image.YCbCrSubsampleRatio420, img))
+		testutil.Check(enc.SaveYUVBufferToStream(buf, i == FRAMES_COUNT-1))
+		testutil.Check(buf.Close())
+		testutil.Check(buf.Close())
+	}
+	testutil.Check(enc.Close())
+	testutil.Expect("encoder double close", enc.Close() == nil)
+	testutil.Expect("encoder releases the state and the info", live("TheoraState") == 0 && live("TheoraInfo") == 0)
+	return out.Bytes()
+}
+
+// testEncodeConfig builds the encoder of the configuration, the
+// info made by the constructor is released with the encoder
+func testEncodeConfig() {
+	cfg := Theora.NewEncoderConfig(WIDTH, HEIGHT)
+	cfg.SerialNo = 1
+	var out bytes.Buffer
+	enc, err := Theora.NewTheoraEncoderConfig(cfg, &out)
+	testutil.Check(err)
+	testutil.Expect("config encoder holds one info", live("TheoraInfo") == 1)
+	testutil.Check(enc.SaveDefHeadersToStream())
+	testutil.Check(enc.SaveImageToStream(testutil.Gradient(WIDTH, HEIGHT, 0), true))
+	testutil.Check(enc.Close())
+	testutil.Expect("config encoder releases the state and the info", live("TheoraState") == 0 && live("TheoraInfo") == 0)
+}
+
+// testDecode closes every frame and the reader, the reader releases
+// its info and its comment
+func testDecode(data []byte) {
+	reader, err := Theora.NewTheoraReader(bytes.NewReader(data))
+	testutil.Check(err)
+	cnt := 0
+	for {
+		frame, err := reader.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		testutil.Check(err)
+		testutil.Check(frame.Buffer.Close())
+		cnt++
+	}
+	testutil.Expect("all the frames decoded", cnt == FRAMES_COUNT)
+
+	info := reader.Info()
+	testutil.Check(info.Close())
+	testutil.Expect("closed info is kept by the decoder state", live("TheoraInfo") == 1)
+	testutil.Check(reader.Close())
+	testutil.Expect("reader double close", reader.Close() == nil)
+	testutil.Expect("reader releases the decoder, the info and the comment",
+		live("TheoraState") == 0 && live("TheoraInfo") == 0 && live("TheoraComment") == 0)
+	testutil.Expect("released info can be closed again", reader.Info().Close() == nil)
+}
+
+// testTh closes the wrappers of the th_ API twice
+func testTh() {
+	inf, err := Theora.NewThInfo()
+	testutil.Check(err)
+	inf.Init()
+	tc, err := Theora.NewThComment()
+	testutil.Check(err)
+	tc.Init()
+	buf, err := Theora.NewThYCbCrBuffer()
+	testutil.Check(err)
+	testutil.Check(buf.Alloc(WIDTH, HEIGHT, image.YCbCrSubsampleRatio420))
+	for i := 0; i < 2; i++ {
+		testutil.Check(buf.Close())
+		testutil.Check(tc.Close())
+		testutil.Check(inf.Close())
+	}
+}
+
+// testPool decodes the frames into a buffer pool, the frames of
+// the same size reuse one buffer
+func testPool(data []byte) {
+	pool, err := Theora.NewYUVBufferPool(2)
+	testutil.Check(err)
+
+	buf, err := pool.Get(WIDTH, HEIGHT, image.YCbCrSubsampleRatio420)
+	testutil.Check(err)
+	pool.Put(buf)
+	again, err := pool.Get(WIDTH, HEIGHT, image.YCbCrSubsampleRatio420)
+	testutil.Check(err)
+	testutil.Expect("pool reuses the buffer", again == buf && pool.Len() == 0)
+	pool.Put(again)
+
+	reader, err := Theora.NewTheoraReader(bytes.NewReader(data))
+	testutil.Check(err)
+	reader.SetBufferPool(pool)
+	for {
+		frame, err := reader.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		testutil.Check(err)
+		frame.Release()
+	}
+	testutil.Expect("decoded frames share the buffer", live("TheoraYUVbuffer") == 2 && pool.Len() == 1)
+	testutil.Check(reader.Close())
+
+	testutil.Check(pool.Close())
+	testutil.Check(pool.Close())
+	testutil.Expect("pool releases the buffers", live("TheoraYUVbuffer") == 0)
+}
+
+// testLost drops a buffer without Close, the finalizer must find it
+func testLost() {
+	func() {
+		buf, err := Theora.NewTheoraYUVbuffer()
+		testutil.Check(err)
+		testutil.Check(buf.Alloc(WIDTH, HEIGHT, WIDTH/2, HEIGHT/2))
+	}()
+	collect()
+	testutil.Expect("lost buffer is counted as leaked", Theora.LeakedAllocations()["TheoraYUVbuffer"] == 1)
+	testutil.Expect("leak is reported", errors.Is(Theora.CheckLeaks(), Theora.ETheoraLeakException))
+}
+
+func main() {
+	Theora.SetLeakTracking(true)
+
+	data := testEncode()
+	testEncodeConfig()
+	testDecode(data)
+	testPool(data)
+	testTh()
+	collect()
+	err := Theora.CheckLeaks()
+	if err != nil {
+		fmt.Println(err)
+	}
+	testutil.Expect("no leaks", err == nil)
+
+	testLost()
+	Theora.SetLeakTracking(false)
+
+	testutil.Exit()
+}
@@ -0,0 +1,165 @@
+/* GoTheora
+A test of the METADATA_BLOCK_PICTURE comments: the color depth of the
+pictures and the round-trip of the picture block through a comment
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"reflect"
+
+	"example.com/ilya2ik/gotheora/internal/testutil"
+	Theora "github.com/ilya2ik/gotheora"
+)
+
+const (
+	WIDTH  = 24
+	HEIGHT = 16
+)
+
+var rect = image.Rect(0, 0, WIDTH, HEIGHT)
+
+func opaque() image.Image {
+	img := image.NewRGBA(rect)
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+		if i%4 == 3 {
+			img.Pix[i] = 0xff
+		}
+	}
+	return img
+}
+
+func translucent() image.Image {
+	img := image.NewNRGBA(rect)
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	return img
+}
+
+func opaque16() image.Image {
+	img := image.NewRGBA64(rect)
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+		if i%8 >= 6 {
+			img.Pix[i] = 0xff
+		}
+	}
+	return img
+}
+
+func gray() image.Image {
+	img := image.NewGray(rect)
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	return img
+}
+
+var palette = color.Palette{
+	color.RGBA{0, 0, 0, 255}, color.RGBA{255, 0, 0, 255},
+	color.RGBA{0, 255, 0, 255}, color.RGBA{0, 0, 255, 255},
+	color.RGBA{255, 255, 255, 255},
+}
+
+func paletted() image.Image {
+	img := image.NewPaletted(rect, palette)
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i % len(palette))
+	}
+	return img
+}
+
+func testDepth() {
+	for _, tc := range []struct {
+		name          string
+		img           image.Image
+		depth, colors int
+	}{
+		{"RGB", opaque(), 24, 0},
+		{"RGBA", translucent(), 32, 0},
+		{"RGB 16 bit", opaque16(), 48, 0},
+		{"gray", gray(), 8, 0},
+		{"paletted", paletted(), 8, len(palette)},
+	} {
+		pic, err := Theora.NewPictureFromImage(Theora.PictureFrontCover, tc.img, tc.name)
+		testutil.Check(err)
+		testutil.Expect(fmt.Sprintf("PNG %s depth %d", tc.name, tc.depth),
+			pic.MIME == "image/png" && pic.Width == WIDTH && pic.Height == HEIGHT &&
+				pic.Depth == tc.depth && pic.Colors == tc.colors)
+	}
+
+	var buf bytes.Buffer
+	testutil.Check(jpeg.Encode(&buf, opaque(), nil))
+	pic, err := Theora.NewPictureFromData(Theora.PictureFrontCover, buf.Bytes(), "")
+	testutil.Check(err)
+	testutil.Expect("JPEG depth 24", pic.MIME == "image/jpeg" && pic.Depth == 24 && pic.Colors == 0)
+
+	buf.Reset()
+	testutil.Check(gif.Encode(&buf, paletted(), nil))
+	pic, err = Theora.NewPictureFromData(Theora.PictureFrontCover, buf.Bytes(), "")
+	testutil.Check(err)
+	testutil.Expect("GIF palette", pic.MIME == "image/gif" && pic.Depth == 8 && pic.Colors > 0)
+
+	_, err = Theora.NewPictureFromData(Theora.PictureFrontCover, []byte("not a picture"), "")
+	testutil.Expect("unknown data is refused", err == Theora.ETheoraInvalidPictureException)
+}
+
+func testRoundTrip() {
+	pic, err := Theora.NewPictureFromImage(Theora.PictureBackCover, translucent(), "back cover")
+	testutil.Check(err)
+	data, err := pic.MarshalBinary()
+	testutil.Check(err)
+
+	parsed, err := Theora.ParsePicture(base64.StdEncoding.EncodeToString(data))
+	testutil.Check(err)
+	testutil.Expect("ParsePicture restores MarshalBinary", reflect.DeepEqual(pic, parsed))
+
+	img, err := parsed.Image()
+	testutil.Check(err)
+	testutil.Expect("picture data decodes", img.Bounds() == rect)
+
+	_, err = Theora.ParsePicture(base64.StdEncoding.EncodeToString(data[:len(data)-1]))
+	testutil.Expect("truncated block is refused", err == Theora.ETheoraInvalidPictureException)
+	_, err = Theora.ParsePicture("@@@")
+	testutil.Expect("invalid base64 is refused", err == Theora.ETheoraInvalidPictureException)
+
+	/* through the comments */
+	tc, err := Theora.NewTheoraComment()
+	testutil.Check(err)
+	defer tc.Close()
+	tc.Init()
+	front, err := Theora.NewPictureFromImage(Theora.PictureFrontCover, opaque(), "front cover")
+	testutil.Check(err)
+	testutil.Check(tc.AddPicture(front))
+	testutil.Check(tc.AddPicture(pic))
+	tc.AddTag(Theora.TagMetadataBlockPicture, "broken")
+
+	pics := tc.Pictures()
+	testutil.Expect("broken picture comment is skipped", len(pics) == 2)
+	testutil.Expect("pictures keep the order", len(pics) == 2 &&
+		reflect.DeepEqual(pics[0], front) && reflect.DeepEqual(pics[1], pic))
+	testutil.Expect("picture by type", reflect.DeepEqual(tc.Picture(Theora.PictureBackCover), pic))
+	testutil.Expect("missing picture type", tc.Picture(Theora.PictureLeaflet) == nil)
+}
+
+func main() {
+	testDepth()
+	testRoundTrip()
+
+	testutil.Exit()
+}
@@ -0,0 +1,184 @@
+/* GoTheora
+A test of the encode and decode pipelines cancelled by the context
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"io"
+	"time"
+
+	"example.com/ilya2ik/gotheora/internal/testutil"
+	Theora "github.com/ilya2ik/gotheora"
+)
+
+const (
+	WIDTH        = 64
+	HEIGHT       = 48
+	FRAMES_COUNT = 16
+	CANCEL_AT    = 5
+)
+
+// source returns FRAMES_COUNT pictures and calls cancel before the
+// picture number cancelAt
+func source(cancel context.CancelFunc, cancelAt int) Theora.ITheoraImageSource {
+	i := 0
+	return Theora.ImageSourceFunc(func(ctx context.Context) (image.Image, error) {
+		if i == cancelAt {
+			cancel()
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if i == FRAMES_COUNT {
+			return nil, io.EOF
+		}
+		i++
+		return testutil.Gradient(WIDTH, HEIGHT, i), nil
+	})
+}
+
+func encode(ctx context.Context, cancel context.CancelFunc, cancelAt int) ([]byte, int64, error) {
+	cfg := Theora.NewEncoderConfig(WIDTH, HEIGHT)
+	cfg.SerialNo = 1
+	var out bytes.Buffer
+	cnt, err := Theora.EncodeFrames(ctx, cfg, source(cancel, cancelAt), &out)
+	return out.Bytes(), cnt, err
+}
+
+// lastPageEOS tells whether the last ogg page of data is the
+// end-of-stream page
+func lastPageEOS(data []byte) bool {
+	eos := false
+	for len(data) >= 27 && bytes.HasPrefix(data, []byte("OggS")) {
+		nsegs := int(data[26])
+		if len(data) < 27+nsegs {
+			return false
+		}
+		size := 27 + nsegs
+		for _, l := range data[27 : 27+nsegs] {
+			size += int(l)
+		}
+		if len(data) < size {
+			return false
+		}
+		eos = data[5]&0x04 != 0
+		data = data[size:]
+	}
+	return eos && len(data) == 0
+}
+
+// testComplete encodes and decodes all the frames
+func testComplete() []byte {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	data, cnt, err := encode(ctx, cancel, -1)
+	testutil.Expect("encode all the frames", err == nil && cnt == FRAMES_COUNT)
+
+	reader, err := Theora.NewTheoraReader(bytes.NewReader(data))
+	testutil.Check(err)
+	defer reader.Close()
+	cnt, err = Theora.DecodeFrames(ctx, reader, Theora.FrameSinkFunc(
+		func(ctx context.Context, frame *Theora.TheoraFrame) error {
+			return nil
+		}))
+	testutil.Expect("decode all the frames", err == nil && cnt == FRAMES_COUNT)
+	testutil.Expect("the temporary pool is removed", reader.BufferPool() == nil)
+	return data
+}
+
+// testEncodeCancel cancels the encoding, the frames encoded before
+// the cancellation form a complete stream
+func testEncodeCancel() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	data, cnt, err := encode(ctx, cancel, CANCEL_AT)
+	testutil.Expect("cancelled encoding returns ctx.Err()", err == context.Canceled)
+	testutil.Expect("the encoding stops at the frame boundary", cnt == CANCEL_AT)
+
+	dec, err := testutil.ReadFrames(data, nil)
+	testutil.Expect("the cancelled stream is terminated", err == nil)
+	testutil.Expect("the cancelled stream has all the encoded frames", dec == CANCEL_AT)
+
+	data, cnt, err = encode(ctx, cancel, 0)
+	testutil.Expect("encoding with a done context returns ctx.Err()", err == context.Canceled)
+	testutil.Expect("nothing is encoded with a done context", cnt == 0 && len(data) == 0)
+
+	/* the context is done before the first picture */
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	data, cnt, err = encode(ctx, cancel, 0)
+	testutil.Expect("cancelled before the first frame returns ctx.Err()", err == context.Canceled && cnt == 0)
+	testutil.Expect("the stream without frames ends with the EOS page", lastPageEOS(data))
+	dec, err = testutil.ReadFrames(data, nil)
+	testutil.Expect("the stream without frames is decoded", err == nil && dec == 0)
+}
+
+// testEmptySource encodes a source without pictures
+func testEmptySource() {
+	cfg := Theora.NewEncoderConfig(WIDTH, HEIGHT)
+	var out bytes.Buffer
+	cnt, err := Theora.EncodeFrames(context.Background(), cfg, Theora.ImageSourceFunc(
+		func(ctx context.Context) (image.Image, error) {
+			return nil, io.EOF
+		}), &out)
+	testutil.Expect("empty source gives no frames", err == nil && cnt == 0)
+	testutil.Expect("empty source ends with the EOS page", lastPageEOS(out.Bytes()))
+}
+
+// testDecodeCancel cancels the decoding from the sink
+func testDecodeCancel(data []byte) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reader, err := Theora.NewTheoraReader(bytes.NewReader(data))
+	testutil.Check(err)
+	defer reader.Close()
+	cnt, err := Theora.DecodeFrames(ctx, reader, Theora.FrameSinkFunc(
+		func(ctx context.Context, frame *Theora.TheoraFrame) error {
+			if frame.Number == CANCEL_AT-1 {
+				cancel()
+			}
+			return nil
+		}))
+	testutil.Expect("cancelled decoding returns ctx.Err()", err == context.Canceled)
+	testutil.Expect("the decoding stops at the frame boundary", cnt == CANCEL_AT)
+
+	frame, err := reader.ReadFrame()
+	testutil.Expect("the reader continues after the cancellation", err == nil && frame.Number == CANCEL_AT)
+	frame.Release()
+}
+
+// testDeadline stops the decoding by the deadline
+func testDeadline(data []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	reader, err := Theora.NewTheoraReader(bytes.NewReader(data))
+	testutil.Check(err)
+	defer reader.Close()
+	cnt, err := Theora.DecodeFrames(ctx, reader, Theora.FrameSinkFunc(
+		func(ctx context.Context, frame *Theora.TheoraFrame) error {
+			<-ctx.Done()
+			return nil
+		}))
+	testutil.Expect("the deadline stops the decoding", errors.Is(err, context.DeadlineExceeded) && cnt == 1)
+}
+
+func main() {
+	data := testComplete()
+	testEncodeCancel()
+	testEmptySource()
+	testDecodeCancel(data)
+	testDeadline(data)
+
+	testutil.Exit()
+}
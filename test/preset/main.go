@@ -0,0 +1,107 @@
+/* GoTheora
+A test of the encoder presets: the configs made by
+NewEncoderConfigPreset are valid, the encoders made of them get the
+settings of the presets and the streams carry them in the headers
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math/bits"
+
+	"example.com/ilya2ik/gotheora/internal/testutil"
+	Theora "github.com/ilya2ik/gotheora"
+)
+
+const (
+	WIDTH        = 320
+	HEIGHT       = 240
+	FRAMES_COUNT = 10
+)
+
+// preset is the expected settings of a preset
+type preset struct {
+	preset Theora.Preset
+	fps    int
+	// Quality checked only in the constant quality mode
+	quality   int
+	bitrate   int
+	keyframes int
+	// -1 is the fastest level
+	speed int
+}
+
+var presets = []preset{
+	{Theora.PresetArchive, 30, 56, 0, 256, 0},
+	/* 0.1 bits per pixel, keyframes every 2 seconds */
+	{Theora.PresetWeb, 30, 0, WIDTH * HEIGHT * 30 / 10, 60, 1},
+	{Theora.PresetWeb, 25, 0, WIDTH * HEIGHT * 25 / 10, 50, 1},
+	/* keyframes every 10 seconds */
+	{Theora.PresetScreencast, 30, 48, 0, 300, 1},
+	/* 0.08 bits per pixel, keyframes every second */
+	{Theora.PresetLowLatency, 30, 0, WIDTH * HEIGHT * 30 * 8 / 100, 30, -1},
+}
+
+func testPreset(p preset) {
+	name := fmt.Sprintf("%v at %d fps", p.preset, p.fps)
+	cfg := Theora.NewEncoderConfigPreset(WIDTH, HEIGHT, p.fps, 1, p.preset)
+	testutil.Expect(name+": config is valid", cfg.Validate() == nil)
+	testutil.Expect(name+": config fields", cfg.Bitrate == p.bitrate && cfg.KeyframeFrequency == p.keyframes &&
+		(p.bitrate > 0 || cfg.Quality == p.quality))
+
+	data := testutil.Stream{
+		Config: cfg,
+		Frames: FRAMES_COUNT,
+		Frame: func(i int) image.Image {
+			return testutil.Noise(WIDTH, HEIGHT, i)
+		},
+		Setup: func(enc Theora.ITheoraEncoder) {
+			level, err := enc.GetSpeedLevel()
+			testutil.Check(err)
+			top, err := enc.GetSpeedLevelMax()
+			testutil.Check(err)
+			if p.speed < 0 {
+				testutil.Expect(name+": the fastest speed level", level == top)
+			} else {
+				testutil.Expect(name+": speed level", level == min(p.speed, top))
+			}
+			if p.bitrate == 0 {
+				testutil.Expect(name+": quality mode", enc.SetQuality(p.quality) == nil)
+			}
+		},
+	}.Encode()
+
+	reader, err := Theora.NewTheoraReader(bytes.NewReader(data))
+	testutil.Check(err)
+	defer reader.Close()
+	inf := reader.Info()
+	testutil.Expect(name+": header bitrate", inf.GetTargetBitrate() == p.bitrate)
+	testutil.Expect(name+": header quality", p.bitrate > 0 || inf.GetQuality() == p.quality)
+	testutil.Expect(name+": header keyframe granule shift", inf.GranuleShift() == bits.Len(uint(p.keyframes-1)))
+	testutil.Expect(name+": header frame rate", inf.GetFPSNumerator() == p.fps && inf.GetFPSDenominator() == 1)
+	frames, err := testutil.ReadFrames(data, nil)
+	testutil.Expect(name+": stream is decoded", err == nil && frames > 0)
+}
+
+func main() {
+	for _, p := range presets {
+		testPreset(p)
+	}
+
+	/* the fields can be overridden after the preset */
+	cfg := Theora.NewEncoderConfigPreset(WIDTH, HEIGHT, 30, 1, Theora.PresetWeb)
+	cfg.Bitrate = 0
+	cfg.Quality = 20
+	testutil.Expect("preset fields can be overridden", cfg.Validate() == nil)
+
+	testutil.Exit()
+}
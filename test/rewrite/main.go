@@ -0,0 +1,214 @@
+/* GoTheora
+A test of RewriteComment: the comment header is replaced, the video
+packets, the granule positions and the pages of the other streams
+are copied as they are, the pages are renumbered with valid CRCs
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+
+	"example.com/ilya2ik/gotheora/internal/testutil"
+	Theora "github.com/ilya2ik/gotheora"
+)
+
+const (
+	WIDTH        = 64
+	HEIGHT       = 48
+	FRAMES_COUNT = 20
+	SERIAL       = 1
+	// Serial number of the stream mixed into the theora stream
+	FOREIGN = 7
+)
+
+func encode() []byte {
+	cfg := Theora.NewEncoderConfig(WIDTH, HEIGHT)
+	cfg.SerialNo = SERIAL
+	return testutil.Stream{
+		Config: cfg,
+		Frames: FRAMES_COUNT,
+		Frame: func(i int) image.Image {
+			return testutil.Noise(WIDTH, HEIGHT, i)
+		},
+	}.Encode()
+}
+
+func parse(data []byte) []testutil.Page {
+	pages, err := testutil.ParsePages(data)
+	testutil.Check(err)
+	return pages
+}
+
+// mix puts the pages of another stream before the theora stream,
+// after each of its video pages and at the end. Returns the mixed
+// stream and the foreign pages
+func mix(data []byte) ([]byte, [][]byte) {
+	var res bytes.Buffer
+	var foreign [][]byte
+	seq := uint32(0)
+	add := func(headerType byte, packet string) {
+		page := testutil.NewPage(FOREIGN, seq, headerType, int64(seq), []byte(packet)).Bytes()
+		res.Write(page)
+		foreign = append(foreign, page)
+		seq++
+	}
+
+	add(testutil.PageBOS, "foreign head")
+	pages := parse(data)
+	headers := headerPages(pages)
+	for i, p := range pages {
+		res.Write(p.Bytes())
+		if i >= headers {
+			add(0, fmt.Sprintf("foreign %d", i))
+		}
+	}
+	add(testutil.PageEOS, "foreign tail")
+	return res.Bytes(), foreign
+}
+
+// headerPages returns the number of the pages holding the three
+// theora headers
+func headerPages(pages []testutil.Page) int {
+	packets := 0
+	for i, p := range pages {
+		if p.Serial != SERIAL {
+			continue
+		}
+		for _, l := range p.Lacing {
+			if l < 255 {
+				packets++
+			}
+		}
+		if packets >= 3 {
+			return i + 1
+		}
+	}
+	return len(pages)
+}
+
+// theoraPages returns the pages of the theora stream
+func theoraPages(pages []testutil.Page) []testutil.Page {
+	var res []testutil.Page
+	for _, p := range pages {
+		if p.Serial == SERIAL {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// comment reads the comment header of the stream
+func comment(data []byte) (vendor, title, artist string) {
+	reader, err := Theora.NewTheoraReader(bytes.NewReader(data))
+	testutil.Check(err)
+	defer reader.Close()
+	tc := reader.Comment()
+	return tc.GetVendor(), tc.Query("TITLE", 0), tc.Query("ARTIST", 0)
+}
+
+func rewrite(data []byte, vendor string) []byte {
+	tc, err := Theora.NewTheoraComment()
+	testutil.Check(err)
+	defer tc.Close()
+	tc.Init()
+	tc.AddTag("TITLE", "rewritten")
+	tc.AddTag("ARTIST", "test")
+	if len(vendor) > 0 {
+		tc.SetVendor(vendor)
+	}
+	var out bytes.Buffer
+	testutil.Check(Theora.RewriteComment(bytes.NewReader(data), &out, tc))
+	return out.Bytes()
+}
+
+func testPackets(orig, res []byte) {
+	want := testutil.Packets(parse(orig), SERIAL)
+	got := testutil.Packets(parse(res), SERIAL)
+	ok := len(got) == len(want) && len(want) > 3
+	for i := 0; ok && i < len(want); i++ {
+		if i == 1 {
+			/* the comment header */
+			ok = !bytes.Equal(got[i].Data, want[i].Data)
+			continue
+		}
+		ok = bytes.Equal(got[i].Data, want[i].Data) && got[i].Granule == want[i].Granule
+	}
+	testutil.Expect("the headers and the video packets are copied with their granule positions", ok)
+}
+
+func testPages(orig, res []byte) {
+	pages := parse(res)
+	ok := len(pages) > 0
+	for _, p := range pages {
+		ok = ok && p.ValidCRC()
+	}
+	testutil.Expect("the pages have valid CRCs", ok)
+
+	ok = true
+	for i, p := range theoraPages(pages) {
+		ok = ok && p.Sequence == uint32(i)
+	}
+	testutil.Expect("the theora pages are numbered without gaps", ok)
+
+	/* the video pages are the same but the sequence numbers */
+	want := theoraPages(parse(orig))
+	want = want[headerPages(want):]
+	got := theoraPages(pages)
+	got = got[headerPages(got):]
+	ok = len(got) == len(want) && len(want) > 0
+	for i := 0; ok && i < len(want); i++ {
+		ok = got[i].HeaderType == want[i].HeaderType && got[i].Granule == want[i].Granule &&
+			bytes.Equal(got[i].Lacing, want[i].Lacing) && bytes.Equal(got[i].Body, want[i].Body)
+	}
+	testutil.Expect("the video pages keep their layout", ok)
+	testutil.Expect("the stream ends with the EOS page", len(got) > 0 && got[len(got)-1].HeaderType&testutil.PageEOS != 0)
+}
+
+func main() {
+	data := encode()
+	vendor, _, _ := comment(data)
+	testutil.Expect("the original stream has a vendor", len(vendor) > 0)
+
+	res := rewrite(data, "")
+	v, title, artist := comment(res)
+	testutil.Expect("the comments are replaced", title == "rewritten" && artist == "test")
+	testutil.Expect("the vendor is kept", v == vendor)
+	testPackets(data, res)
+	testPages(data, res)
+	cnt, err := testutil.ReadFrames(res, nil)
+	testutil.Expect("the rewritten stream is decoded", err == nil && cnt == FRAMES_COUNT)
+
+	res = rewrite(data, "custom vendor")
+	v, _, _ = comment(res)
+	testutil.Expect("the vendor is replaced", v == "custom vendor")
+
+	/* the other stream is interleaved with the theora stream */
+	mixed, foreign := mix(data)
+	res = rewrite(mixed, "")
+	var got [][]byte
+	for _, p := range parse(res) {
+		if p.Serial == FOREIGN {
+			got = append(got, p.Bytes())
+		}
+	}
+	ok := len(got) == len(foreign)
+	for i := 0; ok && i < len(got); i++ {
+		ok = bytes.Equal(got[i], foreign[i])
+	}
+	testutil.Expect("the pages of the other stream are copied as they are", ok)
+	testPackets(data, res)
+	testPages(data, res)
+	_, title, _ = comment(res)
+	testutil.Expect("the comments of the mixed stream are replaced", title == "rewritten")
+
+	testutil.Exit()
+}
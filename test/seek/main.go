@@ -0,0 +1,165 @@
+/* GoTheora
+A test of seeking by time and by frame number: every frame reached by
+a seek must match the frame of the sequential decoding
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"math/rand"
+	"time"
+
+	"example.com/ilya2ik/gotheora/internal/testutil"
+	Theora "github.com/ilya2ik/gotheora"
+)
+
+const (
+	WIDTH        = 64
+	HEIGHT       = 48
+	FPS          = 10
+	FRAMES_COUNT = 40
+	// Maximal distance between the keyframes
+	KEYFRAMES = 16
+)
+
+var frame = testutil.Motion(WIDTH, HEIGHT, FRAMES_COUNT)
+
+func encode() []byte {
+	cfg := Theora.NewEncoderConfig(WIDTH, HEIGHT)
+	cfg.FPSNumerator, cfg.FPSDenominator = FPS, 1
+	cfg.KeyframeFrequency = KEYFRAMES
+	cfg.KeyframeAuto = false
+	cfg.SerialNo = 1
+	return testutil.Stream{Config: cfg, Frames: FRAMES_COUNT, Frame: frame}.Encode()
+}
+
+// decoded is a frame of the sequential decoding
+type decoded struct {
+	number, granulepos int64
+	keyframe           bool
+	pic                *image.YCbCr
+}
+
+func decodedOf(reader Theora.ITheoraReader, frame *Theora.TheoraFrame) decoded {
+	return decoded{
+		number:     frame.Number,
+		granulepos: frame.GranulePos,
+		keyframe:   frame.KeyFrame,
+		pic:        frame.Buffer.ToYCbCr(reader.Info()),
+	}
+}
+
+func readFrame(reader Theora.ITheoraReader) (decoded, error) {
+	frame, err := reader.ReadFrame()
+	if err != nil {
+		return decoded{}, err
+	}
+	defer frame.Release()
+	return decodedOf(reader, frame), nil
+}
+
+func same(a, b decoded) bool {
+	return a.number == b.number && a.granulepos == b.granulepos &&
+		a.keyframe == b.keyframe && a.pic != nil && b.pic != nil &&
+		bytes.Equal(a.pic.Y, b.pic.Y) && bytes.Equal(a.pic.Cb, b.pic.Cb) &&
+		bytes.Equal(a.pic.Cr, b.pic.Cr)
+}
+
+func sequential(data []byte) []decoded {
+	var res []decoded
+	_, err := testutil.ReadFrames(data, func(reader Theora.ITheoraReader, frame *Theora.TheoraFrame) {
+		res = append(res, decodedOf(reader, frame))
+	})
+	testutil.Check(err)
+	return res
+}
+
+func main() {
+	data := encode()
+	frames := sequential(data)
+	testutil.Expect("all the frames decoded", len(frames) == FRAMES_COUNT)
+	ok := len(frames) == FRAMES_COUNT
+	for i := 0; ok && i < FRAMES_COUNT; i++ {
+		ok = frames[i].number == int64(i) && frames[i].keyframe == (i%KEYFRAMES == 0)
+	}
+	testutil.Expect("keyframes every 16 frames", ok)
+	if !ok {
+		testutil.Exit()
+	}
+
+	reader, err := Theora.NewTheoraReader(bytes.NewReader(data))
+	testutil.Check(err)
+	defer reader.Close()
+
+	/* every frame in a mixed order: the keyframes, the frames right
+	   after and right before them, backwards and forwards */
+	ok = true
+	for _, i := range rand.New(rand.NewSource(2)).Perm(FRAMES_COUNT) {
+		if reader.SeekFrame(int64(i)) != nil {
+			ok = false
+			continue
+		}
+		f, err := readFrame(reader)
+		ok = ok && err == nil && same(f, frames[i])
+		/* the decoding goes on after the seek */
+		if i+1 < FRAMES_COUNT {
+			f, err = readFrame(reader)
+			ok = ok && err == nil && same(f, frames[i+1])
+		}
+	}
+	testutil.Expect("SeekFrame to every frame", ok)
+
+	for _, i := range []int{0, 16, 17, 31, 39} {
+		name := fmt.Sprintf("SeekFrame to frame %d", i)
+		if frames[i].keyframe {
+			name += " (keyframe)"
+		}
+		testutil.Check(reader.SeekFrame(int64(i)))
+		f, err := readFrame(reader)
+		testutil.Expect(name, err == nil && same(f, frames[i]))
+	}
+
+	for _, i := range []int{0, 16, 23, 39} {
+		/* the frame shown at the time, also in the middle of its
+		   display interval */
+		t := time.Duration(i) * time.Second / FPS
+		testutil.Check(reader.Seek(t))
+		f, err := readFrame(reader)
+		ok := err == nil && same(f, frames[i])
+		testutil.Check(reader.Seek(t + time.Second/FPS/2))
+		f, err = readFrame(reader)
+		ok = ok && err == nil && same(f, frames[i])
+		testutil.Expect(fmt.Sprintf("Seek to %v", t), ok)
+	}
+
+	testutil.Check(reader.Seek(-time.Second))
+	f, err := readFrame(reader)
+	testutil.Expect("Seek before the start gives frame 0", err == nil && same(f, frames[0]))
+
+	for _, i := range []int64{FRAMES_COUNT, FRAMES_COUNT + 10} {
+		err = reader.SeekFrame(i)
+		if err == nil {
+			_, err = readFrame(reader)
+		}
+		testutil.Expect(fmt.Sprintf("SeekFrame past the end to %d", i), err == io.EOF)
+	}
+
+	/* the reader can seek back after the end */
+	testutil.Check(reader.SeekFrame(5))
+	f, err = readFrame(reader)
+	testutil.Expect("SeekFrame back after the end", err == nil && same(f, frames[5]))
+
+	testutil.Expect("SeekFrame to a negative frame is refused", reader.SeekFrame(-1) != nil)
+
+	testutil.Exit()
+}
@@ -0,0 +1,87 @@
+/* GoTheora
+A test of the reproducible encoder output: the same input encoded
+twice with the same serial number gives the same bytes, with and
+without the skeleton track
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+
+	"example.com/ilya2ik/gotheora/internal/testutil"
+	Theora "github.com/ilya2ik/gotheora"
+)
+
+const (
+	WIDTH        = 64
+	HEIGHT       = 48
+	FRAMES_COUNT = 20
+	SERIAL       = 42
+)
+
+// stream is the test stream. The serial number is random if serial is
+// 0, the skeleton track is added if skeleton is set
+func stream(serial int32, skeleton bool) testutil.Stream {
+	cfg := Theora.NewEncoderConfig(WIDTH, HEIGHT)
+	cfg.SerialNo = serial
+	return testutil.Stream{
+		Config: cfg,
+		Frames: FRAMES_COUNT,
+		Frame: func(i int) image.Image {
+			return testutil.Noise(WIDTH, HEIGHT, i)
+		},
+		Setup: func(enc Theora.ITheoraEncoder) {
+			if serial != 0 {
+				testutil.Expect(fmt.Sprintf("serial number %d is set", serial), enc.SerialNo() == serial)
+			}
+			if skeleton {
+				testutil.Check(enc.EnableSkeleton(16))
+			}
+		},
+	}
+}
+
+// firstSerial returns the serial number of the first ogg page
+func firstSerial(data []byte) int32 {
+	if len(data) < 18 || !bytes.HasPrefix(data, []byte("OggS")) {
+		return 0
+	}
+	return int32(binary.LittleEndian.Uint32(data[14:]))
+}
+
+func main() {
+	a, b := stream(SERIAL, false).Encode(), stream(SERIAL, false).Encode()
+	testutil.Expect("same serial gives the same output", len(a) > 0 && bytes.Equal(a, b))
+	testutil.Expect("pages carry the serial number", firstSerial(a) == SERIAL)
+
+	c := stream(SERIAL+1, false).Encode()
+	testutil.Expect("other serial gives other output", !bytes.Equal(a, c))
+
+	a, b = stream(SERIAL, true).Encode(), stream(SERIAL, true).Encode()
+	testutil.Expect("same serial gives the same output with the skeleton", len(a) > 0 && bytes.Equal(a, b))
+
+	a, b = stream(SERIAL, true).EncodeFile(), stream(SERIAL, true).EncodeFile()
+	testutil.Expect("same serial gives the same output with the skeleton index", len(a) > 0 && bytes.Equal(a, b))
+
+	a, b = stream(0, false).Encode(), stream(0, false).Encode()
+	testutil.Expect("random serial numbers differ", firstSerial(a) != firstSerial(b))
+
+	enc, err := Theora.NewTheoraEncoderConfig(Theora.NewEncoderConfig(WIDTH, HEIGHT), io.Discard)
+	testutil.Check(err)
+	testutil.Check(enc.SaveDefHeadersToStream())
+	testutil.Expect("serial number is fixed after the headers", enc.SetSerialNo(SERIAL) != nil)
+	enc.Close()
+
+	testutil.Exit()
+}
@@ -0,0 +1,148 @@
+/* GoTheora
+A test of the Ogg Skeleton keyframe index: the seeks of a stream with
+the index go straight to the indexed keyframe, the index of a stream
+of a different length is ignored
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"example.com/ilya2ik/gotheora/internal/testutil"
+	Theora "github.com/ilya2ik/gotheora"
+)
+
+const (
+	WIDTH        = 64
+	HEIGHT       = 48
+	FPS          = 10
+	FRAMES_COUNT = 40
+	// Maximal distance between the keyframes
+	KEYFRAMES = 16
+)
+
+var frame = testutil.Motion(WIDTH, HEIGHT, FRAMES_COUNT)
+
+// stream is the stream with a skeleton track. The index is written
+// only if the output is an io.WriteSeeker
+func stream(keypoints int) testutil.Stream {
+	cfg := Theora.NewEncoderConfig(WIDTH, HEIGHT)
+	cfg.FPSNumerator, cfg.FPSDenominator = FPS, 1
+	cfg.KeyframeFrequency = KEYFRAMES
+	cfg.KeyframeAuto = false
+	cfg.SerialNo = 1
+	return testutil.Stream{
+		Config: cfg,
+		Frames: FRAMES_COUNT,
+		Frame:  frame,
+		Setup: func(enc Theora.ITheoraEncoder) {
+			testutil.Check(enc.EnableSkeleton(keypoints))
+		},
+	}
+}
+
+// countingSeeker counts the Seek calls of the reader
+type countingSeeker struct {
+	io.ReadSeeker
+	seeks int
+}
+
+func (s *countingSeeker) Seek(offset int64, whence int) (int64, error) {
+	s.seeks++
+	return s.ReadSeeker.Seek(offset, whence)
+}
+
+// sum returns a digest of the visible picture of the frame
+func sum(reader Theora.ITheoraReader, frame *Theora.TheoraFrame) uint64 {
+	pic := frame.Buffer.ToYCbCr(reader.Info())
+	var res uint64
+	for _, plane := range [][]byte{pic.Y, pic.Cb, pic.Cr} {
+		for _, b := range plane {
+			res = res*31 + uint64(b)
+		}
+	}
+	return res
+}
+
+// checksum reads the next frame and returns its number and digest
+func checksum(reader Theora.ITheoraReader) (int64, uint64, error) {
+	frame, err := reader.ReadFrame()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer frame.Release()
+	return frame.Number, sum(reader, frame), nil
+}
+
+// sequential returns the checksums of all the frames
+func sequential(data []byte) []uint64 {
+	var res []uint64
+	_, err := testutil.ReadFrames(data, func(reader Theora.ITheoraReader, frame *Theora.TheoraFrame) {
+		res = append(res, sum(reader, frame))
+	})
+	testutil.Check(err)
+	return res
+}
+
+// testSeeks seeks to the frames and compares them with the sequential
+// decoding. Returns the maximal count of Seek calls of one seek
+func testSeeks(name string, data []byte, want []uint64) int {
+	src := &countingSeeker{ReadSeeker: bytes.NewReader(data)}
+	reader, err := Theora.NewTheoraReader(src)
+	testutil.Check(err)
+	defer reader.Close()
+	seeks := 0
+	ok := true
+	for _, i := range []int64{20, 16, 35, 3, 0, 39} {
+		src.seeks = 0
+		if reader.SeekFrame(i) != nil {
+			ok = false
+			continue
+		}
+		seeks = max(seeks, src.seeks)
+		n, sum, err := checksum(reader)
+		ok = ok && err == nil && n == i && sum == want[i]
+	}
+	testutil.Expect(name+": seeks give the frames of the sequential decoding", ok)
+	return seeks
+}
+
+func main() {
+	indexed := stream(64).EncodeFile()
+	want := sequential(indexed)
+	testutil.Expect("all the frames decoded", len(want) == FRAMES_COUNT)
+	if len(want) != FRAMES_COUNT {
+		testutil.Exit()
+	}
+
+	/* the index gives the page of the keyframe at once: the reader
+	   seeks to the end for the stream size and to the keyframe */
+	seeks := testSeeks("indexed", indexed, want)
+	testutil.Expect("indexed: one seek to the keyframe", seeks == 2)
+
+	/* the segment length of the fishead does not match, the index is
+	   not trusted and the pages are bisected */
+	longer := append(bytes.Clone(indexed), make([]byte, 4096)...)
+	testutil.Expect("longer: trailing bytes are ignored by the decoding", fmt.Sprint(sequential(longer)) == fmt.Sprint(want))
+	seeks = testSeeks("longer", longer, want)
+	testutil.Expect("longer: the index is ignored", seeks > 2)
+
+	/* the index of one keypoint. The other keyframes are dropped */
+	thin := stream(1).EncodeFile()
+	testSeeks("one keypoint", thin, want)
+
+	/* the output is not seekable, the index can not be written */
+	seeks = testSeeks("no index", stream(64).Encode(), want)
+	testutil.Expect("no index: the pages are bisected", seeks > 2)
+
+	testutil.Exit()
+}
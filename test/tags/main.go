@@ -0,0 +1,92 @@
+/* GoTheora
+A test of the comment checks: Add and AddTag drop the comments
+breaking the Vorbis comment rules, AddChecked and AddTagChecked
+report them
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"example.com/ilya2ik/gotheora/internal/testutil"
+	Theora "github.com/ilya2ik/gotheora"
+)
+
+func newComment() Theora.ITheoraComment {
+	tc, err := Theora.NewTheoraComment()
+	testutil.Check(err)
+	tc.Init()
+	return tc
+}
+
+func comments(tc Theora.ITheoraComment) []string {
+	var res []string
+	for i := 0; i < tc.TagsCount(); i++ {
+		res = append(res, tc.GetTag(i))
+	}
+	return res
+}
+
+func isInvalid(err error) bool {
+	return errors.Is(err, Theora.ETheoraInvalidCommentException)
+}
+
+// invalid is the field names and the values breaking the rules
+var invalid = []struct {
+	name, tag, value string
+}{
+	{"empty field name", "", "value"},
+	{"'=' in the field name", "A=B", "value"},
+	{"control character in the field name", "A\tB", "value"},
+	{"non-ASCII field name", "TITLÉ", "value"},
+	{"value not UTF-8", "TITLE", "\xff\xfe"},
+	{"NUL in the value", "TITLE", "a\x00b"},
+}
+
+func testAddTag() {
+	for _, c := range invalid {
+		tc := newComment()
+		tc.AddTag(c.tag, c.value)
+		testutil.Expect(fmt.Sprintf("AddTag drops the %s", c.name), tc.TagsCount() == 0)
+		testutil.Expect(fmt.Sprintf("AddTagChecked reports the %s", c.name), isInvalid(tc.AddTagChecked(c.tag, c.value)) && tc.TagsCount() == 0)
+		tc.Close()
+	}
+
+	tc := newComment()
+	defer tc.Close()
+	tc.AddTag("TITLE", "Тест")
+	testutil.Check(tc.AddTagChecked("artist", "Ilya"))
+	testutil.Expect("valid comments are added", reflect.DeepEqual(comments(tc), []string{"TITLE=Тест", "artist=Ilya"}))
+}
+
+func testAdd() {
+	tc := newComment()
+	defer tc.Close()
+	tc.Add("no separator")
+	tc.Add("=no field name")
+	tc.Add("TITLE=\xff")
+	testutil.Expect("Add drops the invalid comments", tc.TagsCount() == 0)
+	testutil.Expect("AddChecked reports a comment without '='", isInvalid(tc.AddChecked("no separator")))
+	testutil.Expect("AddChecked reports a value not UTF-8", isInvalid(tc.AddChecked("TITLE=\xff")))
+
+	tc.Add("TITLE=a=b")
+	testutil.Check(tc.AddChecked("EMPTY="))
+	testutil.Expect("the value is split at the first '='", reflect.DeepEqual(comments(tc), []string{"TITLE=a=b", "EMPTY="}))
+	testutil.Expect("Query finds the added comment", tc.Query("title", 0) == "a=b")
+}
+
+func main() {
+	testAddTag()
+	testAdd()
+
+	testutil.Exit()
+}
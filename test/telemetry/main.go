@@ -0,0 +1,124 @@
+/* GoTheora
+A test of the telemetry parser on the frames encoded with the known
+quality and motion: a keyframe, a repeated frame and a shifted frame
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"image"
+	"io"
+
+	"example.com/ilya2ik/gotheora/internal/testutil"
+	Theora "github.com/ilya2ik/gotheora"
+)
+
+const (
+	WIDTH   = 128
+	HEIGHT  = 96
+	QUALITY = 40
+	// Shift of the picture to the right in the third frame, pixels
+	SHIFT = 2
+)
+
+// motion returns the picture moved to the right by shift pixels
+var motion = testutil.Motion(WIDTH, HEIGHT, SHIFT)
+
+// encode encodes the picture, the same picture again and the picture
+// moved by SHIFT
+func encode() []byte {
+	cfg := Theora.NewEncoderConfig(WIDTH, HEIGHT)
+	cfg.Quality = QUALITY
+	cfg.SerialNo = 1
+	shifts := []int{0, 0, SHIFT}
+	return testutil.Stream{
+		Config: cfg,
+		Frames: len(shifts),
+		Frame:  func(i int) image.Image { return motion(shifts[i]) },
+	}.Encode()
+}
+
+func readTelemetry(data []byte) []*Theora.TheoraTelemetry {
+	reader, err := Theora.NewTheoraReader(bytes.NewReader(data))
+	testutil.Check(err)
+	defer reader.Close()
+	var res []*Theora.TheoraTelemetry
+	for {
+		frame, tm, err := reader.ReadFrameTelemetry()
+		if err == io.EOF {
+			return res
+		}
+		testutil.Check(err)
+		frame.Release()
+		res = append(res, tm)
+	}
+}
+
+func hasQI(tm *Theora.TheoraTelemetry, qi int) bool {
+	for _, v := range tm.QIs {
+		if v == qi {
+			return true
+		}
+	}
+	return false
+}
+
+func testKeyframe(tm *Theora.TheoraTelemetry) {
+	testutil.Expect("keyframe is a keyframe", tm.KeyFrame)
+	testutil.Expect("keyframe qi is the quality", len(tm.QIs) > 0 && tm.QIs[0] == QUALITY)
+	testutil.Expect("keyframe size in macroblocks", tm.MBWidth == WIDTH/16 && tm.MBHeight == HEIGHT/16 &&
+		len(tm.MBs) == tm.MBWidth*tm.MBHeight)
+	ok := true
+	for _, mb := range tm.MBs {
+		ok = ok && mb.Mode == Theora.ModeIntra && mb.Coded && hasQI(tm, mb.QI) &&
+			mb.MV == [4]Theora.MotionVector{}
+	}
+	testutil.Expect("keyframe macroblocks are intra coded without motion", ok)
+}
+
+func testRepeated(tm *Theora.TheoraTelemetry) {
+	testutil.Expect("repeated frame is an inter frame", !tm.KeyFrame)
+	testutil.Expect("repeated frame qi is the quality", len(tm.QIs) > 0 && tm.QIs[0] == QUALITY)
+	ok := true
+	for _, mb := range tm.MBs {
+		ok = ok && mb.Mode != Theora.ModeIntra && mb.MV == [4]Theora.MotionVector{}
+	}
+	testutil.Expect("repeated frame macroblocks have no motion", ok)
+}
+
+func testShifted(tm *Theora.TheoraTelemetry) {
+	testutil.Expect("shifted frame is an inter frame", !tm.KeyFrame)
+	/* the content moves to the right, so the blocks are predicted
+	   from the left: -SHIFT pixels is -2*SHIFT in half-pixel units */
+	want := Theora.MotionVector{X: -2 * SHIFT, Y: 0}
+	moved, inner := 0, 0
+	for y := 1; y < tm.MBHeight-1; y++ {
+		for x := 1; x < tm.MBWidth-1; x++ {
+			mb := tm.MB(x, y)
+			inner++
+			if mb.Coded && mb.Mode != Theora.ModeIntra && mb.MV[0] == want {
+				moved++
+			}
+		}
+	}
+	testutil.Expect("shifted frame macroblocks follow the motion", moved*2 > inner)
+}
+
+func main() {
+	tms := readTelemetry(encode())
+	testutil.Expect("three frames with the telemetry", len(tms) == 3 && tms[0] != nil && tms[1] != nil && tms[2] != nil)
+	if len(tms) == 3 && tms[0] != nil && tms[1] != nil && tms[2] != nil {
+		testKeyframe(tms[0])
+		testRepeated(tms[1])
+		testShifted(tms[2])
+	}
+
+	testutil.Exit()
+}
@@ -0,0 +1,304 @@
+/* GoTheora
+A test of the th_* API: the encoder and the decoder contexts, the
+header packets, the setup info and the legacy API working over them
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+
+	"example.com/ilya2ik/gotheora/internal/testutil"
+	OGG "github.com/ilya2ik/googg"
+	Theora "github.com/ilya2ik/gotheora"
+)
+
+const (
+	FRAME_WIDTH  = 64
+	FRAME_HEIGHT = 48
+	PIC_X        = 2
+	PIC_Y        = 2
+	PIC_WIDTH    = 60
+	PIC_HEIGHT   = 44
+	FRAMES_COUNT = 6
+)
+
+// foreignSetup and foreignBuffer are the implementations of the
+// interfaces which are not the types of the package
+type foreignSetup struct{ Theora.IThSetupInfo }
+
+type foreignBuffer struct{ Theora.IThYCbCrBuffer }
+
+func isFault(err error) bool {
+	return errors.Is(err, &Theora.Error{Code: Theora.CodeFault})
+}
+
+// fillFrame draws a gradient moving to the right with the frame
+func fillFrame(buf Theora.IThYCbCrBuffer, i int) {
+	for y := 0; y < buf.GetHeight(0); y++ {
+		row := buf.Row(0, y)
+		for x := range row {
+			row[x] = uint8(16 + x*2 + y + i*2)
+		}
+	}
+	for p := 1; p < 3; p++ {
+		for y := 0; y < buf.GetHeight(p); y++ {
+			row := buf.Row(p, y)
+			for x := range row {
+				row[x] = uint8(96 + p*32)
+			}
+		}
+	}
+}
+
+// lumaError returns the mean absolute difference of the luma of the
+// visible picture
+func lumaError(src, dst Theora.IThYCbCrBuffer) float64 {
+	sum := 0
+	for y := PIC_Y; y < PIC_Y+PIC_HEIGHT; y++ {
+		a, b := src.Row(0, y), dst.Row(0, y)
+		for x := PIC_X; x < PIC_X+PIC_WIDTH; x++ {
+			d := int(a[x]) - int(b[x])
+			if d < 0 {
+				d = -d
+			}
+			sum += d
+		}
+	}
+	return float64(sum) / float64(PIC_WIDTH*PIC_HEIGHT)
+}
+
+func newThInfo() Theora.IThInfo {
+	inf, err := Theora.NewThInfo()
+	testutil.Check(err)
+	inf.Init()
+	inf.SetFrameWidth(FRAME_WIDTH)
+	inf.SetFrameHeight(FRAME_HEIGHT)
+	inf.SetPicWidth(PIC_WIDTH)
+	inf.SetPicHeight(PIC_HEIGHT)
+	inf.SetPicX(PIC_X)
+	inf.SetPicY(PIC_Y)
+	inf.SetFPSNumerator(25)
+	inf.SetFPSDenominator(1)
+	inf.SetAspectNumerator(1)
+	inf.SetAspectDenominator(1)
+	inf.SetPixelFormat(image.YCbCrSubsampleRatio420)
+	inf.SetQuality(48)
+	inf.SetKeyframeGranuleShift(6)
+	return inf
+}
+
+// testTh encodes the frames with the th_* encoder and decodes every
+// packet at once with the th_* decoder and with the legacy decoder
+func testTh() {
+	inf := newThInfo()
+	defer inf.Close()
+	enc, err := Theora.NewThEncoder(inf)
+	testutil.Check(err)
+	defer enc.Close()
+
+	tc, err := Theora.NewThComment()
+	testutil.Check(err)
+	defer tc.Close()
+	tc.Init()
+	tc.AddTag("TITLE", "th test")
+
+	dinf, err := Theora.NewThInfo()
+	testutil.Check(err)
+	defer dinf.Close()
+	dinf.Init()
+	dtc, err := Theora.NewThComment()
+	testutil.Check(err)
+	defer dtc.Close()
+	dtc.Init()
+	setup, err := Theora.NewThSetupInfo()
+	testutil.Check(err)
+	defer setup.Close()
+
+	linf, err := Theora.NewTheoraInfo()
+	testutil.Check(err)
+	defer linf.Close()
+	linf.Init()
+	ltc, err := Theora.NewTheoraComment()
+	testutil.Check(err)
+	defer ltc.Close()
+	ltc.Init()
+	ldec, err := Theora.NewTheoraDecoder(linf)
+	testutil.Check(err)
+	defer ldec.Close()
+
+	op, err := OGG.NewPacket()
+	testutil.Check(err)
+	defer op.Done()
+
+	/* the headers */
+	headers := 0
+	for {
+		ok, err := enc.FlushHeader(tc, op)
+		testutil.Check(err)
+		if !ok {
+			break
+		}
+		headers++
+		testutil.Expect(fmt.Sprintf("header %d is a header packet", headers), Theora.ThPacketIsHeader(op))
+		if headers == 1 {
+			_, err = Theora.ThDecodeHeaderIn(dinf, dtc, foreignSetup{setup}, op)
+			testutil.Expect("foreign setup info gives TH_EFAULT", isFault(err))
+		}
+		ok, err = Theora.ThDecodeHeaderIn(dinf, dtc, setup, op)
+		testutil.Expect(fmt.Sprintf("header %d is decoded", headers), err == nil && ok)
+		testutil.Expect(fmt.Sprintf("legacy header %d is decoded", headers), ldec.Header(ltc, op) == nil)
+	}
+	testutil.Expect("three header packets", headers == 3)
+	testutil.Expect("picture size is decoded", dinf.GetPicWidth() == PIC_WIDTH && dinf.GetPicHeight() == PIC_HEIGHT &&
+		dinf.GetFrameWidth() == FRAME_WIDTH && dinf.GetFrameHeight() == FRAME_HEIGHT)
+	testutil.Expect("picture offset is decoded", dinf.GetPicX() == PIC_X && dinf.GetPicY() == PIC_Y)
+	testutil.Expect("frame rate is decoded", dinf.GetFPSNumerator() == 25 && dinf.GetFPSDenominator() == 1)
+	testutil.Expect("granule shift is decoded", dinf.GetKeyframeGranuleShift() == 6)
+	testutil.Expect("comment is decoded", dtc.Query("TITLE", 0) == "th test" && len(dtc.GetVendor()) > 0)
+	testutil.Expect("legacy decoder is ready", ldec.IsReady())
+	testutil.Expect("legacy info is filled", linf.GetFrameWidth() == PIC_WIDTH && linf.GetWidth() == FRAME_WIDTH &&
+		linf.GetOffsetX() == PIC_X && linf.GetKeyframeFrequencyForce() == 64)
+	testutil.Expect("legacy comment is filled", ltc.Query("TITLE", 0) == "th test" && ltc.GetVendor() == dtc.GetVendor())
+
+	dec, err := Theora.NewThDecoder(dinf, setup)
+	testutil.Check(err)
+	defer dec.Close()
+
+	/* the frames */
+	src, err := Theora.NewThYCbCrBuffer()
+	testutil.Check(err)
+	defer src.Close()
+	testutil.Check(src.Alloc(FRAME_WIDTH, FRAME_HEIGHT, image.YCbCrSubsampleRatio420))
+	out, err := Theora.NewThYCbCrBuffer()
+	testutil.Check(err)
+	defer out.Close()
+	yuv, err := Theora.NewTheoraYUVbuffer()
+	testutil.Check(err)
+	defer yuv.Close()
+
+	for i := 0; i < FRAMES_COUNT; i++ {
+		fillFrame(src, i)
+		testutil.Check(enc.YCbCrIn(src))
+		testutil.Check(enc.PacketOut(i == FRAMES_COUNT-1, op))
+		if i == 0 {
+			testutil.Expect("first packet is a keyframe", Theora.ThPacketIsKeyframe(op))
+		}
+		if i == 1 {
+			testutil.Expect("second packet is not a keyframe", !Theora.ThPacketIsKeyframe(op))
+		}
+
+		gp, err := dec.PacketIn(op)
+		testutil.Check(err)
+		testutil.Expect(fmt.Sprintf("frame %d granule position", i), dec.GranuleFrame(gp) == int64(i))
+		if i == 0 {
+			testutil.Expect("foreign buffer gives TH_EFAULT", isFault(dec.YCbCrOut(foreignBuffer{out})))
+		}
+		testutil.Check(dec.YCbCrOut(out))
+		testutil.Expect(fmt.Sprintf("frame %d plane sizes", i), out.GetWidth(0) == FRAME_WIDTH &&
+			out.GetHeight(0) == FRAME_HEIGHT && out.GetWidth(1) == FRAME_WIDTH/2)
+		testutil.Expect(fmt.Sprintf("frame %d luma", i), lumaError(src, out) < 4)
+
+		testutil.Check(ldec.PacketIn(op))
+		testutil.Check(ldec.YUVout(yuv))
+		state := ldec.State()
+		testutil.Expect(fmt.Sprintf("legacy frame %d granule position", i),
+			state.GetGranulePos() == gp && state.GranuleFrame(gp) == int64(i))
+		testutil.Expect(fmt.Sprintf("legacy frame %d plane sizes", i), yuv.GetYWidth() == FRAME_WIDTH &&
+			yuv.GetYHeight() == FRAME_HEIGHT && yuv.GetUVWidth() == FRAME_WIDTH/2)
+	}
+	testutil.Expect("no packet after the last one", enc.PacketOut(false, op) != nil)
+
+	/* the setup is kept by the legacy info */
+	again, err := Theora.NewTheoraDecoder(linf)
+	testutil.Check(err)
+	testutil.Expect("decoder over the decoded info is ready", again.IsReady())
+	testutil.Check(again.Close())
+}
+
+// testLegacy encodes the frames with the legacy encoder and decodes
+// them with the th_* decoder
+func testLegacy() {
+	cfg := Theora.NewEncoderConfig(PIC_WIDTH, PIC_HEIGHT)
+	cfg.SerialNo = 1
+	inf, err := cfg.NewTheoraInfo()
+	testutil.Check(err)
+	defer inf.Close()
+	enc, err := Theora.NewTheoraEncoder(inf, io.Discard)
+	testutil.Check(err)
+	defer enc.Close()
+
+	dinf, err := Theora.NewThInfo()
+	testutil.Check(err)
+	defer dinf.Close()
+	dinf.Init()
+	dtc, err := Theora.NewThComment()
+	testutil.Check(err)
+	defer dtc.Close()
+	dtc.Init()
+	setup, err := Theora.NewThSetupInfo()
+	testutil.Check(err)
+	defer setup.Close()
+
+	op, err := OGG.NewPacket()
+	testutil.Check(err)
+	defer op.Done()
+
+	testutil.Expect("tables before the other headers are refused", enc.Tables(op) != nil)
+	testutil.Check(enc.Header(op))
+	ok, err := Theora.ThDecodeHeaderIn(dinf, dtc, setup, op)
+	testutil.Expect("legacy info header is decoded", err == nil && ok)
+	testutil.Expect("legacy info header sizes", dinf.GetPicWidth() == PIC_WIDTH &&
+		dinf.GetFrameWidth() == inf.GetWidth() && dinf.GetFrameHeight() == inf.GetHeight())
+
+	tc, err := Theora.NewTheoraComment()
+	testutil.Check(err)
+	defer tc.Close()
+	tc.Init()
+	tc.AddTag("ARTIST", "legacy")
+	testutil.Check(enc.Comment(tc, op))
+	ok, err = Theora.ThDecodeHeaderIn(dinf, dtc, setup, op)
+	testutil.Expect("legacy comment header is decoded", err == nil && ok && dtc.Query("ARTIST", 0) == "legacy")
+
+	testutil.Check(enc.Tables(op))
+	ok, err = Theora.ThDecodeHeaderIn(dinf, dtc, setup, op)
+	testutil.Expect("legacy setup header is decoded", err == nil && ok)
+	testutil.Expect("no more header packets", enc.Header(op) != nil)
+
+	dec, err := Theora.NewThDecoder(dinf, setup)
+	testutil.Check(err)
+	defer dec.Close()
+
+	buf, err := Theora.NewTheoraYUVbuffer()
+	testutil.Check(err)
+	defer buf.Close()
+	for i := 0; i < FRAMES_COUNT; i++ {
+		testutil.Expect(fmt.Sprintf("legacy convert %d", i), buf.ConvertFromRasterImageInfo(inf, testutil.Gradient(PIC_WIDTH, PIC_HEIGHT, i)))
+		testutil.Check(enc.YUVin(buf))
+		testutil.Check(enc.PacketOut(i == FRAMES_COUNT-1, op))
+		state := enc.(*Theora.TheoraEncoder).State()
+		testutil.Expect(fmt.Sprintf("legacy encoder frame %d", i), state.GranuleFrame(state.GetGranulePos()) == int64(i))
+		gp, err := dec.PacketIn(op)
+		testutil.Check(err)
+		testutil.Expect(fmt.Sprintf("legacy packet %d is decoded", i), dec.GranuleFrame(gp) == int64(i))
+	}
+	err = enc.PacketOut(false, op)
+	testutil.Expect("legacy encoder has completed", errors.Is(err, Theora.ETheoraEncCompletedException))
+}
+
+func main() {
+	testTh()
+	testLegacy()
+	testutil.Expect("no live C allocations", len(Theora.LiveAllocations()) == 0)
+
+	testutil.Exit()
+}
@@ -0,0 +1,230 @@
+/* GoTheora
+A test of the two-pass encoding: the bitrate and the file size
+targets and the metrics collected into a file and into memory
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"math/rand"
+	"os"
+
+	"example.com/ilya2ik/gotheora/internal/testutil"
+	Theora "github.com/ilya2ik/gotheora"
+)
+
+const (
+	WIDTH        = 160
+	HEIGHT       = 120
+	FPS          = 25
+	FRAMES_COUNT = 50
+)
+
+// texture is a gradient with a noise pattern. The frames move it to
+// the right by one pixel
+var texture = func() []uint8 {
+	res := make([]uint8, (WIDTH+FRAMES_COUNT)*HEIGHT)
+	rnd := rand.New(rand.NewSource(1))
+	for i := range res {
+		res[i] = uint8(rnd.Intn(32))
+	}
+	return res
+}()
+
+func frame(i int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, WIDTH, HEIGHT))
+	for y := 0; y < HEIGHT; y++ {
+		for x := 0; x < WIDTH; x++ {
+			t := texture[y*(WIDTH+FRAMES_COUNT)+x+FRAMES_COUNT-i]
+			img.Set(x, y, color.RGBA{uint8(x) + t, uint8(y*2) + t, 128 + t, 255})
+		}
+	}
+	return img
+}
+
+// frameSource converts the frames into two buffers in turn, the
+// current frame and the next one. The source fails at the frame
+// failAt if it is positive
+type frameSource struct {
+	inf    Theora.ITheoraInfo
+	next   int
+	failAt int
+	bufs   [2]Theora.ITheoraYUVbuffer
+}
+
+var errSource = errors.New("source failed")
+
+func (s *frameSource) Rewind() error {
+	s.next = 0
+	return nil
+}
+
+func (s *frameSource) NextFrame() (Theora.ITheoraYUVbuffer, error) {
+	if s.next >= FRAMES_COUNT {
+		return nil, io.EOF
+	}
+	if s.failAt > 0 && s.next == s.failAt {
+		return nil, errSource
+	}
+	buf := s.bufs[s.next%2]
+	if buf == nil {
+		var err error
+		buf, err = Theora.NewTheoraYUVbuffer()
+		if err != nil {
+			return nil, err
+		}
+		s.bufs[s.next%2] = buf
+	}
+	if !buf.ConvertFromRasterImageInfo(s.inf, frame(s.next)) {
+		return nil, Theora.ETheoraConvertException
+	}
+	s.next++
+	return buf, nil
+}
+
+func (s *frameSource) Close() {
+	for _, buf := range s.bufs {
+		if buf != nil {
+			buf.Close()
+		}
+	}
+}
+
+func newInfo() Theora.ITheoraInfo {
+	cfg := Theora.NewEncoderConfig(WIDTH, HEIGHT)
+	cfg.FPSNumerator = FPS
+	inf, err := cfg.NewTheoraInfo()
+	testutil.Check(err)
+	return inf
+}
+
+// encodeTwoPass runs EncodeTwoPass and returns the size of the stream
+func encodeTwoPass(target Theora.TwoPassTarget) int {
+	inf := newInfo()
+	defer inf.Close()
+	tc, err := Theora.NewTheoraComment()
+	testutil.Check(err)
+	defer tc.Close()
+	tc.Init()
+	src := &frameSource{inf: inf}
+	defer src.Close()
+
+	var out bytes.Buffer
+	testutil.Check(Theora.EncodeTwoPass(inf, tc, src, &out, target))
+	testutil.Expect("the info is not changed", inf.GetTargetBitrate() == 0)
+	return out.Len()
+}
+
+// testFailure stops the source in the middle, the encoders of both
+// passes are released
+func testFailure() {
+	inf := newInfo()
+	defer inf.Close()
+	tc, err := Theora.NewTheoraComment()
+	testutil.Check(err)
+	defer tc.Close()
+	tc.Init()
+	src := &frameSource{inf: inf, failAt: FRAMES_COUNT / 2}
+	defer src.Close()
+	states := Theora.LiveAllocations()["TheoraState"]
+	err = Theora.EncodeTwoPass(inf, tc, src, io.Discard, Theora.TwoPassTarget{Bitrate: 200000})
+	testutil.Expect("the source error is returned", errors.Is(err, errSource))
+	testutil.Expect("the encoder is released after the error", Theora.LiveAllocations()["TheoraState"] == states)
+}
+
+// near checks that the value is within 30% of the target
+func near(value, target float64) bool {
+	return value > target*0.7 && value < target*1.3
+}
+
+func testBitrate() {
+	const seconds = float64(FRAMES_COUNT) / FPS
+	low := encodeTwoPass(Theora.TwoPassTarget{Bitrate: 200000})
+	high := encodeTwoPass(Theora.TwoPassTarget{Bitrate: 600000})
+	testutil.Expect("bitrate 200000 is reached", near(float64(low*8)/seconds, 200000))
+	testutil.Expect("bitrate 600000 is reached", near(float64(high*8)/seconds, 600000))
+	testutil.Expect("higher bitrate gives a larger stream", low < high)
+}
+
+func testFileSize() {
+	size := encodeTwoPass(Theora.TwoPassTarget{FileSize: 60000})
+	testutil.Expect("file size 60000 is reached", near(float64(size), 60000))
+}
+
+// firstPass collects the metrics into stats
+func firstPass(stats io.Writer) {
+	inf := newInfo()
+	defer inf.Close()
+	inf.SetTargetBitrate(300000)
+	enc, err := Theora.NewTheoraEncoder(inf, io.Discard)
+	testutil.Check(err)
+	testutil.Check(enc.SetSerialNo(1))
+	testutil.Check(enc.StartFirstPass(stats))
+	testutil.Check(enc.SaveDefHeadersToStream())
+	for i := 0; i < FRAMES_COUNT; i++ {
+		testutil.Check(enc.SaveImageToStream(frame(i), i == FRAMES_COUNT-1))
+	}
+	/* Close writes the summary of the metrics */
+	testutil.Check(enc.Close())
+}
+
+// secondPass encodes the frames with the metrics of stats
+func secondPass(stats io.Reader) []byte {
+	inf := newInfo()
+	defer inf.Close()
+	inf.SetTargetBitrate(300000)
+	var out bytes.Buffer
+	enc, err := Theora.NewTheoraEncoder(inf, &out)
+	testutil.Check(err)
+	testutil.Check(enc.SetSerialNo(1))
+	testutil.Check(enc.StartSecondPass(stats))
+	testutil.Check(enc.SaveDefHeadersToStream())
+	for i := 0; i < FRAMES_COUNT; i++ {
+		testutil.Check(enc.SaveImageToStream(frame(i), i == FRAMES_COUNT-1))
+	}
+	testutil.Check(enc.Close())
+	return out.Bytes()
+}
+
+func testStats() {
+	/* os.File is an io.WriteSeeker, the summary is rewritten in place */
+	file, err := os.CreateTemp("", "twopass-*.stats")
+	testutil.Check(err)
+	defer os.Remove(file.Name())
+	defer file.Close()
+	firstPass(file)
+	fileStats, err := os.ReadFile(file.Name())
+	testutil.Check(err)
+
+	/* bytes.Buffer is not seekable, the metrics are kept in memory */
+	var memStats bytes.Buffer
+	firstPass(&memStats)
+
+	testutil.Expect("metrics are collected", len(fileStats) > 0)
+	testutil.Expect("seekable and in-memory metrics are equal", bytes.Equal(fileStats, memStats.Bytes()))
+
+	fromFile := secondPass(bytes.NewReader(fileStats))
+	fromMem := secondPass(&memStats)
+	testutil.Expect("second pass gives a stream", len(fromFile) > 0)
+	testutil.Expect("second pass does not depend on the metrics storage", bytes.Equal(fromFile, fromMem))
+}
+
+func main() {
+	testBitrate()
+	testFileSize()
+	testStats()
+	testFailure()
+
+	testutil.Exit()
+}
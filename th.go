@@ -0,0 +1,1000 @@
+/* GoTheora
+Wrapper for Theora library
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package gotheora
+
+/*
+#cgo CFLAGS: -I/usr/include
+#cgo LDFLAGS: -ltheoraenc -ltheoradec
+#include "theora/theoradec.h"
+#include "theora/theoraenc.h"
+#include "theora/codec.h"
+#include "theora/theora.h"
+#include <stdlib.h>
+
+int size_of_struct_th_info() {
+    return sizeof(th_info);
+}
+
+int size_of_struct_th_comment() {
+    return sizeof(th_comment);
+}
+
+int size_of_struct_th_ycbcr_buffer() {
+    return sizeof(th_ycbcr_buffer);
+}
+
+*/
+import "C"
+import (
+	"image"
+	"runtime"
+	"unsafe"
+
+	OGG "github.com/ilya2ik/googg"
+)
+
+/* Bindings for the modern th_* API of libtheora (theora/codec.h,
+   theora/theoradec.h, theora/theoraenc.h) */
+
+type IThInfo interface {
+	Ref() *C.th_info
+
+	Init()
+	Done()
+	Close() error
+
+	GetVersionMajor() byte
+	GetVersionMinor() byte
+	GetVersionSubminor() byte
+
+	GetFrameWidth() int
+	SetFrameWidth(AValue int)
+	GetFrameHeight() int
+	SetFrameHeight(AValue int)
+	GetPicWidth() int
+	SetPicWidth(AValue int)
+	GetPicHeight() int
+	SetPicHeight(AValue int)
+	GetPicX() int
+	SetPicX(AValue int)
+	GetPicY() int
+	SetPicY(AValue int)
+	GetFPSNumerator() int
+	SetFPSNumerator(AValue int)
+	GetFPSDenominator() int
+	SetFPSDenominator(AValue int)
+	GetAspectNumerator() int
+	SetAspectNumerator(AValue int)
+	GetAspectDenominator() int
+	SetAspectDenominator(AValue int)
+	GetColorspace() Colorspace
+	SetColorspace(AValue Colorspace)
+	GetPixelFormat() image.YCbCrSubsampleRatio
+	SetPixelFormat(AValue image.YCbCrSubsampleRatio)
+	GetTargetBitrate() int
+	SetTargetBitrate(AValue int)
+	GetQuality() int
+	SetQuality(AValue int)
+	GetKeyframeGranuleShift() int
+	SetKeyframeGranuleShift(AValue int)
+
+	AssignFromTheoraInfo(inf ITheoraInfo)
+	AssignToTheoraInfo(inf ITheoraInfo)
+}
+
+type IThComment interface {
+	Ref() *C.th_comment
+
+	Init()
+	Done()
+	Close() error
+
+	GetVendor() string
+
+	Add(comment string)
+	AddTag(tag, value string)
+	TagsCount() int
+	GetTag(index int) string
+	Query(tag string, index int) string
+	QueryCount(tag string) int
+
+	AssignFromTheoraComment(tc ITheoraComment)
+	AssignToTheoraComment(tc ITheoraComment)
+}
+
+type IThYCbCrBuffer interface {
+	Ref() *C.th_img_plane
+
+	Done()
+	Close() error
+	Alloc(width, height int, pf image.YCbCrSubsampleRatio) error
+
+	GetWidth(plane int) int
+	GetHeight(plane int) int
+	GetStride(plane int) int
+	GetData(plane int) []byte
+	Row(plane, y int) []byte
+
+	AssignFromYUVbuffer(yuv ITheoraYUVbuffer) error
+}
+
+type IThSetupInfo interface {
+	Ref() *C.th_setup_info
+	Done()
+	Close() error
+}
+
+type IThEncoder interface {
+	Ref() *C.th_enc_ctx
+	Done()
+	Close() error
+
+	Control(req int, buf []byte) int
+	FlushHeader(tc IThComment, op OGG.IOGGPacket) (bool, error)
+	YCbCrIn(buf IThYCbCrBuffer) error
+	PacketOut(last_p bool, op OGG.IOGGPacket) error
+
+	GranuleFrame(granulepos int64) int64
+	GranuleTime(granulepos int64) float64
+}
+
+type IThDecoder interface {
+	Ref() *C.th_dec_ctx
+	Done()
+	Close() error
+
+	Control(req int, buf []byte) int
+	PacketIn(op OGG.IOGGPacket) (int64, error)
+	YCbCrOut(buf IThYCbCrBuffer) error
+
+	GranuleFrame(granulepos int64) int64
+	GranuleTime(granulepos int64) float64
+}
+
+/* Common methods */
+
+func ThVersion() string {
+	return C.GoString(C.th_version_string())
+}
+
+func ThVersionNumber() uint32 {
+	return uint32(C.th_version_number())
+}
+
+func ThPacketIsHeader(op OGG.IOGGPacket) bool {
+	return C.th_packet_isheader(oggPacketRef(op)) == 1
+}
+
+func ThPacketIsKeyframe(op OGG.IOGGPacket) bool {
+	return C.th_packet_iskeyframe(oggPacketRef(op)) == 1
+}
+
+func thControlBuf(buf []byte) (unsafe.Pointer, C.size_t) {
+	if len(buf) == 0 {
+		return nil, 0
+	}
+	return unsafe.Pointer(&buf[0]), C.size_t(len(buf))
+}
+
+// ilog returns the number of bits needed to store v
+func ilog(v uint32) int {
+	r := 0
+	for v > 0 {
+		r++
+		v >>= 1
+	}
+	return r
+}
+
+/* ThInfo */
+
+type ThInfo struct {
+	fValue *C.th_info
+}
+
+func NewThInfo() (IThInfo, error) {
+	value := new(ThInfo)
+
+	mem, err := C.calloc(1, (C.size_t)(C.size_of_struct_th_info()))
+	if mem == nil {
+		return nil, errTheoraOutOfMemory{err}
+	}
+	value.fValue = (*C.th_info)(mem)
+	trackAlloc(allocThInfo)
+	runtime.SetFinalizer(value, func(a *ThInfo) {
+		if a.fValue != nil {
+			trackFinalized(allocThInfo)
+		}
+		a.Done()
+	})
+	return value, nil
+}
+
+func (v *ThInfo) Ref() *C.th_info {
+	return v.fValue
+}
+
+func (v *ThInfo) Init() {
+	C.th_info_init(v.Ref())
+}
+
+func (v *ThInfo) Done() {
+	if v.fValue != nil {
+		C.th_info_clear(v.fValue)
+		C.free(unsafe.Pointer(v.fValue))
+		v.fValue = nil
+		trackFree(allocThInfo)
+	}
+}
+
+func (v *ThInfo) Close() error {
+	v.Done()
+	return nil
+}
+
+func (v *ThInfo) GetVersionMajor() byte {
+	return byte(v.fValue.version_major)
+}
+
+func (v *ThInfo) GetVersionMinor() byte {
+	return byte(v.fValue.version_minor)
+}
+
+func (v *ThInfo) GetVersionSubminor() byte {
+	return byte(v.fValue.version_subminor)
+}
+
+func (v *ThInfo) GetFrameWidth() int {
+	return int(v.fValue.frame_width)
+}
+
+func (v *ThInfo) SetFrameWidth(AValue int) {
+	v.fValue.frame_width = C.uint32_t(AValue)
+}
+
+func (v *ThInfo) GetFrameHeight() int {
+	return int(v.fValue.frame_height)
+}
+
+func (v *ThInfo) SetFrameHeight(AValue int) {
+	v.fValue.frame_height = C.uint32_t(AValue)
+}
+
+func (v *ThInfo) GetPicWidth() int {
+	return int(v.fValue.pic_width)
+}
+
+func (v *ThInfo) SetPicWidth(AValue int) {
+	v.fValue.pic_width = C.uint32_t(AValue)
+}
+
+func (v *ThInfo) GetPicHeight() int {
+	return int(v.fValue.pic_height)
+}
+
+func (v *ThInfo) SetPicHeight(AValue int) {
+	v.fValue.pic_height = C.uint32_t(AValue)
+}
+
+func (v *ThInfo) GetPicX() int {
+	return int(v.fValue.pic_x)
+}
+
+func (v *ThInfo) SetPicX(AValue int) {
+	v.fValue.pic_x = C.uint32_t(AValue)
+}
+
+func (v *ThInfo) GetPicY() int {
+	return int(v.fValue.pic_y)
+}
+
+func (v *ThInfo) SetPicY(AValue int) {
+	v.fValue.pic_y = C.uint32_t(AValue)
+}
+
+func (v *ThInfo) GetFPSNumerator() int {
+	return int(v.fValue.fps_numerator)
+}
+
+func (v *ThInfo) SetFPSNumerator(AValue int) {
+	v.fValue.fps_numerator = C.uint32_t(AValue)
+}
+
+func (v *ThInfo) GetFPSDenominator() int {
+	return int(v.fValue.fps_denominator)
+}
+
+func (v *ThInfo) SetFPSDenominator(AValue int) {
+	v.fValue.fps_denominator = C.uint32_t(AValue)
+}
+
+func (v *ThInfo) GetAspectNumerator() int {
+	return int(v.fValue.aspect_numerator)
+}
+
+func (v *ThInfo) SetAspectNumerator(AValue int) {
+	v.fValue.aspect_numerator = C.uint32_t(AValue)
+}
+
+func (v *ThInfo) GetAspectDenominator() int {
+	return int(v.fValue.aspect_denominator)
+}
+
+func (v *ThInfo) SetAspectDenominator(AValue int) {
+	v.fValue.aspect_denominator = C.uint32_t(AValue)
+}
+
+func (v *ThInfo) GetColorspace() Colorspace {
+	return Colorspace(v.fValue.colorspace)
+}
+
+func (v *ThInfo) SetColorspace(AValue Colorspace) {
+	v.fValue.colorspace = C.th_colorspace(AValue)
+}
+
+func (v *ThInfo) GetPixelFormat() image.YCbCrSubsampleRatio {
+	switch v.fValue.pixel_fmt {
+	case C.TH_PF_420:
+		return image.YCbCrSubsampleRatio420
+	case C.TH_PF_422:
+		return image.YCbCrSubsampleRatio422
+	case C.TH_PF_444:
+		return image.YCbCrSubsampleRatio444
+	}
+	return image.YCbCrSubsampleRatio410
+}
+
+func (v *ThInfo) SetPixelFormat(AValue image.YCbCrSubsampleRatio) {
+	switch AValue {
+	case image.YCbCrSubsampleRatio420:
+		v.fValue.pixel_fmt = C.TH_PF_420
+	case image.YCbCrSubsampleRatio422:
+		v.fValue.pixel_fmt = C.TH_PF_422
+	case image.YCbCrSubsampleRatio444:
+		v.fValue.pixel_fmt = C.TH_PF_444
+	}
+}
+
+func (v *ThInfo) GetTargetBitrate() int {
+	return int(v.fValue.target_bitrate)
+}
+
+func (v *ThInfo) SetTargetBitrate(AValue int) {
+	v.fValue.target_bitrate = C.int(AValue)
+}
+
+func (v *ThInfo) GetQuality() int {
+	return int(v.fValue.quality)
+}
+
+func (v *ThInfo) SetQuality(AValue int) {
+	v.fValue.quality = C.int(AValue)
+}
+
+func (v *ThInfo) GetKeyframeGranuleShift() int {
+	return int(v.fValue.keyframe_granule_shift)
+}
+
+func (v *ThInfo) SetKeyframeGranuleShift(AValue int) {
+	v.fValue.keyframe_granule_shift = C.int(AValue)
+}
+
+// AssignFromTheoraInfo fills the structure from the legacy info the
+// same way libtheora does for the legacy API
+func (v *ThInfo) AssignFromTheoraInfo(inf ITheoraInfo) {
+	v.fValue.version_major = C.uchar(inf.GetVersionMajor())
+	v.fValue.version_minor = C.uchar(inf.GetVersionMinor())
+	v.fValue.version_subminor = C.uchar(inf.GetVersionSubminor())
+	v.SetFrameWidth(inf.GetWidth())
+	v.SetFrameHeight(inf.GetHeight())
+	v.SetPicWidth(inf.GetFrameWidth())
+	v.SetPicHeight(inf.GetFrameHeight())
+	v.SetPicX(inf.GetOffsetX())
+	v.SetPicY(inf.GetOffsetY())
+	v.SetFPSNumerator(inf.GetFPSNumerator())
+	v.SetFPSDenominator(inf.GetFPSDenominator())
+	v.SetAspectNumerator(inf.GetAspectNumerator())
+	v.SetAspectDenominator(inf.GetAspectDenominator())
+	v.SetColorspace(inf.GetColorspace())
+	v.SetPixelFormat(inf.GetPixelFormat())
+	v.SetTargetBitrate(inf.GetTargetBitrate())
+	v.SetQuality(inf.GetQuality())
+	kff := inf.GetKeyframeFrequencyForce()
+	if kff > 0 {
+		v.SetKeyframeGranuleShift(min(ilog(uint32(kff-1)), 31))
+	} else {
+		v.SetKeyframeGranuleShift(0)
+	}
+}
+
+// AssignToTheoraInfo copies the fields that have a legacy counterpart
+// to inf. The encoder-only fields of inf are left untouched
+func (v *ThInfo) AssignToTheoraInfo(inf ITheoraInfo) {
+	inf.Ref().version_major = C.uchar(v.GetVersionMajor())
+	inf.Ref().version_minor = C.uchar(v.GetVersionMinor())
+	inf.Ref().version_subminor = C.uchar(v.GetVersionSubminor())
+	inf.SetWidth(v.GetFrameWidth())
+	inf.SetHeight(v.GetFrameHeight())
+	inf.SetFrameWidth(v.GetPicWidth())
+	inf.SetFrameHeight(v.GetPicHeight())
+	inf.SetOffsetX(v.GetPicX())
+	inf.SetOffsetY(v.GetPicY())
+	inf.SetFPSNumerator(v.GetFPSNumerator())
+	inf.SetFPSDenominator(v.GetFPSDenominator())
+	inf.SetAspectNumerator(v.GetAspectNumerator())
+	inf.SetAspectDenominator(v.GetAspectDenominator())
+	inf.SetColorspace(v.GetColorspace())
+	inf.SetPixelFormat(v.GetPixelFormat())
+	inf.SetTargetBitrate(v.GetTargetBitrate())
+	inf.SetQuality(v.GetQuality())
+	inf.SetKeyframeFrequencyForce(1 << v.GetKeyframeGranuleShift())
+}
+
+/* ThComment */
+
+type ThComment struct {
+	fValue *C.th_comment
+}
+
+func NewThComment() (IThComment, error) {
+	value := new(ThComment)
+
+	mem, err := C.calloc(1, (C.size_t)(C.size_of_struct_th_comment()))
+	if mem == nil {
+		return nil, errTheoraOutOfMemory{err}
+	}
+	value.fValue = (*C.th_comment)(mem)
+	trackAlloc(allocThComment)
+	runtime.SetFinalizer(value, func(a *ThComment) {
+		if a.fValue != nil {
+			trackFinalized(allocThComment)
+		}
+		a.Done()
+	})
+	return value, nil
+}
+
+func (v *ThComment) Ref() *C.th_comment {
+	return v.fValue
+}
+
+func (v *ThComment) Init() {
+	C.th_comment_init(v.Ref())
+}
+
+func (v *ThComment) Done() {
+	if v.fValue != nil {
+		C.th_comment_clear(v.Ref())
+		C.free(unsafe.Pointer(v.fValue))
+		v.fValue = nil
+		trackFree(allocThComment)
+	}
+}
+
+func (v *ThComment) Close() error {
+	v.Done()
+	return nil
+}
+
+func (v *ThComment) GetVendor() string {
+	return C.GoString(v.Ref().vendor)
+}
+
+func (v *ThComment) Add(comment string) {
+	cs := C.CString(comment)
+	defer C.free(unsafe.Pointer(cs))
+	C.th_comment_add(v.Ref(), cs)
+}
+
+func (v *ThComment) AddTag(tag string, value string) {
+	ctag := C.CString(tag)
+	defer C.free(unsafe.Pointer(ctag))
+	cvalue := C.CString(value)
+	defer C.free(unsafe.Pointer(cvalue))
+	C.th_comment_add_tag(v.Ref(), ctag, cvalue)
+}
+
+func (v *ThComment) TagsCount() int {
+	return int(v.Ref().comments)
+}
+
+func (v *ThComment) GetTag(index int) string {
+	if index < 0 || index >= v.TagsCount() {
+		return ""
+	}
+	cnt := v.TagsCount()
+	comments := unsafe.Slice(v.Ref().user_comments, cnt)
+	lengths := unsafe.Slice(v.Ref().comment_lengths, cnt)
+	return C.GoStringN(comments[index], lengths[index])
+}
+
+func (v *ThComment) Query(tag string, index int) string {
+	ctag := C.CString(tag)
+	defer C.free(unsafe.Pointer(ctag))
+	return C.GoString(C.th_comment_query(v.Ref(), ctag, C.int(index)))
+}
+
+func (v *ThComment) QueryCount(tag string) int {
+	ctag := C.CString(tag)
+	defer C.free(unsafe.Pointer(ctag))
+	return int(C.th_comment_query_count(v.Ref(), ctag))
+}
+
+// AssignFromTheoraComment appends all the comments of the legacy
+// structure. The vendor string is set by the encoder
+func (v *ThComment) AssignFromTheoraComment(tc ITheoraComment) {
+	src := tc.Ref()
+	cnt := int(src.comments)
+	if cnt == 0 {
+		return
+	}
+	comments := unsafe.Slice(src.user_comments, cnt)
+	lengths := unsafe.Slice(src.comment_lengths, cnt)
+	for i := 0; i < cnt; i++ {
+		v.Add(C.GoStringN(comments[i], lengths[i]))
+	}
+}
+
+// AssignToTheoraComment sets the vendor string of the legacy
+// structure and appends all the comments to it. The comments are
+// copied as they are, without the checks of TheoraComment.AddChecked
+func (v *ThComment) AssignToTheoraComment(tc ITheoraComment) {
+	tc.SetVendor(v.GetVendor())
+	for i := 0; i < v.TagsCount(); i++ {
+		cs := C.CString(v.GetTag(i))
+		C.theora_comment_add(tc.Ref(), cs)
+		C.free(unsafe.Pointer(cs))
+	}
+}
+
+/* ThYCbCrBuffer */
+
+type ThYCbCrBuffer struct {
+	fValue   *C.th_img_plane
+	fOwnData bool
+	fView    bool
+}
+
+func NewThYCbCrBuffer() (IThYCbCrBuffer, error) {
+	return newThYCbCrBuffer()
+}
+
+func newThYCbCrBuffer() (*ThYCbCrBuffer, error) {
+	value := new(ThYCbCrBuffer)
+
+	mem, err := C.calloc(1, (C.size_t)(C.size_of_struct_th_ycbcr_buffer()))
+	if mem == nil {
+		return nil, errTheoraOutOfMemory{err}
+	}
+	value.fValue = (*C.th_img_plane)(mem)
+	trackAlloc(allocThYCbCrBuffer)
+	runtime.SetFinalizer(value, func(a *ThYCbCrBuffer) {
+		if a.fValue != nil {
+			trackFinalized(allocThYCbCrBuffer)
+		}
+		a.Done()
+	})
+	return value, nil
+}
+
+// newThYCbCrBufferView wraps the planes owned by libtheora. Done
+// does not release them
+func newThYCbCrBufferView(ref *C.th_img_plane) *ThYCbCrBuffer {
+	return &ThYCbCrBuffer{fValue: ref, fView: true}
+}
+
+func (v *ThYCbCrBuffer) Ref() *C.th_img_plane {
+	return v.fValue
+}
+
+func (v *ThYCbCrBuffer) planes() []C.th_img_plane {
+	return unsafe.Slice(v.fValue, 3)
+}
+
+func (v *ThYCbCrBuffer) freeData() {
+	if v.fOwnData {
+		pl := v.planes()
+		for i := range pl {
+			if pl[i].data != nil {
+				C.free(unsafe.Pointer(pl[i].data))
+				pl[i].data = nil
+			}
+		}
+		v.fOwnData = false
+	}
+}
+
+func (v *ThYCbCrBuffer) Done() {
+	if v.fValue != nil {
+		if !v.fView {
+			v.freeData()
+			C.free(unsafe.Pointer(v.fValue))
+			trackFree(allocThYCbCrBuffer)
+		}
+		v.fValue = nil
+	}
+}
+
+func (v *ThYCbCrBuffer) Close() error {
+	v.Done()
+	return nil
+}
+
+// Alloc allocates the planes in C memory for a frame of the given
+// size and pixel format. The memory is released by Done
+func (v *ThYCbCrBuffer) Alloc(width, height int, pf image.YCbCrSubsampleRatio) error {
+	if v.fView {
+		return newError("ThYCbCrBuffer.Alloc", C.TH_EINVAL)
+	}
+	v.freeData()
+
+	cw, ch := width, height
+	switch pf {
+	case image.YCbCrSubsampleRatio420:
+		cw, ch = (width+1)>>1, (height+1)>>1
+	case image.YCbCrSubsampleRatio422:
+		cw = (width + 1) >> 1
+	case image.YCbCrSubsampleRatio444:
+	default:
+		return newError("ThYCbCrBuffer.Alloc", C.TH_EINVAL)
+	}
+
+	pl := v.planes()
+	v.fOwnData = true
+	for i := range pl {
+		w, h := width, height
+		if i > 0 {
+			w, h = cw, ch
+		}
+		mem, err := C.calloc(C.size_t(w*h), 1)
+		if mem == nil {
+			v.freeData()
+			return errTheoraOutOfMemory{err}
+		}
+		pl[i].width = C.int(w)
+		pl[i].height = C.int(h)
+		pl[i].stride = C.int(w)
+		pl[i].data = (*C.uchar)(mem)
+	}
+	return nil
+}
+
+func (v *ThYCbCrBuffer) GetWidth(plane int) int {
+	return int(v.planes()[plane].width)
+}
+
+func (v *ThYCbCrBuffer) GetHeight(plane int) int {
+	return int(v.planes()[plane].height)
+}
+
+func (v *ThYCbCrBuffer) GetStride(plane int) int {
+	return int(v.planes()[plane].stride)
+}
+
+// GetData returns the whole memory of the plane. If the stride is
+// negative, the slice starts with the bottom row of the plane
+func (v *ThYCbCrBuffer) GetData(plane int) []byte {
+	pl := v.planes()[plane]
+	if pl.data == nil || pl.height == 0 {
+		return nil
+	}
+	stride := int(pl.stride)
+	base := unsafe.Pointer(pl.data)
+	if stride < 0 {
+		stride = -stride
+		base = unsafe.Add(base, -stride*(int(pl.height)-1))
+	}
+	return unsafe.Slice((*byte)(base), stride*int(pl.height))
+}
+
+// Row returns the y-th row of the plane counting from the top
+func (v *ThYCbCrBuffer) Row(plane, y int) []byte {
+	pl := v.planes()[plane]
+	row := unsafe.Add(unsafe.Pointer(pl.data), y*int(pl.stride))
+	return unsafe.Slice((*byte)(row), int(pl.width))
+}
+
+// AssignFromYUVbuffer allocates the planes and copies the content of
+// the legacy buffer into them
+func (v *ThYCbCrBuffer) AssignFromYUVbuffer(yuv ITheoraYUVbuffer) error {
+	if v.fView {
+		return newError("ThYCbCrBuffer.AssignFromYUVbuffer", C.TH_EINVAL)
+	}
+	v.freeData()
+
+	src := yuv.Ref()
+	type plane struct {
+		data         *C.uchar
+		w, h, stride int
+	}
+	from := []plane{
+		{src.y, yuv.GetYWidth(), yuv.GetYHeight(), yuv.GetYStride()},
+		{src.u, yuv.GetUVWidth(), yuv.GetUVHeight(), yuv.GetUVStride()},
+		{src.v, yuv.GetUVWidth(), yuv.GetUVHeight(), yuv.GetUVStride()},
+	}
+
+	pl := v.planes()
+	v.fOwnData = true
+	for i := range pl {
+		mem, err := C.calloc(C.size_t(from[i].w*from[i].h), 1)
+		if mem == nil {
+			v.freeData()
+			return errTheoraOutOfMemory{err}
+		}
+		pl[i].width = C.int(from[i].w)
+		pl[i].height = C.int(from[i].h)
+		pl[i].stride = C.int(from[i].w)
+		pl[i].data = (*C.uchar)(mem)
+		copy(unsafe.Slice((*byte)(mem), from[i].w*from[i].h),
+			copyPlane(from[i].data, from[i].stride, from[i].w, from[i].h))
+	}
+	return nil
+}
+
+// assignView points the planes to the planes of the legacy buffer
+// without copying, like theora_encode_YUVin does. The planes are not
+// released by Done
+func (v *ThYCbCrBuffer) assignView(yuv ITheoraYUVbuffer) {
+	v.freeData()
+	src := yuv.Ref()
+	pl := v.planes()
+	pl[0].width = C.int(yuv.GetYWidth())
+	pl[0].height = C.int(yuv.GetYHeight())
+	pl[0].stride = C.int(yuv.GetYStride())
+	pl[0].data = src.y
+	for i, data := range []*C.uchar{src.u, src.v} {
+		pl[i+1].width = C.int(yuv.GetUVWidth())
+		pl[i+1].height = C.int(yuv.GetUVHeight())
+		pl[i+1].stride = C.int(yuv.GetUVStride())
+		pl[i+1].data = data
+	}
+}
+
+// assignToYUVbuffer points the planes of the legacy buffer to the
+// planes of v, like theora_decode_YUVout does
+func (v *ThYCbCrBuffer) assignToYUVbuffer(yuv ITheoraYUVbuffer) {
+	pl := v.planes()
+	yuv.SetYWidth(int(pl[0].width))
+	yuv.SetYHeight(int(pl[0].height))
+	yuv.SetYStride(int(pl[0].stride))
+	yuv.SetUVWidth(int(pl[1].width))
+	yuv.SetUVHeight(int(pl[1].height))
+	yuv.SetUVStride(int(pl[1].stride))
+	dst := yuv.Ref()
+	dst.y, dst.u, dst.v = pl[0].data, pl[1].data, pl[2].data
+}
+
+/* ThSetupInfo */
+
+type ThSetupInfo struct {
+	fValue *C.th_setup_info
+}
+
+func NewThSetupInfo() (IThSetupInfo, error) {
+	value := new(ThSetupInfo)
+	runtime.SetFinalizer(value, func(a *ThSetupInfo) {
+		if a.fValue != nil {
+			trackFinalized(allocThSetupInfo)
+		}
+		a.Done()
+	})
+	return value, nil
+}
+
+func (v *ThSetupInfo) Ref() *C.th_setup_info {
+	return v.fValue
+}
+
+func (v *ThSetupInfo) Done() {
+	if v.fValue != nil {
+		C.th_setup_free(v.fValue)
+		v.fValue = nil
+		trackFree(allocThSetupInfo)
+	}
+}
+
+func (v *ThSetupInfo) Close() error {
+	v.Done()
+	return nil
+}
+
+/* ThEncoder */
+
+type ThEncoder struct {
+	fValue *C.th_enc_ctx
+}
+
+func NewThEncoder(inf IThInfo) (IThEncoder, error) {
+	value := new(ThEncoder)
+	value.fValue = C.th_encode_alloc(inf.Ref())
+	if value.fValue == nil {
+		return nil, newError("NewThEncoder", C.TH_EINVAL)
+	}
+	trackAlloc(allocThEncoder)
+	runtime.SetFinalizer(value, func(a *ThEncoder) {
+		if a.fValue != nil {
+			trackFinalized(allocThEncoder)
+		}
+		a.Done()
+	})
+	return value, nil
+}
+
+func (v *ThEncoder) Ref() *C.th_enc_ctx {
+	return v.fValue
+}
+
+func (v *ThEncoder) Done() {
+	if v.fValue != nil {
+		C.th_encode_free(v.fValue)
+		v.fValue = nil
+		trackFree(allocThEncoder)
+	}
+}
+
+func (v *ThEncoder) Close() error {
+	v.Done()
+	return nil
+}
+
+func (v *ThEncoder) Control(req int, buf []byte) int {
+	p, sz := thControlBuf(buf)
+	return int(C.th_encode_ctl(v.fValue, C.int(req), p, sz))
+}
+
+// FlushHeader outputs the next header packet. false is returned when
+// all the header packets have been produced
+func (v *ThEncoder) FlushHeader(tc IThComment, op OGG.IOGGPacket) (bool, error) {
+	R := int(C.th_encode_flushheader(v.fValue, tc.Ref(), oggPacketRef(op)))
+	if R < 0 {
+		return false, newError("ThEncoder.FlushHeader", R)
+	}
+	return R > 0, nil
+}
+
+func (v *ThEncoder) YCbCrIn(buf IThYCbCrBuffer) error {
+	R := int(C.th_encode_ycbcr_in(v.fValue, buf.Ref()))
+	if R == 0 {
+		return nil
+	} else if R == C.TH_EINVAL {
+		return newFrameError("ThEncoder.YCbCrIn", R, -1, ETheoraEncDifferException)
+	} else {
+		return newError("ThEncoder.YCbCrIn", R)
+	}
+}
+
+func (v *ThEncoder) PacketOut(last_p bool, op OGG.IOGGPacket) error {
+	var lp C.int
+	if last_p {
+		lp = 1
+	} else {
+		lp = 0
+	}
+
+	R := int(C.th_encode_packetout(v.fValue, lp, oggPacketRef(op)))
+	if R > 0 {
+		return nil
+	} else if R == 0 {
+		return newFrameError("ThEncoder.PacketOut", R, -1, ETheoraEncNotPackReadyException)
+	} else {
+		return newError("ThEncoder.PacketOut", R)
+	}
+}
+
+func (v *ThEncoder) GranuleFrame(granulepos int64) int64 {
+	return int64(C.th_granule_frame(unsafe.Pointer(v.fValue), C.ogg_int64_t(granulepos)))
+}
+
+func (v *ThEncoder) GranuleTime(granulepos int64) float64 {
+	return float64(C.th_granule_time(unsafe.Pointer(v.fValue), C.ogg_int64_t(granulepos)))
+}
+
+/* ThDecoder */
+
+type ThDecoder struct {
+	fValue *C.th_dec_ctx
+}
+
+// ThDecodeHeaderIn decodes one of the three header packets. The
+// result is true if the packet was a header and false if the first
+// video packet was met; that packet must be passed to the decoder
+func ThDecodeHeaderIn(inf IThInfo, tc IThComment, setup IThSetupInfo, op OGG.IOGGPacket) (bool, error) {
+	s, ok := setup.(*ThSetupInfo)
+	if !ok || s == nil {
+		return false, newError("ThDecodeHeaderIn", C.TH_EFAULT)
+	}
+	allocated := s.fValue != nil
+	R := int(C.th_decode_headerin(inf.Ref(), tc.Ref(), &s.fValue, oggPacketRef(op)))
+	if !allocated && s.fValue != nil {
+		trackAlloc(allocThSetupInfo)
+	}
+	if R < 0 {
+		return false, newError("ThDecodeHeaderIn", R)
+	}
+	return R > 0, nil
+}
+
+func NewThDecoder(inf IThInfo, setup IThSetupInfo) (IThDecoder, error) {
+	value := new(ThDecoder)
+	value.fValue = C.th_decode_alloc(inf.Ref(), setup.Ref())
+	if value.fValue == nil {
+		return nil, newError("NewThDecoder", C.TH_EFAULT)
+	}
+	trackAlloc(allocThDecoder)
+	runtime.SetFinalizer(value, func(a *ThDecoder) {
+		if a.fValue != nil {
+			trackFinalized(allocThDecoder)
+		}
+		a.Done()
+	})
+	return value, nil
+}
+
+func (v *ThDecoder) Ref() *C.th_dec_ctx {
+	return v.fValue
+}
+
+func (v *ThDecoder) Done() {
+	if v.fValue != nil {
+		C.th_decode_free(v.fValue)
+		v.fValue = nil
+		trackFree(allocThDecoder)
+	}
+}
+
+func (v *ThDecoder) Close() error {
+	v.Done()
+	return nil
+}
+
+func (v *ThDecoder) Control(req int, buf []byte) int {
+	p, sz := thControlBuf(buf)
+	return int(C.th_decode_ctl(v.fValue, C.int(req), p, sz))
+}
+
+// PacketIn submits a video packet and returns the granule position of
+// the decoded frame. A packet of a duplicate frame (TH_DUPFRAME) is not
+// an error, the last frame is shown again
+func (v *ThDecoder) PacketIn(op OGG.IOGGPacket) (int64, error) {
+	var gp C.ogg_int64_t
+	R := int(C.th_decode_packetin(v.fValue, oggPacketRef(op), &gp))
+	if R == 0 || R == C.TH_DUPFRAME {
+		return int64(gp), nil
+	} else if R == C.TH_EBADPACKET {
+		return int64(gp), newFrameError("ThDecoder.PacketIn", R, -1, ETheoraDecBadPacketException)
+	} else {
+		return int64(gp), newError("ThDecoder.PacketIn", R)
+	}
+}
+
+// YCbCrOut points the planes of buf to the last decoded frame. The
+// memory is owned by the decoder and is valid until the next call
+// of PacketIn
+func (v *ThDecoder) YCbCrOut(buf IThYCbCrBuffer) error {
+	b, ok := buf.(*ThYCbCrBuffer)
+	if !ok || b == nil || b.fValue == nil {
+		return newError("ThDecoder.YCbCrOut", C.TH_EFAULT)
+	}
+	b.freeData()
+	R := int(C.th_decode_ycbcr_out(v.fValue, b.Ref()))
+	if R != 0 {
+		return newError("ThDecoder.YCbCrOut", R)
+	}
+	return nil
+}
+
+func (v *ThDecoder) GranuleFrame(granulepos int64) int64 {
+	return int64(C.th_granule_frame(unsafe.Pointer(v.fValue), C.ogg_int64_t(granulepos)))
+}
+
+func (v *ThDecoder) GranuleTime(granulepos int64) float64 {
+	return float64(C.th_granule_time(unsafe.Pointer(v.fValue), C.ogg_int64_t(granulepos)))
+}
@@ -39,12 +39,13 @@ int size_of_struct_theora_comment() {
 */
 import "C"
 import (
+	"errors"
 	"fmt"
 	"image"
-	"image/color"
 	"io"
 	"math/rand"
 	"runtime"
+	"sync"
 	"time"
 	"unsafe"
 
@@ -64,6 +65,7 @@ type ITheoraYUVbuffer interface {
 	Ref() *C.yuv_buffer
 
 	Done()
+	Close() error
 
 	GetYWidth() int
 	SetYWidth(value int)
@@ -88,8 +90,18 @@ type ITheoraYUVbuffer interface {
 
 	GetOwnData() bool
 	SetOwnData(value bool)
+	Alloc(ywidth, yheight, uvwidth, uvheight int) error
+	Err() error
 
 	ConvertFromRasterImage(chroma_format image.YCbCrSubsampleRatio, aData image.Image) bool
+	ConvertFromRasterImageInfo(inf ITheoraInfo, aData image.Image) bool
+	ConvertFromRasterImageOptions(chroma_format image.YCbCrSubsampleRatio, aData image.Image, opts ConvertOptions) bool
+	Clone() (ITheoraYUVbuffer, error)
+	CopyTo(dst ITheoraYUVbuffer) error
+	ToYCbCr(inf ITheoraInfo) *image.YCbCr
+	ToRGBA(inf ITheoraInfo) *image.RGBA
+	ToRGBAMatrix(inf ITheoraInfo, m ColorMatrix) *image.RGBA
+	ToRGBAOptions(inf ITheoraInfo, opts ConvertOptions) *image.RGBA
 }
 
 type ITheoraInfo interface {
@@ -97,6 +109,7 @@ type ITheoraInfo interface {
 
 	Init()
 	Done()
+	Close() error
 
 	GetAspectDenominator() int
 	GetAspectNumerator() int
@@ -161,16 +174,38 @@ type ITheoraComment interface {
 
 	Init()
 	Done()
+	Close() error
 
 	GetVendor() string
 	SetVendor(s string)
 
 	Add(comment string)
 	AddTag(tag, value string)
+	AddChecked(comment string) error
+	AddTagChecked(tag, value string) error
 	TagsCount() int
 	GetTag(index int) string
 	Query(tag string, index int) string
 	QueryCount(tag string) int
+
+	Tags() map[string][]string
+	Remove(tag string) int
+	Replace(tag, value string) error
+
+	GetTitle() string
+	SetTitle(AValue string) error
+	GetArtist() string
+	SetArtist(AValue string) error
+	GetDate() string
+	SetDate(AValue time.Time) error
+	GetLanguage() string
+	SetLanguage(AValue string) error
+	GetLicense() string
+	SetLicense(AValue string) error
+
+	AddPicture(p *TheoraPicture) error
+	Pictures() []*TheoraPicture
+	Picture(ptype PictureType) *TheoraPicture
 }
 
 type ITheoraState interface {
@@ -178,6 +213,7 @@ type ITheoraState interface {
 
 	Init(inf ITheoraInfo)
 	Done()
+	Close() error
 
 	Info() ITheoraInfo
 	GetGranulePos() int64
@@ -196,51 +232,67 @@ type ITheoraEncoder interface {
 	Tables(op OGG.IOGGPacket) error
 
 	Control(req int, buf []byte) int
+	SetQuality(value int) error
+	SetBitrate(value int) error
+	SetKeyframeFrequencyForce(value int) (int, error)
+	GetSpeedLevelMax() (int, error)
+	GetSpeedLevel() (int, error)
+	SetSpeedLevel(value int) error
+	SetVP3Compatible(value bool) (bool, error)
+	SetRateFlags(value RateFlags) error
+	SetRateBuffer(value int) (int, error)
+
+	TwoPassOut() ([]byte, error)
+	TwoPassIn(buf []byte) (int, error)
+	StartFirstPass(stats io.Writer) error
+	FinishFirstPass() error
+	StartSecondPass(stats io.Reader) error
+
+	SerialNo() int32
+	SetSerialNo(value int32) error
+
+	ForceKeyframe()
+	SetChapters(c Chapters)
+	EnableSkeleton(keypoints int) error
 
 	SaveDefHeadersToStream() error
 	SaveCustomHeadersToStream(tc ITheoraComment) error
 	SaveYUVBufferToStream(buf ITheoraYUVbuffer, is_last bool) error
+	SaveImageToStream(img image.Image, is_last bool) error
+	SaveEOSToStream() error
+	SetBufferPool(pool IYUVBufferPool)
+	BufferPool() IYUVBufferPool
 	Flush() error
 	Close() error
 }
 
 type ITheoraDecoder interface {
+	State() ITheoraState
+	IsReady() bool
+
+	Control(req int, buf []byte) int
+	GetPPLevelMax() (int, error)
+	SetPPLevel(value int) error
+	SetGranulePos(value int64) error
+	SetStripeCallback(cb StripeDecodedFunc) error
+	SetTelemetry(opt TelemetryOptions) error
+
 	Header(cc ITheoraComment, op OGG.IOGGPacket) error
 	PacketIn(op OGG.IOGGPacket) error
 	YUVout(yuv ITheoraYUVbuffer) error
+
+	Close() error
 }
 
 /* Exceptions */
 
 type errTheoraException struct{ r int }
 
+// ETheoraException matches any *Error which is a failure of libtheora
 var ETheoraException = errTheoraException{0}
 
 func (v errTheoraException) Error() string {
-	switch v.r {
-	case C.OC_FAULT:
-		return "Unspecified error"
-	case C.OC_EINVAL:
-		return "General failure"
-	case C.OC_DISABLED:
-		return "Requested action is disabled"
-	case C.OC_BADHEADER:
-		return "Header packet was corrupt/invalid"
-	case C.OC_NOTFORMAT:
-		return "Packet is not a theora packet"
-	case C.OC_VERSION:
-		return "Bitstream version is not handled"
-	case C.OC_IMPL:
-		return "Feature or action not implemented"
-	case C.OC_BADPACKET:
-		return "Packet is corrupt"
-	case C.OC_NEWPACKET:
-		return "Packet is an (ignorable) unhandled extension"
-	case C.OC_DUPFRAME:
-		return "Packet is a dropped frame"
-	default:
-		return "Unspecified encoder error"
-	}
+	return ErrorCode(v.r).message()
 }
 
 type errTheoraDecException struct{}
@@ -259,6 +311,93 @@ func (v errTheoraDecBadPacketException) Error() string {
 	return "Packet is corrupt. Packet does not contain encoded video data"
 }
 
+type errTheoraDecNotReadyException struct{}
+
+var ETheoraDecNotReadyException = errTheoraDecNotReadyException{}
+
+func (v errTheoraDecNotReadyException) Error() string {
+	return "Decoder is not ready. Not all header packets were received"
+}
+
+type errTheoraNoStreamException struct{}
+
+var ETheoraNoStreamException = errTheoraNoStreamException{}
+
+func (v errTheoraNoStreamException) Error() string {
+	return "No theora stream found"
+}
+
+type errTheoraNotSeekableException struct{}
+
+var ETheoraNotSeekableException = errTheoraNotSeekableException{}
+
+func (v errTheoraNotSeekableException) Error() string {
+	return "Stream is not seekable"
+}
+
+type errTheoraInvalidCommentException struct{}
+
+var ETheoraInvalidCommentException = errTheoraInvalidCommentException{}
+
+func (v errTheoraInvalidCommentException) Error() string {
+	return "Invalid comment. The field name must be printable ASCII without '=', the value must be UTF-8 without NUL"
+}
+
+type errTheoraInvalidPictureException struct{}
+
+var ETheoraInvalidPictureException = errTheoraInvalidPictureException{}
+
+func (v errTheoraInvalidPictureException) Error() string {
+	return "Invalid picture. Unsupported image format or corrupt picture block"
+}
+
+type errTheoraConvertException struct{}
+
+var ETheoraConvertException = errTheoraConvertException{}
+
+func (v errTheoraConvertException) Error() string {
+	return "Can not convert the image. Unsupported chroma format or the picture does not fit into the frame"
+}
+
+// errTheoraInvalidConfigException describes a wrong field of an
+// EncoderConfig
+type errTheoraInvalidConfigException struct{ field, reason string }
+
+var ETheoraInvalidConfigException = errTheoraInvalidConfigException{}
+
+func (v errTheoraInvalidConfigException) Error() string {
+	if len(v.field) == 0 {
+		return "Invalid encoder config"
+	}
+	return fmt.Sprintf("Invalid encoder config. %s: %s", v.field, v.reason)
+}
+
+// Is matches any config error with ETheoraInvalidConfigException
+func (v errTheoraInvalidConfigException) Is(target error) bool {
+	return target == ETheoraInvalidConfigException
+}
+
+// errTheoraLeakException lists the wrappers found by CheckLeaks
+type errTheoraLeakException struct{ live, leaked string }
+
+var ETheoraLeakException = errTheoraLeakException{}
+
+func (v errTheoraLeakException) Error() string {
+	res := "C memory leak"
+	if len(v.live) > 0 {
+		res += fmt.Sprintf(". Live: %s", v.live)
+	}
+	if len(v.leaked) > 0 {
+		res += fmt.Sprintf(". Not closed: %s", v.leaked)
+	}
+	return res
+}
+
+// Is matches any leak error with ETheoraLeakException
+func (v errTheoraLeakException) Is(target error) bool {
+	return target == ETheoraLeakException
+}
+
 type errTheoraEncException struct{}
 
 var ETheoraEncException = errTheoraEncException{}
@@ -335,8 +474,10 @@ func NewTheoraComment() (ITheoraComment, error) {
 		return nil, errTheoraOutOfMemory{err}
 	}
 	value.fValue = (*C.theora_comment)(mem)
+	trackAlloc(allocTheoraComment)
 	runtime.SetFinalizer(value, func(a *TheoraComment) {
 		if a.fValue != nil {
+			trackFinalized(allocTheoraComment)
 			a.Done()
 		}
 	})
@@ -356,37 +497,87 @@ func (v *TheoraComment) Done() {
 		C.theora_comment_clear(v.Ref())
 		C.free(unsafe.Pointer(v.fValue))
 		v.fValue = nil
+		trackFree(allocTheoraComment)
 	}
 }
 
+// Close releases the comment. It is safe to call Close more than once
+func (v *TheoraComment) Close() error {
+	v.Done()
+	return nil
+}
+
 func (v *TheoraComment) GetVendor() string {
 	return C.GoString(v.Ref().vendor)
 }
 
+// SetVendor replaces the vendor string. Note that the encoder writes
+// the vendor string of the library into the comment header
 func (v *TheoraComment) SetVendor(s string) {
-	panic("not implemented") // TODO: Implement
+	if v.Ref().vendor != nil {
+		C.free(unsafe.Pointer(v.Ref().vendor))
+	}
+	v.Ref().vendor = C.CString(s)
 }
 
+// Add appends the comment in the form "TAG=value". A comment breaking
+// the Vorbis comment rules (the field name, UTF-8 of the value) is
+// dropped, use AddChecked to get the error
 func (v *TheoraComment) Add(comment string) {
+	_ = v.AddChecked(comment)
+}
+
+// AddTag appends the comment "tag=value". An invalid field name or a
+// value which is not UTF-8 is dropped, use AddTagChecked to get the
+// error
+func (v *TheoraComment) AddTag(tag string, value string) {
+	_ = v.AddTagChecked(tag, value)
+}
+
+// AddChecked is like Add but returns ETheoraInvalidCommentException
+// for an invalid comment, which is not added
+func (v *TheoraComment) AddChecked(comment string) error {
+	tag, value, ok := splitComment(comment)
+	if !ok {
+		return ETheoraInvalidCommentException
+	}
+	if err := validateComment(tag, value); err != nil {
+		return err
+	}
 	cs := C.CString(comment)
 	defer C.free(unsafe.Pointer(cs))
 	C.theora_comment_add(v.Ref(), cs)
+	return nil
 }
 
-func (v *TheoraComment) AddTag(tag string, value string) {
+// AddTagChecked is like AddTag but returns
+// ETheoraInvalidCommentException for an invalid comment, which is not
+// added
+func (v *TheoraComment) AddTagChecked(tag string, value string) error {
+	if err := validateComment(tag, value); err != nil {
+		return err
+	}
 	ctag := C.CString(tag)
 	defer C.free(unsafe.Pointer(ctag))
 	cvalue := C.CString(value)
 	defer C.free(unsafe.Pointer(cvalue))
 	C.theora_comment_add_tag(v.Ref(), ctag, cvalue)
+	return nil
 }
 
 func (v *TheoraComment) TagsCount() int {
-	panic("not implemented") // TODO: Implement
+	return int(v.Ref().comments)
 }
 
+// GetTag returns the whole comment at index in the form "TAG=value"
 func (v *TheoraComment) GetTag(index int) string {
-	panic("not implemented") // TODO: Implement
+	if index < 0 || index >= v.TagsCount() {
+		return ""
+	}
+	cnt := v.TagsCount()
+	comments := unsafe.Slice(v.Ref().user_comments, cnt)
+	lengths := unsafe.Slice(v.Ref().comment_lengths, cnt)
+	return C.GoStringN(comments[index], lengths[index])
 }
 
 func (v *TheoraComment) Query(tag string, index int) string {
@@ -405,6 +596,12 @@ func (v *TheoraComment) QueryCount(tag string) int {
 
 type TheoraInfo struct {
 	fValue *C.theora_info
+	// The states using the info and the deferred Done
+	fLock   sync.Mutex
+	fRefs   int
+	fClosed bool
+	// Setup of the decoder parsed from the headers
+	fSetup IThSetupInfo
 }
 
 func NewTheoraInfo() (ITheoraInfo, error) {
@@ -415,7 +612,11 @@ func NewTheoraInfo() (ITheoraInfo, error) {
 		return nil, errTheoraOutOfMemory{err}
 	}
 	value.fValue = (*C.theora_info)(mem)
+	trackAlloc(allocTheoraInfo)
 	runtime.SetFinalizer(value, func(a *TheoraInfo) {
+		if a.fValue != nil && !a.fClosed {
+			trackFinalized(allocTheoraInfo)
+		}
 		a.Done()
 	})
 	return value, nil
@@ -429,11 +630,81 @@ func (v *TheoraInfo) Init() {
 	C.theora_info_init(v.Ref())
 }
 
+// Done releases the info. If the info is used by a state, the memory
+// is released when the last of the states is done
 func (v *TheoraInfo) Done() {
-	if v.fValue != nil {
+	v.fLock.Lock()
+	defer v.fLock.Unlock()
+	v.fClosed = true
+	v.free()
+}
+
+// Close releases the info like Done. It is safe to call Close more
+// than once
+func (v *TheoraInfo) Close() error {
+	v.Done()
+	return nil
+}
+
+func (v *TheoraInfo) free() {
+	if v.fValue != nil && v.fRefs == 0 {
+		if v.fSetup != nil {
+			v.fSetup.Close()
+			v.fSetup = nil
+		}
 		C.theora_info_clear(v.fValue)
 		C.free(unsafe.Pointer(v.fValue))
 		v.fValue = nil
+		trackFree(allocTheoraInfo)
+	}
+}
+
+// cloneTheoraInfo returns a copy of the fields of inf. The setup of
+// the decoder is not copied
+func cloneTheoraInfo(inf ITheoraInfo) (ITheoraInfo, error) {
+	res, err := NewTheoraInfo()
+	if err != nil {
+		return nil, err
+	}
+	*res.Ref() = *inf.Ref()
+	res.Ref().codec_setup = nil
+	return res, nil
+}
+
+// retain marks the info as used by a state
+func (v *TheoraInfo) retain() {
+	v.fLock.Lock()
+	defer v.fLock.Unlock()
+	v.fRefs++
+}
+
+// setSetup keeps the decoder setup parsed from the headers of the
+// stream described by the info
+func (v *TheoraInfo) setSetup(setup IThSetupInfo) {
+	v.fLock.Lock()
+	defer v.fLock.Unlock()
+	if v.fSetup != nil {
+		v.fSetup.Close()
+	}
+	v.fSetup = setup
+}
+
+// setup returns the decoder setup kept by setSetup or nil
+func (v *TheoraInfo) setup() IThSetupInfo {
+	v.fLock.Lock()
+	defer v.fLock.Unlock()
+	return v.fSetup
+}
+
+// release is called by the state which retained the info when it is
+// done. The memory of the closed info is released with the last
+// reference
+func (v *TheoraInfo) release() {
+	v.fLock.Lock()
+	defer v.fLock.Unlock()
+	v.fRefs--
+	if v.fClosed {
+		v.free()
 	}
 }
 
@@ -581,9 +852,9 @@ func (v *TheoraInfo) SetColorspace(AValue Colorspace) {
 
 func (v *TheoraInfo) SetDropFrames(AValue bool) {
 	if AValue {
-		v.fValue.dropframes_p = C.int(0)
-	} else {
 		v.fValue.dropframes_p = C.int(1)
+	} else {
+		v.fValue.dropframes_p = C.int(0)
 	}
 }
 
@@ -609,9 +880,9 @@ func (v *TheoraInfo) SetHeight(AValue int) {
 
 func (v *TheoraInfo) SetKeyframeAuto(AValue bool) {
 	if AValue {
-		v.fValue.keyframe_auto_p = C.int(0)
-	} else {
 		v.fValue.keyframe_auto_p = C.int(1)
+	} else {
+		v.fValue.keyframe_auto_p = C.int(0)
 	}
 }
 
@@ -688,12 +959,24 @@ func (v *TheoraInfo) GranuleShift() int {
 
 /* TheoraState */
 
+// granuleCodec converts the granule positions of the stream. It is
+// the encoder or the decoder context the state belongs to
+type granuleCodec interface {
+	GranuleFrame(granulepos int64) int64
+	GranuleTime(granulepos int64) float64
+}
+
 type TheoraState struct {
 	fValue *C.theora_state
 	info   ITheoraInfo
+	fCodec granuleCodec
 }
 
 func NewTheoraState() (ITheoraState, error) {
+	return newTheoraState()
+}
+
+func newTheoraState() (*TheoraState, error) {
 	value := new(TheoraState)
 
 	mem, err := C.calloc(1, (C.size_t)(C.size_of_struct_theora_state()))
@@ -701,7 +984,11 @@ func NewTheoraState() (ITheoraState, error) {
 		return nil, errTheoraOutOfMemory{err}
 	}
 	value.fValue = (*C.theora_state)(mem)
+	trackAlloc(allocTheoraState)
 	runtime.SetFinalizer(value, func(a *TheoraState) {
+		if a.fValue != nil {
+			trackFinalized(allocTheoraState)
+		}
 		a.Done()
 	})
 	return value, nil
@@ -711,20 +998,43 @@ func (v *TheoraState) Ref() *C.theora_state {
 	return v.fValue
 }
 
+// Init attaches the info to the state. The state holds a reference
+// to the info, so the info is not released before the state is done
 func (v *TheoraState) Init(inf ITheoraInfo) {
+	v.releaseInfo()
 	v.Ref().i = inf.Ref()
 	v.info = inf
+	if ti, ok := inf.(*TheoraInfo); ok {
+		ti.retain()
+	}
+}
+
+func (v *TheoraState) releaseInfo() {
+	if ti, ok := v.info.(*TheoraInfo); ok {
+		ti.release()
+	}
+	v.info = nil
 }
 
+// Done releases the state. The encoder or the decoder context is
+// released by its owner, the info by the caller
 func (v *TheoraState) Done() {
 	if v.fValue != nil {
-		v.fValue.i = nil
-		C.theora_clear(v.fValue)
 		C.free(unsafe.Pointer(v.fValue))
 		v.fValue = nil
+		v.fCodec = nil
+		trackFree(allocTheoraState)
+		v.releaseInfo()
 	}
 }
 
+// Close releases the state and its reference to the info. It is safe
+// to call Close more than once
+func (v *TheoraState) Close() error {
+	v.Done()
+	return nil
+}
+
 func (v *TheoraState) Info() ITheoraInfo {
 	return v.info
 }
@@ -737,22 +1047,39 @@ func (v *TheoraState) SetGranulePos(value int64) {
 	v.Ref().granulepos = C.int64_t(value)
 }
 
+// GranuleFrame returns the frame number of the granule position or
+// -1 if the encoder or the decoder is not initialized yet
 func (v *TheoraState) GranuleFrame(granulepos int64) int64 {
-	return int64(C.theora_granule_frame(v.Ref(), C.int64_t(granulepos)))
+	if v.fCodec == nil {
+		return -1
+	}
+	return v.fCodec.GranuleFrame(granulepos)
 }
 
+// GranuleTime returns the end time of the frame in seconds or -1 if
+// the encoder or the decoder is not initialized yet
 func (v *TheoraState) GranuleTime(granulepos int64) float64 {
-	return float64(C.theora_granule_time(v.Ref(), C.int64_t(granulepos)))
+	if v.fCodec == nil {
+		return -1
+	}
+	return v.fCodec.GranuleTime(granulepos)
 }
 
-/* TheoraYUVbuffer */
+/* TheoraYUVbuffer.
+   The planes are always in C memory, so the buffer can be passed to
+   libtheora without breaking the cgo pointer rules. The planes are
+   either allocated by the buffer itself (Alloc, SetYData, Clone, the
+   conversions) and released by Done, or owned by the decoder after
+   YUVout and valid until the next frame */
 
 type TheoraYUVbuffer struct {
-	fValue   *C.yuv_buffer
-	fyData   []byte
-	fuData   []byte
-	fvData   []byte
-	fOwnData bool
+	fValue *C.yuv_buffer
+	// Planes allocated by the buffer and their sizes
+	fPlanes [3]unsafe.Pointer
+	fSizes  [3]int
+	// The allocation failures of SetYData, SetUData and SetVData,
+	// reported by Err
+	fErrs [3]error
 }
 
 func NewTheoraYUVbuffer() (ITheoraYUVbuffer, error) {
@@ -763,7 +1090,11 @@ func NewTheoraYUVbuffer() (ITheoraYUVbuffer, error) {
 		return nil, errTheoraOutOfMemory{err}
 	}
 	value.fValue = (*C.yuv_buffer)(mem)
+	trackAlloc(allocTheoraYUVbuffer)
 	runtime.SetFinalizer(value, func(a *TheoraYUVbuffer) {
+		if a.fValue != nil {
+			trackFinalized(allocTheoraYUVbuffer)
+		}
 		a.Done()
 	})
 	return value, nil
@@ -773,27 +1104,118 @@ func (v *TheoraYUVbuffer) Ref() *C.yuv_buffer {
 	return v.fValue
 }
 
+// planeRefs returns the C pointers of the planes
+func (v *TheoraYUVbuffer) planeRefs() [3]**C.uchar {
+	return [3]**C.uchar{&v.fValue.y, &v.fValue.u, &v.fValue.v}
+}
+
+// freePlane releases the plane i if it is allocated by the buffer
+func (v *TheoraYUVbuffer) freePlane(i int) {
+	if v.fPlanes[i] == nil {
+		return
+	}
+	if ref := v.planeRefs()[i]; unsafe.Pointer(*ref) == v.fPlanes[i] {
+		*ref = nil
+	}
+	C.free(v.fPlanes[i])
+	v.fPlanes[i] = nil
+	v.fSizes[i] = 0
+}
+
+// allocPlane makes the plane i a zeroed block of size bytes in C
+// memory. The block is reused if it has the same size
+func (v *TheoraYUVbuffer) allocPlane(i, size int) ([]byte, error) {
+	if v.fPlanes[i] == nil || v.fSizes[i] != size {
+		v.freePlane(i)
+		mem, err := C.calloc(C.size_t(max(size, 1)), 1)
+		if mem == nil {
+			return nil, errTheoraOutOfMemory{err}
+		}
+		v.fPlanes[i] = mem
+		v.fSizes[i] = size
+	}
+	*v.planeRefs()[i] = (*C.uchar)(v.fPlanes[i])
+	return unsafe.Slice((*byte)(v.fPlanes[i]), size), nil
+}
+
+// planeData returns the plane i as a slice over its C memory
+func (v *TheoraYUVbuffer) planeData(i int) []byte {
+	ref := *v.planeRefs()[i]
+	if ref == nil {
+		return nil
+	}
+	if unsafe.Pointer(ref) == v.fPlanes[i] {
+		return unsafe.Slice((*byte)(v.fPlanes[i]), v.fSizes[i])
+	}
+	stride, height := v.GetYStride(), v.GetYHeight()
+	if i > 0 {
+		stride, height = v.GetUVStride(), v.GetUVHeight()
+	}
+	if stride <= 0 || height <= 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(ref)), stride*height)
+}
+
+// Alloc allocates the zeroed planes in C memory for the given sizes.
+// The strides are set equal to the widths. The memory is released by
+// Done
+func (v *TheoraYUVbuffer) Alloc(ywidth, yheight, uvwidth, uvheight int) error {
+	err := v.reserve(ywidth, yheight, uvwidth, uvheight)
+	if err != nil {
+		return err
+	}
+	for i := range v.fPlanes {
+		clear(v.planeData(i))
+	}
+	return nil
+}
+
+// reserve makes the planes of the given sizes like Alloc, but the
+// content of the reused planes is left as is. It is used when every
+// byte of the planes is written next
+func (v *TheoraYUVbuffer) reserve(ywidth, yheight, uvwidth, uvheight int) error {
+	if ywidth <= 0 || yheight <= 0 || uvwidth <= 0 || uvheight <= 0 {
+		return newError("TheoraYUVbuffer.Alloc", C.OC_EINVAL)
+	}
+	v.SetYWidth(ywidth)
+	v.SetYHeight(yheight)
+	v.SetYStride(ywidth)
+	v.SetUVWidth(uvwidth)
+	v.SetUVHeight(uvheight)
+	v.SetUVStride(uvwidth)
+	for i := range v.fPlanes {
+		size := ywidth * yheight
+		if i > 0 {
+			size = uvwidth * uvheight
+		}
+		_, err := v.allocPlane(i, size)
+		if err != nil {
+			return err
+		}
+	}
+	v.fErrs = [3]error{}
+	return nil
+}
+
 func (v *TheoraYUVbuffer) Done() {
 	if v.fValue != nil {
-		if v.GetOwnData() {
-			if v.fValue.y != nil {
-				C.free(unsafe.Pointer(v.fValue.y))
-				v.fValue.y = nil
-			}
-			if v.fValue.u != nil {
-				C.free(unsafe.Pointer(v.fValue.u))
-				v.fValue.u = nil
-			}
-			if v.fValue.v != nil {
-				C.free(unsafe.Pointer(v.fValue.v))
-				v.fValue.v = nil
-			}
+		for i := range v.fPlanes {
+			v.freePlane(i)
 		}
 		C.free(unsafe.Pointer(v.fValue))
 		v.fValue = nil
+		trackFree(allocTheoraYUVbuffer)
 	}
 }
 
+// Close releases the buffer and its own planes. It is safe to call
+// Close more than once
+func (v *TheoraYUVbuffer) Close() error {
+	v.Done()
+	return nil
+}
+
 func (v *TheoraYUVbuffer) GetYWidth() int {
 	return int(v.fValue.y_width)
 }
@@ -842,205 +1264,214 @@ func (v *TheoraYUVbuffer) SetUVStride(value int) {
 	v.fValue.uv_stride = C.int(value)
 }
 
+// GetYData returns the Y plane as a slice over its C memory. The
+// slice is nil if the stride is negative, use Clone or ToYCbCr then.
+// The planes of the decoder are valid until the next frame
 func (v *TheoraYUVbuffer) GetYData() []byte {
-	return v.fyData
+	return v.planeData(0)
 }
 
+// SetYData copies value into the Y plane allocated by the buffer. If
+// the plane can not be allocated it is left unset and the failure is
+// reported by Err
 func (v *TheoraYUVbuffer) SetYData(value []byte) {
-	v.fyData = value
-	v.fValue.y = (*C.uchar)(OGG.Ptr(v.fyData[0:]))
+	v.setPlane(0, value)
 }
 
 func (v *TheoraYUVbuffer) GetUData() []byte {
-	return v.fuData
+	return v.planeData(1)
 }
 
 func (v *TheoraYUVbuffer) SetUData(value []byte) {
-	v.fuData = value
-	v.fValue.u = (*C.uchar)(OGG.Ptr(v.fuData[0:]))
+	v.setPlane(1, value)
 }
 
 func (v *TheoraYUVbuffer) GetVData() []byte {
-	return v.fvData
+	return v.planeData(2)
 }
 
 func (v *TheoraYUVbuffer) SetVData(value []byte) {
-	v.fvData = value
-	v.fValue.v = (*C.uchar)(OGG.Ptr(v.fvData[0:]))
+	v.setPlane(2, value)
 }
 
+func (v *TheoraYUVbuffer) setPlane(i int, value []byte) {
+	data, err := v.allocPlane(i, len(value))
+	v.fErrs[i] = err
+	if err != nil {
+		*v.planeRefs()[i] = nil
+		return
+	}
+	copy(data, value)
+}
+
+// Err returns the allocation failure of the last SetYData, SetUData or
+// SetVData of a plane. A successful Alloc or set of the same plane
+// clears it. YUVin refuses the buffer with the error
+func (v *TheoraYUVbuffer) Err() error {
+	return errors.Join(v.fErrs[:]...)
+}
+
+// GetOwnData tells whether the planes are allocated by the buffer
 func (v *TheoraYUVbuffer) GetOwnData() bool {
-	return v.fOwnData
+	for i, ref := range v.planeRefs() {
+		if *ref == nil || unsafe.Pointer(*ref) != v.fPlanes[i] {
+			return false
+		}
+	}
+	return true
 }
 
+// SetOwnData does nothing.
+//
+// Deprecated: the buffer keeps track of the planes it has allocated
+// and releases only them
 func (v *TheoraYUVbuffer) SetOwnData(value bool) {
-	v.fOwnData = value
 }
 
-func (v *TheoraYUVbuffer) ConvertFromRasterImage(chroma_format image.YCbCrSubsampleRatio, aData image.Image) bool {
+func copyPlane(src *C.uchar, stride, width, height int) []byte {
+	dst := make([]byte, width*height)
+	copyPlaneTo(dst, src, stride, width, height)
+	return dst
+}
 
-	/* increadable awfully */
-	nrgb := func(v color.Color) (uint32, uint32, uint32) {
-		c := color.NRGBAModel.Convert(v).(color.NRGBA)
-		return uint32(c.R), uint32(c.G), uint32(c.B)
+// copyPlaneTo copies the rows of the plane src into dst packed with
+// the stride equal to the width
+func copyPlaneTo(dst []byte, src *C.uchar, stride, width, height int) {
+	for r := 0; r < height; r++ {
+		row := unsafe.Add(unsafe.Pointer(src), r*stride)
+		copy(dst[r*width:(r+1)*width], unsafe.Slice((*byte)(row), width))
 	}
+}
 
-	clamp := func(v uint32) byte {
-		if v > 255 {
-			return 255
-		}
-		return byte(v)
+// Clone makes a deep copy of the buffer. The planes of the copy are
+// stored top-down with the stride equal to the plane width, so the
+// buffer stays valid after the decoder has produced the next frame
+func (v *TheoraYUVbuffer) Clone() (ITheoraYUVbuffer, error) {
+	res, err := NewTheoraYUVbuffer()
+	if err != nil {
+		return nil, err
 	}
-
-	booltoint := func(v bool) int {
-		if v {
-			return 1
-		}
-		return 0
+	err = v.CopyTo(res)
+	if err != nil {
+		res.Done()
+		return nil, err
 	}
+	return res, nil
+}
 
-	if !(chroma_format == image.YCbCrSubsampleRatio444 ||
-		chroma_format == image.YCbCrSubsampleRatio422 ||
-		chroma_format == image.YCbCrSubsampleRatio420) {
-		return false
+// CopyTo copies the picture into dst like Clone. The planes of dst
+// are reused if they have the same sizes
+func (v *TheoraYUVbuffer) CopyTo(dst ITheoraYUVbuffer) error {
+	res, ok := dst.(*TheoraYUVbuffer)
+	if !ok || res == v {
+		return newError("TheoraYUVbuffer.CopyTo", C.OC_EINVAL)
 	}
-
-	h := aData.Bounds().Dy()
-	w := aData.Bounds().Dx()
-
-	// Must hold: yuv_w >= w
-	var yuv_w int = int(uint32(w+15) & ^uint32(0xf))
-	// Must hold: yuv_h >= h
-	var yuv_h int = int(uint32(h+15) & ^uint32(0xf))
-
-	v.SetYWidth(yuv_w)
-	v.SetYHeight(yuv_h)
-	v.SetYStride(yuv_w)
-
-	if chroma_format == image.YCbCrSubsampleRatio444 {
-		v.SetUVWidth(yuv_w)
-	} else {
-		v.SetUVWidth(yuv_w >> 1)
-	}
-	v.SetUVStride(v.GetUVWidth())
-
-	if chroma_format == image.YCbCrSubsampleRatio420 {
-		v.SetUVHeight(yuv_h >> 1)
-	} else {
-		v.SetUVHeight(yuv_h)
-	}
-
-	yuv_y := make([]byte, v.GetYStride()*v.GetYHeight())
-	yuv_u := make([]byte, v.GetUVStride()*v.GetUVHeight())
-	yuv_v := make([]byte, v.GetUVStride()*v.GetUVHeight())
-
-	v.SetYData(yuv_y)
-	v.SetUData(yuv_u)
-	v.SetVData(yuv_v)
-
-	if chroma_format == image.YCbCrSubsampleRatio420 {
-		y := 0
-		for y < h {
-			y1 := y + booltoint((y+1) < h)
-			x := 0
-			for x < w {
-				x1 := x + booltoint((x+1) < w)
-				r0, g0, b0 := nrgb(aData.At(x, y))
-				r1, g1, b1 := nrgb(aData.At(x1, y))
-				r2, g2, b2 := nrgb(aData.At(x, y1))
-				r3, g3, b3 := nrgb(aData.At(x1, y1))
-
-				yuv_y[x+y*yuv_w] = clamp((65481*r0 + 128553*g0 + 24966*b0 + 4207500) / 255000)
-				yuv_y[x1+y*yuv_w] = clamp((65481*r1 + 128553*g1 + 24966*b1 + 4207500) / 255000)
-				yuv_y[x+y1*yuv_w] = clamp((65481*r2 + 128553*g2 + 24966*b2 + 4207500) / 255000)
-				yuv_y[x1+y1*yuv_w] = clamp((65481*r3 + 128553*g3 + 24966*b3 + 4207500) / 255000)
-
-				yuv_u[(x>>1)+(y>>1)*v.GetUVStride()] =
-					clamp(((29032005-33488*r0-65744*g0+99232*b0)/4 +
-						(29032005-33488*r1-65744*g1+99232*b1)/4 +
-						(29032005-33488*r2-65744*g2+99232*b2)/4 +
-						(29032005-33488*r3-65744*g3+99232*b3)/4) / 225930)
-				yuv_v[(x>>1)+(y>>1)*v.GetUVStride()] =
-					clamp(((157024*r0-131488*g0-25536*b0+45940035)/4 +
-						(157024*r1-131488*g1-25536*b1+45940035)/4 +
-						(157024*r2-131488*g2-25536*b2+45940035)/4 +
-						(157024*r3-131488*g3-25536*b3+45940035)/4) / 357510)
-				x += 2
-			}
-			y += 2
-		}
-	} else if chroma_format == image.YCbCrSubsampleRatio444 {
-		for y := 0; y < h; y++ {
-			for x := 0; x < w; x++ {
-				r0, g0, b0 := nrgb(aData.At(x, y))
-
-				yuv_y[x+y*yuv_w] = clamp((65481*r0 + 128553*g0 + 24966*b0 + 4207500) / 255000)
-				yuv_u[x+y*yuv_w] = clamp((29032005 - 33488*r0 - 65744*g0 + 99232*b0) / 225930)
-				yuv_v[x+y*yuv_w] = clamp((157024*r0 - 131488*g0 - 25536*b0 + 45940035) / 357510)
-			}
-		}
-	} else { /* TH_PF_422 */
-		y := 0
-		for y < h {
-			x := 0
-			for x < w {
-				x1 := x + booltoint((x+1) < w)
-				r0, g0, b0 := nrgb(aData.At(x, y))
-				r1, g1, b1 := nrgb(aData.At(x1, y))
-
-				yuv_y[x+y*yuv_w] = clamp((65481*r0 + 128553*g0 + 24966*b0 + 4207500) / 255000)
-				yuv_y[x1+y*yuv_w] = clamp((65481*r1 + 128553*g1 + 24966*b1 + 4207500) / 255000)
-
-				yuv_u[(x>>1)+y*v.GetUVStride()] =
-					clamp(((29032005-33488*r0-65744*g0+99232*b0)/2 +
-						(29032005-33488*r1-65744*g1+99232*b1)/2) / 225930)
-				yuv_v[(x>>1)+y*v.GetUVStride()] =
-					clamp(((157024*r0-131488*g0-25536*b0+45940035)/2 +
-						(157024*r1-131488*g1-25536*b1+45940035)/2) / 357510)
-				x += 2
-			}
-			y++
-		}
+	err := res.reserve(v.GetYWidth(), v.GetYHeight(), v.GetUVWidth(), v.GetUVHeight())
+	if err != nil {
+		return err
 	}
-	return true
+	copyPlaneTo(res.planeData(0), v.fValue.y, v.GetYStride(), v.GetYWidth(), v.GetYHeight())
+	copyPlaneTo(res.planeData(1), v.fValue.u, v.GetUVStride(), v.GetUVWidth(), v.GetUVHeight())
+	copyPlaneTo(res.planeData(2), v.fValue.v, v.GetUVStride(), v.GetUVWidth(), v.GetUVHeight())
+	return nil
 }
 
-/* TheoraEncoder */
+/* TheoraEncoder.
+   The legacy encoder works over the encoder context of the th_* API
+   the same way the compatibility layer of libtheora does */
 
 type TheoraEncoder struct {
-	fState  ITheoraState
+	fState *TheoraState
+	fEnc   IThEncoder
+	// The planes of the frame being encoded, a view of the legacy
+	// buffer
+	fFrame *ThYCbCrBuffer
+	// The number of the header packets made and the end of the
+	// encoding
+	fHeaders int
+	fDone    bool
+
 	foggs   OGG.IOGGStreamState
 	fwriter io.Writer
+	fSerial int32
+	fFrames int64
+	fPass   twoPassState
+
+	fKFForce       int
+	fForceKF       bool
+	fChapterFrames []int64
+
+	fHeadersSaved bool
+	fSkeleton     *skeletonWriter
+	fPool         IYUVBufferPool
 }
 
 func NewTheoraEncoder(inf ITheoraInfo, str io.Writer) (ITheoraEncoder, error) {
 	value := new(TheoraEncoder)
-	var err error
-	value.fState, err = NewTheoraState()
+	err := value.init(inf)
 	if err != nil {
+		value.release()
 		return nil, err
 	}
-	value.fState.Init(inf)
-	R := int(C.theora_encode_init(value.fState.Ref(), inf.Ref()))
-	if R != 0 {
-		return nil, errTheoraException{R}
-	}
-	value.foggs, err = OGG.NewStream(int32(rand.Int63n(time.Now().UnixMilli())))
+	value.fSerial = int32(rand.Int63n(time.Now().UnixMilli()))
+	value.foggs, err = OGG.NewStream(value.fSerial)
 	if err != nil {
+		value.release()
 		return nil, err
 	}
 	value.fwriter = str
 
 	runtime.SetFinalizer(value, func(a *TheoraEncoder) {
-		if value.fState != nil {
-			value.fState.Done()
-			value.fState = nil
+		if a.fState != nil {
+			trackFinalized(allocTheoraState)
 		}
+		a.release()
 	})
 	return value, nil
 }
 
+// init makes the encoder context for the legacy info like
+// theora_encode_init does
+func (v *TheoraEncoder) init(inf ITheoraInfo) error {
+	var err error
+	v.fState, err = newTheoraState()
+	if err != nil {
+		return err
+	}
+	v.fState.Init(inf)
+	ti, err := NewThInfo()
+	if err != nil {
+		return err
+	}
+	defer ti.Close()
+	ti.Init()
+	ti.AssignFromTheoraInfo(inf)
+	v.fEnc, err = NewThEncoder(ti)
+	if err != nil {
+		return newError("NewTheoraEncoder", codeOf(err))
+	}
+	v.fState.fCodec = v.fEnc
+	v.fFrame, err = newThYCbCrBuffer()
+	if err != nil {
+		return err
+	}
+
+	/* the keyframe distance of the legacy info depends on
+	   keyframe_auto_p */
+	v.fKFForce = inf.GetKeyframeFrequency()
+	if inf.GetKeyframeAuto() {
+		v.fKFForce = inf.GetKeyframeFrequencyForce()
+	}
+	if res, err := v.setKeyframeFrequencyForce(v.fKFForce); err == nil {
+		v.fKFForce = res
+	}
+	return nil
+}
+
 func (v *TheoraEncoder) State() ITheoraState {
+	if v.fState == nil {
+		return nil
+	}
 	return v.fState
 }
 
@@ -1048,31 +1479,75 @@ func (v *TheoraEncoder) Stream() OGG.IOGGStreamState {
 	return v.foggs
 }
 
-func (v *TheoraEncoder) Header(op OGG.IOGGPacket) error {
-	R := int(C.theora_encode_header(v.fState.Ref(), (*C.ogg_packet)(unsafe.Pointer(op.Ref()))))
-	if R != 0 {
-		return errTheoraException{R}
+// SerialNo returns the serial number of the ogg stream
+func (v *TheoraEncoder) SerialNo() int32 {
+	return v.fSerial
+}
+
+// SetSerialNo replaces the random serial number of the ogg stream,
+// so the same input and settings give the same output. Must be
+// called before the headers are saved
+func (v *TheoraEncoder) SetSerialNo(value int32) error {
+	if v.fHeadersSaved {
+		return newError("TheoraEncoder.SetSerialNo", C.OC_EINVAL)
 	}
+	str, err := OGG.NewStream(value)
+	if err != nil {
+		return err
+	}
+	v.foggs.Done()
+	v.foggs = str
+	v.fSerial = value
 	return nil
 }
 
-func (v *TheoraEncoder) PacketOut(last_p bool, op OGG.IOGGPacket) error {
-	var lp C.int
-	if last_p {
-		lp = 1
-	} else {
-		lp = 0
+// flushHeader makes the header packet number index. The headers are
+// made in the order of the stream, so Header, Comment and Tables must
+// be called in this order
+func (v *TheoraEncoder) flushHeader(name string, index int, tc ITheoraComment, op OGG.IOGGPacket) error {
+	if v.fHeaders != index {
+		return newError(name, C.TH_EINVAL)
+	}
+	thc, err := NewThComment()
+	if err != nil {
+		return err
+	}
+	defer thc.Close()
+	thc.Init()
+	if tc != nil {
+		thc.AssignFromTheoraComment(tc)
+	}
+	ok, err := v.fEnc.FlushHeader(thc, op)
+	if err != nil {
+		return newError(name, codeOf(err))
+	}
+	if !ok {
+		return newError(name, C.TH_EINVAL)
 	}
+	v.fHeaders++
+	return nil
+}
 
-	R := int(C.theora_encode_packetout(v.fState.Ref(), lp, (*C.ogg_packet)(unsafe.Pointer(op.Ref()))))
-	if R == 1 {
+// Header makes the identification header packet
+func (v *TheoraEncoder) Header(op OGG.IOGGPacket) error {
+	return v.flushHeader("TheoraEncoder.Header", 0, nil, op)
+}
+
+func (v *TheoraEncoder) PacketOut(last_p bool, op OGG.IOGGPacket) error {
+	if v.fDone {
+		return newFrameError("TheoraEncoder.PacketOut", -1, v.fFrames-1, ETheoraEncCompletedException)
+	}
+	err := v.fEnc.PacketOut(last_p, op)
+	if err == nil {
+		v.fState.SetGranulePos(int64(oggPacketRef(op).granulepos))
+		v.fDone = oggPacketRef(op).e_o_s != 0
 		return nil
-	} else if R == -1 {
-		return ETheoraEncCompletedException
-	} else if R == 0 {
-		return ETheoraEncNotReadyException
+	} else if errors.Is(err, ETheoraEncNotPackReadyException) {
+		/* the encoder is done if there is nothing left to flush */
+		v.fDone = last_p
+		return newFrameError("TheoraEncoder.PacketOut", 0, v.fFrames-1, ETheoraEncNotReadyException)
 	} else {
-		return errTheoraException{R}
+		return newFrameError("TheoraEncoder.PacketOut", codeOf(err), v.fFrames-1, nil)
 	}
 }
 
@@ -1084,37 +1559,71 @@ func (v *TheoraEncoder) DoPacketOut(last_p bool) (OGG.IOGGPacket, error) {
 	return p, v.PacketOut(last_p, p)
 }
 
+// fitsFrame tells whether the Y plane of yuv has the frame size or the
+// picture size of inf, the sizes the encoder accepts
+func fitsFrame(inf ITheoraInfo, yuv ITheoraYUVbuffer) bool {
+	w, h := yuv.GetYWidth(), yuv.GetYHeight()
+	return (w == inf.GetFrameWidth() && h == inf.GetFrameHeight()) ||
+		(w == inf.GetWidth() && h == inf.GetHeight())
+}
+
+// YUVin feeds the frame to the encoder. A frame of other size than
+// the one of the stream is ETheoraEncDifferException, a frame after
+// the last one is ETheoraEncCompletedException
 func (v *TheoraEncoder) YUVin(yuv ITheoraYUVbuffer) error {
-	R := int(C.theora_encode_YUVin(v.fState.Ref(), yuv.Ref()))
-	if R == 0 {
+	if err := yuv.Err(); err != nil {
+		return wrapError("TheoraEncoder.YUVin", v.fFrames, err)
+	}
+	if !fitsFrame(v.fState.Info(), yuv) {
+		return newFrameError("TheoraEncoder.YUVin", -1, v.fFrames, ETheoraEncDifferException)
+	}
+	if v.fPass.pass == 2 {
+		err := v.feedSecondPass()
+		if err != nil {
+			return err
+		}
+	}
+	v.fFrame.assignView(yuv)
+	restore := v.beginKeyframe()
+	err := v.fEnc.YCbCrIn(v.fFrame)
+	v.endKeyframe(restore)
+	if err == nil {
+		v.fFrames++
+		if v.fPass.pass == 1 {
+			return v.collectFirstPass()
+		}
 		return nil
-	} else if R == -1 {
-		return ETheoraEncDifferException
+	}
+	R := codeOf(err)
+	if R == C.OC_EINVAL && v.fDone {
+		return newFrameError("TheoraEncoder.YUVin", R, v.fFrames, ETheoraEncCompletedException)
 	} else if R == C.OC_EINVAL {
-		return ETheoraEncNotReadyException
+		return newFrameError("TheoraEncoder.YUVin", R, v.fFrames, ETheoraEncNotReadyException)
 	} else {
-		return errTheoraException{R}
+		return newFrameError("TheoraEncoder.YUVin", R, v.fFrames, nil)
 	}
 }
 
+// Comment makes the comment header packet with the comments of tc.
+// The vendor string is the one of the library
 func (v *TheoraEncoder) Comment(tc ITheoraComment, op OGG.IOGGPacket) error {
-	R := int(C.theora_encode_comment(tc.Ref(), (*C.ogg_packet)(unsafe.Pointer(op.Ref()))))
-	if R != 0 {
-		return errTheoraException{R}
-	}
-	return nil
+	return v.flushHeader("TheoraEncoder.Comment", 1, tc, op)
 }
 
+// Tables makes the setup header packet
 func (v *TheoraEncoder) Tables(op OGG.IOGGPacket) error {
-	R := int(C.theora_encode_tables(v.fState.Ref(), (*C.ogg_packet)(unsafe.Pointer(op.Ref()))))
-	if R != 0 {
-		return errTheoraException{R}
+	return v.flushHeader("TheoraEncoder.Tables", 2, nil, op)
+}
+
+func (v *TheoraEncoder) control(req int, buf unsafe.Pointer, sz uintptr) int {
+	if buf == nil {
+		return v.fEnc.Control(req, nil)
 	}
-	return nil
+	return v.fEnc.Control(req, unsafe.Slice((*byte)(buf), sz))
 }
 
 func (v *TheoraEncoder) Control(req int, buf []byte) int {
-	panic("not implemented") // TODO: Implement
+	return v.fEnc.Control(req, buf)
 }
 
 func (v *TheoraEncoder) SaveDefHeadersToStream() error {
@@ -1122,14 +1631,26 @@ func (v *TheoraEncoder) SaveDefHeadersToStream() error {
 	if err != nil {
 		return err
 	}
+	defer tc.Close()
 	return v.SaveCustomHeadersToStream(tc)
 }
 
 func (v *TheoraEncoder) SaveCustomHeadersToStream(tc ITheoraComment) error {
+	return wrapError("TheoraEncoder.SaveCustomHeadersToStream", -1, v.saveHeaders(tc))
+}
+
+func (v *TheoraEncoder) saveHeaders(tc ITheoraComment) error {
 	op, err := OGG.NewPacket()
 	if err != nil {
 		return err
 	}
+	v.fHeadersSaved = true
+	if v.fSkeleton != nil {
+		err = v.fSkeleton.writeHead(v.fSerial)
+		if err != nil {
+			return err
+		}
+	}
 	err = v.Header(op)
 	if err != nil {
 		return err
@@ -1138,6 +1659,12 @@ func (v *TheoraEncoder) SaveCustomHeadersToStream(tc ITheoraComment) error {
 	if err != nil {
 		return err
 	}
+	if v.fSkeleton != nil {
+		err = v.fSkeleton.writeBones(v.fState.Info(), v.fSerial)
+		if err != nil {
+			return err
+		}
+	}
 	err = v.Comment(tc, op)
 	if err != nil {
 		return err
@@ -1158,10 +1685,23 @@ func (v *TheoraEncoder) SaveCustomHeadersToStream(tc ITheoraComment) error {
 	if err != nil {
 		return err
 	}
+	if v.fSkeleton != nil {
+		/* the skeleton ends after all the header pages */
+		err = v.Flush()
+		if err != nil {
+			return err
+		}
+		return v.fSkeleton.writeEOS()
+	}
 	return nil
 }
 
 func (v *TheoraEncoder) SaveYUVBufferToStream(buf ITheoraYUVbuffer, is_last bool) error {
+	frame := v.fFrames
+	return wrapError("TheoraEncoder.SaveYUVBufferToStream", frame, v.saveFrame(buf, is_last))
+}
+
+func (v *TheoraEncoder) saveFrame(buf ITheoraYUVbuffer, is_last bool) error {
 	err := v.YUVin(buf)
 	if err != nil {
 		return err
@@ -1170,6 +1710,14 @@ func (v *TheoraEncoder) SaveYUVBufferToStream(buf ITheoraYUVbuffer, is_last bool
 	if err != nil {
 		return err
 	}
+	if v.fSkeleton != nil && ThPacketIsKeyframe(op) {
+		/* the indexed keyframe starts a new page */
+		err = v.Flush()
+		if err != nil {
+			return err
+		}
+		v.fSkeleton.addKeyframe(v.fFrames-1, v.fState.Info())
+	}
 	err = v.foggs.SavePacketToStream(v.fwriter, op)
 	if err != nil {
 		return err
@@ -1177,77 +1725,321 @@ func (v *TheoraEncoder) SaveYUVBufferToStream(buf ITheoraYUVbuffer, is_last bool
 	return nil
 }
 
+// SaveImageToStream converts img with the info of the encoder and
+// encodes it. The frame buffer is taken from the buffer pool of the
+// encoder and returned after the frame is encoded, so the images of
+// the same size are encoded without allocations
+func (v *TheoraEncoder) SaveImageToStream(img image.Image, is_last bool) error {
+	inf := v.fState.Info()
+	if v.fPool == nil {
+		buf, err := NewTheoraYUVbuffer()
+		if err != nil {
+			return err
+		}
+		defer buf.Close()
+		if !buf.ConvertFromRasterImageInfo(inf, img) {
+			return newFrameError("TheoraEncoder.SaveImageToStream", 0, v.fFrames, ETheoraConvertException)
+		}
+		return v.SaveYUVBufferToStream(buf, is_last)
+	}
+	buf, err := v.fPool.ConvertFromRasterImageInfo(inf, img)
+	if err != nil {
+		return wrapError("TheoraEncoder.SaveImageToStream", v.fFrames, err)
+	}
+	/* the encoder copies the frame, the buffer can be reused at once */
+	defer v.fPool.Put(buf)
+	return v.SaveYUVBufferToStream(buf, is_last)
+}
+
+// SetBufferPool sets the pool of the frame buffers used by
+// SaveImageToStream. The pool is not closed with the encoder
+func (v *TheoraEncoder) SetBufferPool(pool IYUVBufferPool) {
+	v.fPool = pool
+}
+
+func (v *TheoraEncoder) BufferPool() IYUVBufferPool {
+	return v.fPool
+}
+
 func (v *TheoraEncoder) Flush() error {
-	return v.foggs.PagesFlushToStream(v.fwriter)
+	return wrapError("TheoraEncoder.Flush", -1, v.foggs.PagesFlushToStream(v.fwriter))
 }
 
-func (v *TheoraEncoder) Close() error {
-	err := v.Flush()
+// SaveEOSToStream ends the stream with an empty packet if no frame has
+// ended it, so the last page of the stream has the end-of-stream flag.
+// The empty packet repeats the last frame. A stream without frames
+// ends with it right after the headers, TheoraReader skips it there
+// and reads no frames
+func (v *TheoraEncoder) SaveEOSToStream() error {
+	if v.fState == nil || !v.fHeadersSaved {
+		return newError("TheoraEncoder.SaveEOSToStream", C.OC_EINVAL)
+	}
+	if v.fDone {
+		return nil
+	}
+	op, err := OGG.NewPacket()
 	if err != nil {
 		return err
 	}
+	p := oggPacketRef(op)
+	p.e_o_s = 1
+	p.granulepos = C.ogg_int64_t(max(v.fState.GetGranulePos(), 0))
+	p.packetno = C.ogg_int64_t(int64(v.fHeaders) + v.fFrames)
+	err = v.foggs.SavePacketToStream(v.fwriter, op)
+	if err != nil {
+		return wrapError("TheoraEncoder.SaveEOSToStream", v.fFrames, err)
+	}
+	v.fDone = true
+	return nil
+}
+
+// Close completes the stream: finishes the first pass, flushes the
+// pages and writes the skeleton index. Then the encoder is released,
+// even if an error occurs. It is safe to call Close more than once
+func (v *TheoraEncoder) Close() error {
+	if v.fState == nil {
+		return nil
+	}
+	var err error
+	if v.fPass.pass == 1 {
+		err = v.FinishFirstPass()
+	}
+	if err == nil {
+		err = v.Flush()
+	}
+	if err == nil && v.fSkeleton != nil {
+		err = v.fSkeleton.patch(v.fState.Info(), v.fSerial, v.fFrames)
+	}
+	v.release()
+	return wrapError("TheoraEncoder.Close", -1, err)
+}
+
+// release frees the stream, the encoder context and the state without
+// writing anything
+func (v *TheoraEncoder) release() {
+	if v.fSkeleton != nil {
+		v.fSkeleton.Done()
+		v.fSkeleton = nil
+	}
 	if v.foggs != nil {
 		v.foggs.Done()
 		v.foggs = nil
 	}
-	return nil
+	if v.fFrame != nil {
+		v.fFrame.Done()
+		v.fFrame = nil
+	}
+	if v.fState != nil {
+		v.fState.Done()
+		v.fState = nil
+	}
+	if v.fEnc != nil {
+		v.fEnc.Close()
+		v.fEnc = nil
+	}
 }
 
-/* TheoraDecoder */
+/* TheoraDecoder.
+   The legacy decoder parses the headers and decodes the frames with
+   the th_* API. The headers are copied into the legacy info and
+   comment, the decoder setup is kept by the info */
 
 type TheoraDecoder struct {
-	fState ITheoraState
-}
-
+	fState *TheoraState
+	fDec   IThDecoder
+	// The headers parsed so far
+	fInfo    IThInfo
+	fComment IThComment
+	fSetup   IThSetupInfo
+	// The planes of the last decoded frame
+	fFrame   *ThYCbCrBuffer
+	fHeaders int
+	fReady   bool
+	fStripe  stripeHandle
+}
+
+// NewTheoraDecoder creates a decoder over the info structure.
+// If the info keeps the setup of a stream decoded before, the decoder
+// is ready at once. Otherwise it is initialized after the three
+// header packets have been passed to Header
 func NewTheoraDecoder(inf ITheoraInfo) (ITheoraDecoder, error) {
 	value := new(TheoraDecoder)
-	var err error
-	value.fState, err = NewTheoraState()
+	err := value.alloc(inf)
+	if err == nil {
+		if ti, ok := inf.(*TheoraInfo); ok && ti.setup() != nil {
+			value.fInfo.AssignFromTheoraInfo(inf)
+			err = value.init(ti.setup())
+		}
+	}
 	if err != nil {
+		value.Close()
 		return nil, err
 	}
-	value.fState.Init(inf)
-	R := int(C.theora_decode_init(value.fState.Ref(), inf.Ref()))
-	if R != 0 {
-		return nil, errTheoraException{R}
-	}
 
 	runtime.SetFinalizer(value, func(a *TheoraDecoder) {
-		if value.fState != nil {
-			value.fState.Done()
-			value.fState = nil
+		if a.fState != nil {
+			trackFinalized(allocTheoraState)
 		}
+		a.Close()
 	})
 	return value, nil
 }
 
+func (v *TheoraDecoder) alloc(inf ITheoraInfo) error {
+	var err error
+	v.fState, err = newTheoraState()
+	if err != nil {
+		return err
+	}
+	v.fState.Init(inf)
+	v.fInfo, err = NewThInfo()
+	if err != nil {
+		return err
+	}
+	v.fInfo.Init()
+	v.fComment, err = NewThComment()
+	if err != nil {
+		return err
+	}
+	v.fComment.Init()
+	v.fSetup, err = NewThSetupInfo()
+	if err != nil {
+		return err
+	}
+	v.fFrame, err = newThYCbCrBuffer()
+	return err
+}
+
+// Close releases the decoder context, the state and its reference to
+// the info. It is safe to call Close more than once
+func (v *TheoraDecoder) Close() error {
+	if v.fState != nil {
+		v.fState.Done()
+		v.fState = nil
+	}
+	if v.fDec != nil {
+		v.fDec.Close()
+		v.fDec = nil
+	}
+	if v.fFrame != nil {
+		v.fFrame.Done()
+		v.fFrame = nil
+	}
+	if v.fSetup != nil {
+		v.fSetup.Close()
+		v.fSetup = nil
+	}
+	if v.fComment != nil {
+		v.fComment.Close()
+		v.fComment = nil
+	}
+	if v.fInfo != nil {
+		v.fInfo.Close()
+		v.fInfo = nil
+	}
+	v.fStripe.release()
+	v.fReady = false
+	return nil
+}
+
+func (v *TheoraDecoder) init(setup IThSetupInfo) error {
+	dec, err := NewThDecoder(v.fInfo, setup)
+	if err != nil {
+		return newError("TheoraDecoder.Header", codeOf(err))
+	}
+	v.fDec = dec
+	v.fState.fCodec = dec
+	v.fReady = true
+	return nil
+}
+
+func (v *TheoraDecoder) control(req int, buf unsafe.Pointer, sz uintptr) int {
+	if v.fDec == nil {
+		return C.TH_EFAULT
+	}
+	if buf == nil {
+		return v.fDec.Control(req, nil)
+	}
+	return v.fDec.Control(req, unsafe.Slice((*byte)(buf), sz))
+}
+
+func (v *TheoraDecoder) Control(req int, buf []byte) int {
+	if v.fDec == nil {
+		return C.TH_EFAULT
+	}
+	return v.fDec.Control(req, buf)
+}
+
 func (v *TheoraDecoder) State() ITheoraState {
+	if v.fState == nil {
+		return nil
+	}
 	return v.fState
 }
 
+func (v *TheoraDecoder) IsReady() bool {
+	return v.fReady
+}
+
+// Header parses the next header packet. The info header is copied
+// into the info of the decoder, the comments are appended to cc
 func (v *TheoraDecoder) Header(cc ITheoraComment, op OGG.IOGGPacket) error {
-	R := int(C.theora_decode_header(v.fState.Info().Ref(), cc.Ref(), (*C.ogg_packet)(unsafe.Pointer(op.Ref()))))
-	if R != 0 {
-		return errTheoraException{R}
+	if v.fHeaders >= 3 {
+		return newError("TheoraDecoder.Header", C.TH_EBADHEADER)
+	}
+	ok, err := ThDecodeHeaderIn(v.fInfo, v.fComment, v.fSetup, op)
+	if err != nil {
+		return newError("TheoraDecoder.Header", codeOf(err))
+	}
+	if !ok {
+		/* a video packet */
+		return newError("TheoraDecoder.Header", C.TH_EBADHEADER)
+	}
+	v.fHeaders++
+	switch v.fHeaders {
+	case 1:
+		v.fInfo.AssignToTheoraInfo(v.fState.Info())
+	case 2:
+		v.fComment.AssignToTheoraComment(cc)
+	case 3:
+		if !v.fReady {
+			err = v.init(v.fSetup)
+			if err != nil {
+				return err
+			}
+		}
+		/* the decoders created over the info later are ready at once */
+		if ti, ok := v.fState.Info().(*TheoraInfo); ok {
+			ti.setSetup(v.fSetup)
+			v.fSetup = nil
+		}
 	}
 	return nil
 }
 
 func (v *TheoraDecoder) PacketIn(op OGG.IOGGPacket) error {
-	R := int(C.theora_decode_packetin(v.fState.Ref(), (*C.ogg_packet)(unsafe.Pointer(op.Ref()))))
-	if R == 0 {
+	if !v.fReady {
+		return newFrameError("TheoraDecoder.PacketIn", 0, -1, ETheoraDecNotReadyException)
+	}
+	gp, err := v.fDec.PacketIn(op)
+	if err == nil {
+		v.fState.SetGranulePos(gp)
 		return nil
-	} else if R == C.OC_BADPACKET {
-		return ETheoraDecBadPacketException
+	} else if errors.Is(err, ETheoraDecBadPacketException) {
+		return newFrameError("TheoraDecoder.PacketIn", codeOf(err), -1, ETheoraDecBadPacketException)
 	} else {
-		return errTheoraException{R}
+		return newError("TheoraDecoder.PacketIn", codeOf(err))
 	}
 }
 
 func (v *TheoraDecoder) YUVout(yuv ITheoraYUVbuffer) error {
-	R := int(C.theora_decode_YUVout(v.fState.Ref(), yuv.Ref()))
-	if R != 0 {
-		return errTheoraException{R}
+	if !v.fReady {
+		return newFrameError("TheoraDecoder.YUVout", 0, -1, ETheoraDecNotReadyException)
+	}
+	err := v.fDec.YCbCrOut(v.fFrame)
+	if err != nil {
+		return newError("TheoraDecoder.YUVout", codeOf(err))
 	}
+	v.fFrame.assignToYUVbuffer(yuv)
 	return nil
 }
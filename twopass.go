@@ -0,0 +1,292 @@
+/* GoTheora
+Wrapper for Theora library
+
+Copyright (c) 2024 by Ilya Medvedkov
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+*/
+
+package gotheora
+
+/*
+#cgo CFLAGS: -I/usr/include
+#include "theora/theoraenc.h"
+#include "theora/theora.h"
+*/
+import "C"
+import (
+	"bytes"
+	"io"
+	"unsafe"
+)
+
+/* Two-pass rate control.
+   The first pass collects the metrics of each frame. The stream
+   starts with a summary header which is known only after the last
+   frame, so a placeholder of the same size is written first and
+   rewritten at the end of the pass. The second pass consumes the
+   metrics back before each frame */
+
+type twoPassState struct {
+	pass   int
+	out    io.Writer
+	outpos int64
+	seeker io.WriteSeeker
+	mem    []byte
+	in     io.Reader
+	inbuf  []byte
+}
+
+// ITheoraFrameSource is a re-openable sequence of frames used by the
+// two-pass helper
+type ITheoraFrameSource interface {
+	// Rewind restarts the sequence from the first frame
+	Rewind() error
+	// NextFrame returns the next frame of the sequence or io.EOF. The
+	// frame is owned by the source and is not closed by the helper.
+	// The helper reads one frame ahead, so the frame must stay valid
+	// until NextFrame is called twice more
+	NextFrame() (ITheoraYUVbuffer, error)
+}
+
+// TwoPassTarget sets the goal of the two-pass encoding. Either
+// the bitrate or the size of the resulting stream should be set
+type TwoPassTarget struct {
+	// Target bitrate, bits per second
+	Bitrate int
+	// Target size of the video stream, bytes. The bitrate is
+	// calculated from the duration of the stream after the first pass
+	FileSize int64
+}
+
+// Bitrate used in the first pass if no other value is known. The
+// collected metrics do not depend much on it
+const defTwoPassBitrate = 1000000
+
+// TwoPassOut returns the two-pass metrics produced by the encoder
+// since the previous call. The first call enables the first pass
+// and returns the summary placeholder
+func (v *TheoraEncoder) TwoPassOut() ([]byte, error) {
+	var buf *C.uchar
+	R := v.control(C.TH_ENCCTL_2PASS_OUT, unsafe.Pointer(&buf), unsafe.Sizeof(buf))
+	if R < 0 {
+		return nil, newError("TheoraEncoder.TwoPassOut", R)
+	}
+	if R == 0 || buf == nil {
+		return nil, nil
+	}
+	return C.GoBytes(unsafe.Pointer(buf), C.int(R)), nil
+}
+
+// TwoPassIn submits the two-pass metrics to the encoder and returns
+// the number of bytes consumed. With an empty buf it returns the
+// number of bytes the encoder needs before the next frame
+func (v *TheoraEncoder) TwoPassIn(buf []byte) (int, error) {
+	var R int
+	if len(buf) == 0 {
+		R = v.control(C.TH_ENCCTL_2PASS_IN, nil, 0)
+	} else {
+		R = v.control(C.TH_ENCCTL_2PASS_IN, unsafe.Pointer(&buf[0]), uintptr(len(buf)))
+	}
+	if R < 0 {
+		return 0, newError("TheoraEncoder.TwoPassIn", R)
+	}
+	return R, nil
+}
+
+// StartFirstPass switches the encoder into the first pass mode. The
+// metrics are written to stats. If stats is an io.WriteSeeker they
+// are written as they come, otherwise they are kept in memory and
+// written by FinishFirstPass. Must be called before the first frame
+func (v *TheoraEncoder) StartFirstPass(stats io.Writer) error {
+	if v.fFrames > 0 || v.fPass.pass != 0 {
+		return newError("TheoraEncoder.StartFirstPass", C.OC_EINVAL)
+	}
+	hdr, err := v.TwoPassOut()
+	if err != nil {
+		return err
+	}
+	v.fPass = twoPassState{pass: 1, out: stats}
+	if seeker, ok := stats.(io.WriteSeeker); ok {
+		v.fPass.outpos, err = seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		v.fPass.seeker = seeker
+		_, err = stats.Write(hdr)
+		return err
+	}
+	v.fPass.mem = append(v.fPass.mem, hdr...)
+	return nil
+}
+
+func (v *TheoraEncoder) collectFirstPass() error {
+	buf, err := v.TwoPassOut()
+	if err != nil {
+		return err
+	}
+	if v.fPass.seeker != nil {
+		_, err = v.fPass.out.Write(buf)
+		return err
+	}
+	v.fPass.mem = append(v.fPass.mem, buf...)
+	return nil
+}
+
+// FinishFirstPass writes the final summary of the metrics. It is
+// called by Close automatically
+func (v *TheoraEncoder) FinishFirstPass() error {
+	if v.fPass.pass != 1 {
+		return newError("TheoraEncoder.FinishFirstPass", C.OC_EINVAL)
+	}
+	v.fPass.pass = 0
+
+	sum, err := v.TwoPassOut()
+	if err != nil {
+		return err
+	}
+	if v.fPass.seeker != nil {
+		end, err := v.fPass.seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		_, err = v.fPass.seeker.Seek(v.fPass.outpos, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		_, err = v.fPass.seeker.Write(sum)
+		if err != nil {
+			return err
+		}
+		_, err = v.fPass.seeker.Seek(end, io.SeekStart)
+		return err
+	}
+	copy(v.fPass.mem, sum)
+	_, err = v.fPass.out.Write(v.fPass.mem)
+	v.fPass.mem = nil
+	return err
+}
+
+// StartSecondPass switches the encoder into the second pass mode.
+// The metrics collected by the first pass are read from stats frame
+// by frame. The encoder must be in the bitrate mode. Must be called
+// before the first frame
+func (v *TheoraEncoder) StartSecondPass(stats io.Reader) error {
+	if v.fFrames > 0 || v.fPass.pass != 0 {
+		return newError("TheoraEncoder.StartSecondPass", C.OC_EINVAL)
+	}
+	v.fPass = twoPassState{pass: 2, in: stats}
+	return v.feedSecondPass()
+}
+
+func (v *TheoraEncoder) feedSecondPass() error {
+	for {
+		need, err := v.TwoPassIn(nil)
+		if err != nil {
+			return err
+		}
+		if need == 0 {
+			return nil
+		}
+		if len(v.fPass.inbuf) < need {
+			v.fPass.inbuf = make([]byte, need)
+		}
+		_, err = io.ReadFull(v.fPass.in, v.fPass.inbuf[:need])
+		if err != nil {
+			return err
+		}
+		_, err = v.TwoPassIn(v.fPass.inbuf[:need])
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func encodeFramesFromSource(enc ITheoraEncoder, tc ITheoraComment, src ITheoraFrameSource) (int64, error) {
+	err := enc.SaveCustomHeadersToStream(tc)
+	if err != nil {
+		return 0, err
+	}
+	err = src.Rewind()
+	if err != nil {
+		return 0, err
+	}
+
+	var cnt int64
+	cur, err := src.NextFrame()
+	for err == nil {
+		next, nerr := src.NextFrame()
+		if nerr != nil && nerr != io.EOF {
+			return cnt, nerr
+		}
+		err = enc.SaveYUVBufferToStream(cur, nerr == io.EOF)
+		if err != nil {
+			return cnt, err
+		}
+		cnt++
+		cur, err = next, nerr
+	}
+	if err != io.EOF {
+		return cnt, err
+	}
+	return cnt, enc.Close()
+}
+
+// EncodeTwoPass encodes all the frames of src into str using
+// two-pass rate control. The first pass is made into memory. inf
+// must describe the frames of src. inf is not changed, the target
+// bitrate set according to target is the one of a copy
+func EncodeTwoPass(inf ITheoraInfo, tc ITheoraComment, src ITheoraFrameSource, str io.Writer, target TwoPassTarget) error {
+	inf, err := cloneTheoraInfo(inf)
+	if err != nil {
+		return err
+	}
+	defer inf.Close()
+	if target.Bitrate > 0 {
+		inf.SetTargetBitrate(target.Bitrate)
+	} else if inf.GetTargetBitrate() <= 0 {
+		inf.SetTargetBitrate(defTwoPassBitrate)
+	}
+
+	/* The first pass */
+
+	first, err := NewTheoraEncoder(inf, io.Discard)
+	if err != nil {
+		return err
+	}
+	defer first.Close()
+	stats := new(bytes.Buffer)
+	err = first.StartFirstPass(stats)
+	if err != nil {
+		return err
+	}
+	frames, err := encodeFramesFromSource(first, tc, src)
+	if err != nil {
+		return err
+	}
+
+	if target.Bitrate <= 0 && target.FileSize > 0 {
+		if frames == 0 || inf.GetFPSNumerator() == 0 {
+			return newError("EncodeTwoPass", C.OC_EINVAL)
+		}
+		duration := float64(frames) * float64(inf.GetFPSDenominator()) /
+			float64(inf.GetFPSNumerator())
+		inf.SetTargetBitrate(int(float64(target.FileSize*8) / duration))
+	}
+
+	/* The second pass */
+
+	second, err := NewTheoraEncoder(inf, str)
+	if err != nil {
+		return err
+	}
+	defer second.Close()
+	err = second.StartSecondPass(stats)
+	if err != nil {
+		return err
+	}
+	_, err = encodeFramesFromSource(second, tc, src)
+	return err
+}